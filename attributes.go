@@ -0,0 +1,329 @@
+package rbxfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// AttributesProperty is the name of the property in which the attributes of
+// an instance are serialized.
+const AttributesProperty = "AttributesSerialize"
+
+// Type IDs of attribute values.
+const (
+	attrString         byte = 0x02
+	attrBool           byte = 0x03
+	attrFloat          byte = 0x05
+	attrDouble         byte = 0x06
+	attrUDim           byte = 0x09
+	attrUDim2          byte = 0x0A
+	attrBrickColor     byte = 0x0E
+	attrColor3         byte = 0x0F
+	attrVector2        byte = 0x10
+	attrVector3        byte = 0x11
+	attrCFrame         byte = 0x14
+	attrNumberSequence byte = 0x17
+	attrColorSequence  byte = 0x19
+	attrNumberRange    byte = 0x1B
+	attrRect2D         byte = 0x1C
+)
+
+// Attributes decodes the attributes of the instance from the
+// AttributesSerialize property. The property may be a ValueBinaryString or a
+// ValueString. Returns an empty map if the property is not defined.
+//
+// Attributes support the following value types: String, Bool, Float, Double,
+// UDim, UDim2, BrickColor, Color3, Vector2, Vector3, CFrame, NumberSequence,
+// ColorSequence, NumberRange, and Rect2D. An error is returned if the
+// property contains an attribute of any other type, or if the property is
+// malformed.
+func (inst *Instance) Attributes() (map[string]Value, error) {
+	var b []byte
+	switch v := inst.Properties[AttributesProperty].(type) {
+	case nil:
+		return map[string]Value{}, nil
+	case ValueBinaryString:
+		b = v
+	case ValueString:
+		b = v
+	default:
+		return nil, fmt.Errorf("%s has unexpected type %s", AttributesProperty, v.Type())
+	}
+	return decodeAttributes(b)
+}
+
+// SetAttributes encodes attrs to the AttributesSerialize property of the
+// instance, as a ValueBinaryString. Attributes are written in order by name.
+// If attrs is empty, the property is deleted. If an attribute has an
+// unsupported type, an error is returned, and the property is left unchanged.
+func (inst *Instance) SetAttributes(attrs map[string]Value) error {
+	if len(attrs) == 0 {
+		delete(inst.Properties, AttributesProperty)
+		return nil
+	}
+	b, err := encodeAttributes(attrs)
+	if err != nil {
+		return err
+	}
+	inst.Properties[AttributesProperty] = ValueBinaryString(b)
+	return nil
+}
+
+// attrReader reads little-endian attribute data, retaining the first error.
+type attrReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *attrReader) read(data interface{}) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.LittleEndian, data)
+	}
+}
+
+func (r *attrReader) readString() string {
+	var n uint32
+	r.read(&n)
+	if r.err != nil {
+		return ""
+	}
+	if int64(n) > int64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	b := make([]byte, n)
+	r.read(b)
+	return string(b)
+}
+
+func (r *attrReader) readUDim() ValueUDim {
+	var v struct {
+		Scale  float32
+		Offset int32
+	}
+	r.read(&v)
+	if r.err == nil && (v.Offset < math.MinInt16 || v.Offset > math.MaxInt16) {
+		r.err = fmt.Errorf("UDim offset %d overflows int16", v.Offset)
+	}
+	return ValueUDim{Scale: v.Scale, Offset: int16(v.Offset)}
+}
+
+func decodeAttributes(b []byte) (attrs map[string]Value, err error) {
+	r := &attrReader{r: bytes.NewReader(b)}
+	var count uint32
+	r.read(&count)
+	attrs = make(map[string]Value)
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		name := r.readString()
+		var typ byte
+		r.read(&typ)
+		if r.err != nil {
+			break
+		}
+		var value Value
+		switch typ {
+		case attrString:
+			value = ValueString(r.readString())
+		case attrBool:
+			var v uint8
+			r.read(&v)
+			value = ValueBool(v != 0)
+		case attrFloat:
+			var v ValueFloat
+			r.read(&v)
+			value = v
+		case attrDouble:
+			var v ValueDouble
+			r.read(&v)
+			value = v
+		case attrUDim:
+			value = r.readUDim()
+		case attrUDim2:
+			value = ValueUDim2{X: r.readUDim(), Y: r.readUDim()}
+		case attrBrickColor:
+			var v ValueBrickColor
+			r.read(&v)
+			value = v
+		case attrColor3:
+			var v ValueColor3
+			r.read(&v)
+			value = v
+		case attrVector2:
+			var v ValueVector2
+			r.read(&v)
+			value = v
+		case attrVector3:
+			var v ValueVector3
+			r.read(&v)
+			value = v
+		case attrCFrame:
+			var v ValueCFrame
+			var id uint8
+			r.read(&v.Position)
+			r.read(&id)
+			if id == 0 {
+				r.read(&v.Rotation)
+			} else if v.Rotation, err = orientationMatrix(id); err != nil {
+				return nil, fmt.Errorf("attribute %q: %s", name, err)
+			}
+			value = v
+		case attrNumberSequence:
+			var n uint32
+			r.read(&n)
+			if int64(n)*12 > int64(r.r.Len()) {
+				r.err = io.ErrUnexpectedEOF
+				break
+			}
+			v := make(ValueNumberSequence, n)
+			for i := range v {
+				r.read(&v[i].Envelope)
+				r.read(&v[i].Time)
+				r.read(&v[i].Value)
+			}
+			value = v
+		case attrColorSequence:
+			var n uint32
+			r.read(&n)
+			if int64(n)*20 > int64(r.r.Len()) {
+				r.err = io.ErrUnexpectedEOF
+				break
+			}
+			v := make(ValueColorSequence, n)
+			for i := range v {
+				r.read(&v[i].Envelope)
+				r.read(&v[i].Time)
+				r.read(&v[i].Value)
+			}
+			value = v
+		case attrNumberRange:
+			var v ValueNumberRange
+			r.read(&v)
+			value = v
+		case attrRect2D:
+			var v ValueRect2D
+			r.read(&v)
+			value = v
+		default:
+			return nil, fmt.Errorf("attribute %q has unsupported type 0x%02X", name, typ)
+		}
+		attrs[name] = value
+	}
+	if r.err != nil {
+		if r.err == io.EOF {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("malformed attributes: %s", r.err)
+	}
+	return attrs, nil
+}
+
+// orientationMatrix returns the rotation matrix of an axis-aligned
+// orientation ID, as used by the binary format.
+func orientationMatrix(id uint8) (m [9]float32, err error) {
+	normals := [6][3]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {-1, 0, 0}, {0, -1, 0}, {0, 0, -1}}
+	if id < 1 || id > 36 {
+		return m, fmt.Errorf("invalid orientation %d", id)
+	}
+	x, y := normals[(id-1)/6], normals[(id-1)%6]
+	if x[0]*y[0]+x[1]*y[1]+x[2]*y[2] != 0 {
+		return m, fmt.Errorf("invalid orientation %d", id)
+	}
+	z := [3]float32{x[1]*y[2] - x[2]*y[1], x[2]*y[0] - x[0]*y[2], x[0]*y[1] - x[1]*y[0]}
+	for i := 0; i < 3; i++ {
+		m[i*3+0], m[i*3+1], m[i*3+2] = x[i], y[i], z[i]
+	}
+	return m, nil
+}
+
+func encodeAttributes(attrs map[string]Value) ([]byte, error) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	write := func(data interface{}) {
+		// Writes to a bytes.Buffer do not fail.
+		binary.Write(&buf, binary.LittleEndian, data)
+	}
+	writeString := func(s []byte) {
+		write(uint32(len(s)))
+		buf.Write(s)
+	}
+	writeUDim := func(v ValueUDim) {
+		write(v.Scale)
+		write(int32(v.Offset))
+	}
+
+	write(uint32(len(names)))
+	for _, name := range names {
+		writeString([]byte(name))
+		switch v := attrs[name].(type) {
+		case ValueString:
+			buf.WriteByte(attrString)
+			writeString(v)
+		case ValueBool:
+			buf.WriteByte(attrBool)
+			write(v)
+		case ValueFloat:
+			buf.WriteByte(attrFloat)
+			write(v)
+		case ValueDouble:
+			buf.WriteByte(attrDouble)
+			write(v)
+		case ValueUDim:
+			buf.WriteByte(attrUDim)
+			writeUDim(v)
+		case ValueUDim2:
+			buf.WriteByte(attrUDim2)
+			writeUDim(v.X)
+			writeUDim(v.Y)
+		case ValueBrickColor:
+			buf.WriteByte(attrBrickColor)
+			write(v)
+		case ValueColor3:
+			buf.WriteByte(attrColor3)
+			write(v)
+		case ValueVector2:
+			buf.WriteByte(attrVector2)
+			write(v)
+		case ValueVector3:
+			buf.WriteByte(attrVector3)
+			write(v)
+		case ValueCFrame:
+			// Rotation is always written in full.
+			buf.WriteByte(attrCFrame)
+			write(v.Position)
+			buf.WriteByte(0)
+			write(v.Rotation)
+		case ValueNumberSequence:
+			buf.WriteByte(attrNumberSequence)
+			write(uint32(len(v)))
+			for _, k := range v {
+				write([3]float32{k.Envelope, k.Time, k.Value})
+			}
+		case ValueColorSequence:
+			buf.WriteByte(attrColorSequence)
+			write(uint32(len(v)))
+			for _, k := range v {
+				write([5]float32{k.Envelope, k.Time, k.Value.R, k.Value.G, k.Value.B})
+			}
+		case ValueNumberRange:
+			buf.WriteByte(attrNumberRange)
+			write(v)
+		case ValueRect2D:
+			buf.WriteByte(attrRect2D)
+			write(v)
+		case nil:
+			return nil, fmt.Errorf("attribute %q is nil", name)
+		default:
+			return nil, fmt.Errorf("attribute %q has unsupported type %s", name, v.Type())
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package rbxfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// attrBytes builds little-endian attribute data from strings, bytes, and
+// fixed-size numbers.
+func attrBytes(values ...interface{}) ValueBinaryString {
+	var buf bytes.Buffer
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			binary.Write(&buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		default:
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+	return ValueBinaryString(buf.Bytes())
+}
+
+func TestInstance_Attributes(t *testing.T) {
+	// Hand-built attribute data, following the layout and order used by
+	// Studio.
+	inst := NewInstance("Part", nil)
+	inst.Set(AttributesProperty, attrBytes(
+		uint32(4),
+		"Speed", uint8(0x06), float64(16),
+		"Label", uint8(0x02), "Hi",
+		"Enabled", uint8(0x03), uint8(1),
+		"Spawn", uint8(0x14), [3]float32{1, 2, 3}, uint8(0x03),
+	))
+	attrs, err := inst.Attributes()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]Value{
+		"Speed":   ValueDouble(16),
+		"Label":   ValueString("Hi"),
+		"Enabled": ValueBool(true),
+		"Spawn": ValueCFrame{
+			Position: ValueVector3{X: 1, Y: 2, Z: 3},
+			Rotation: [9]float32{1, 0, 0, 0, 0, -1, 0, 1, 0},
+		},
+	}
+	if !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("unexpected attributes:\n\texpected: %v\n\tgot:      %v", expected, attrs)
+	}
+
+	// Round-trip every supported type.
+	expected = map[string]Value{
+		"String":     ValueString("text"),
+		"Bool":       ValueBool(false),
+		"Float":      ValueFloat(0.5),
+		"Double":     ValueDouble(math.Pi),
+		"UDim":       ValueUDim{Scale: 0.5, Offset: -10},
+		"UDim2":      ValueUDim2{X: ValueUDim{Scale: 1, Offset: 2}, Y: ValueUDim{Scale: 3, Offset: 4}},
+		"BrickColor": ValueBrickColor(194),
+		"Color3":     ValueColor3{R: 1, G: 0.5, B: 0.25},
+		"Vector2":    ValueVector2{X: 1, Y: 2},
+		"Vector3":    ValueVector3{X: 1, Y: 2, Z: 3},
+		"CFrame":     ValueCFrame{Position: ValueVector3{X: 4}, Rotation: [9]float32{0, 0, 1, 0, 1, 0, -1, 0, 0}},
+		"NumberSequence": ValueNumberSequence{
+			{Time: 0, Value: 1, Envelope: 0.5},
+			{Time: 1, Value: 0, Envelope: 0},
+		},
+		"ColorSequence": ValueColorSequence{
+			{Time: 0, Value: ValueColor3{R: 1}},
+			{Time: 1, Value: ValueColor3{B: 1}},
+		},
+		"NumberRange": ValueNumberRange{Min: 1, Max: 2},
+		"Rect2D":      ValueRect2D{Min: ValueVector2{X: 1, Y: 2}, Max: ValueVector2{X: 3, Y: 4}},
+	}
+	if err := inst.SetAttributes(expected); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := inst.Get(AttributesProperty).(ValueBinaryString); !ok {
+		t.Fatalf("expected BinaryString property, got %#v", inst.Get(AttributesProperty))
+	}
+	if attrs, err := inst.Attributes(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("unexpected attributes:\n\texpected: %v\n\tgot:      %v", expected, attrs)
+	}
+
+	// Unsupported types leave the property unchanged.
+	prev := inst.Get(AttributesProperty)
+	if err := inst.SetAttributes(map[string]Value{"Ref": ValueReference{}}); err == nil {
+		t.Errorf("expected error for unsupported type")
+	}
+	if !reflect.DeepEqual(inst.Get(AttributesProperty), prev) {
+		t.Errorf("expected property to be unchanged")
+	}
+
+	// An empty map clears the property.
+	if err := inst.SetAttributes(map[string]Value{}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, ok := inst.Properties[AttributesProperty]; ok {
+		t.Errorf("expected property to be deleted")
+	}
+	if attrs, err := inst.Attributes(); err != nil || len(attrs) != 0 {
+		t.Errorf("expected no attributes, got %v, %v", attrs, err)
+	}
+
+	// Malformed data.
+	for _, b := range []ValueBinaryString{
+		attrBytes(uint32(1), "Speed", uint8(0x06)),
+		attrBytes(uint32(2), "Speed", uint8(0x06), float64(16)),
+		attrBytes(uint32(1), "Label", uint8(0x02), uint32(100)),
+		attrBytes(uint32(1), "Unknown", uint8(0xFF)),
+	} {
+		inst.Set(AttributesProperty, b)
+		if _, err := inst.Attributes(); err == nil {
+			t.Errorf("expected error for % 02x", b)
+		}
+	}
+}
@@ -16,6 +16,56 @@ const (
 	ModeModel             // Data is decoded and encoded as a Roblox model (RBXM) file.
 )
 
+// placeServices is a set of class names of services that commonly appear at
+// the top level of a place.
+var placeServices = map[string]bool{
+	"Workspace":           true,
+	"Lighting":            true,
+	"Players":             true,
+	"ReplicatedFirst":     true,
+	"ReplicatedStorage":   true,
+	"ServerScriptService": true,
+	"ServerStorage":       true,
+	"SoundService":        true,
+	"StarterGui":          true,
+	"StarterPack":         true,
+	"StarterPlayer":       true,
+	"Teams":               true,
+	"Chat":                true,
+}
+
+// DetectMode guesses whether root represents a place or a model, by
+// inspecting its top-level instances. If any top-level instance is a service,
+// either because IsService is set or because it has the class of a common
+// service such as Workspace, then ModePlace is returned. Otherwise,
+// ModeModel is returned.
+//
+// ok is false if the guess has low confidence: when root has no instances,
+// or when it has both services and non-service instances at the top level.
+func DetectMode(root *rbxfile.Root) (mode Mode, ok bool) {
+	if root == nil {
+		return ModeModel, false
+	}
+	var services, others int
+	for _, inst := range root.Instances {
+		if inst == nil {
+			continue
+		}
+		if inst.IsService || placeServices[inst.ClassName] {
+			services++
+		} else {
+			others++
+		}
+	}
+	switch {
+	case services > 0:
+		return ModePlace, others == 0
+	case others > 0:
+		return ModeModel, true
+	}
+	return ModeModel, false
+}
+
 // RobloxCodec implements Decoder and Encoder to emulate Roblox's internal
 // codec as closely as possible.
 type RobloxCodec struct {
@@ -41,20 +91,68 @@ type RobloxCodec struct {
 	// generally preferred to set ExcludeInvalidAPI to false, so that false
 	// negatives do not lead to lost data.
 	ExcludeInvalidAPI bool
+
+	// OrthonormalizeCFrames determines whether the rotation of each decoded
+	// CFrame is made orthonormal with ValueCFrame.Orthonormalize.
+	OrthonormalizeCFrames bool
+
+	// MaxInstances is the maximum number of instances that may be decoded.
+	// If decoding a model would produce more instances, then decoding is
+	// aborted, and an rbxfile.ErrMaxInstances is returned. If zero or less,
+	// the number of instances is not limited.
+	MaxInstances int
+
+	// InstanceIDs, if not nil, receives the original ID of each instance
+	// when decoding, as it appears in the InstanceIDs of a ChunkInstance,
+	// and the Children and Parents of a ChunkParent. This allows decoded
+	// instances to be correlated with the chunks of the model. Existing
+	// entries are not cleared.
+	InstanceIDs map[*rbxfile.Instance]int32
 }
 
 //go:generate rbxpipe -i=cframegen.lua -o=cframe.go -place=cframe.rbxl -filter=o
 
 func (c RobloxCodec) Decode(model *FormatModel) (root *rbxfile.Root, err error) {
+	root = new(rbxfile.Root)
+	if err = c.decode(model, root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// DecodeInto decodes model into a caller-provided root, rather than
+// allocating a new one. The root is cleared first, and the capacity of its
+// Instances slice is reused. This reduces allocations when many models are
+// decoded in succession. If an error is returned, the root may be partially
+// populated.
+func (c RobloxCodec) DecodeInto(model *FormatModel, root *rbxfile.Root) error {
+	if root == nil {
+		return fmt.Errorf("Root is nil")
+	}
+	for i := range root.Instances {
+		root.Instances[i] = nil
+	}
+	root.Instances = root.Instances[:0]
+	return c.decode(model, root)
+}
+
+func (c RobloxCodec) decode(model *FormatModel, root *rbxfile.Root) (err error) {
 	if model == nil {
-		return nil, fmt.Errorf("FormatModel is nil")
+		return fmt.Errorf("FormatModel is nil")
 	}
 	model.Warnings = model.Warnings[:0]
 
-	root = new(rbxfile.Root)
-
-	groupLookup := make(map[int32]*ChunkInstance, model.TypeCount)
-	instLookup := make(map[int32]*rbxfile.Instance, model.InstanceCount+1)
+	// Size hints are taken from the chunks rather than the header, which may
+	// be malformed.
+	var typeCount, instCount int
+	for _, chunk := range model.Chunks {
+		if chunk, ok := chunk.(*ChunkInstance); ok {
+			typeCount++
+			instCount += len(chunk.InstanceIDs)
+		}
+	}
+	groupLookup := make(map[int32]*ChunkInstance, typeCount)
+	instLookup := make(map[int32]*rbxfile.Instance, instCount+1)
 	instLookup[-1] = nil
 
 	propTypes := map[string]map[string]string{}
@@ -131,6 +229,11 @@ loop:
 				goto chunkErr
 			}
 
+			// Checked before any instances of the chunk are created.
+			if c.MaxInstances > 0 && len(instLookup)-1+len(chunk.InstanceIDs) > c.MaxInstances {
+				return rbxfile.ErrMaxInstances{Max: c.MaxInstances}
+			}
+
 			for i, ref := range chunk.InstanceIDs {
 				if ref < 0 || uint32(ref) >= model.InstanceCount {
 					err = fmt.Errorf("invalid id %d", ref)
@@ -149,6 +252,9 @@ loop:
 				}
 
 				instLookup[ref] = inst
+				if c.InstanceIDs != nil {
+					c.InstanceIDs[inst] = ref
+				}
 			}
 
 			if _, ok := groupLookup[chunk.TypeID]; ok {
@@ -171,9 +277,15 @@ loop:
 				continue
 			}
 
-			if len(chunk.Properties) != len(instChunk.InstanceIDs) {
-				err = fmt.Errorf("length of properties array (%d) does not equal length of type array (%d)", len(chunk.Properties), len(instChunk.InstanceIDs))
-				goto chunkErr
+			// A mismatched array is truncated to the length of the group.
+			// Instances without a corresponding value do not receive the
+			// property.
+			values := chunk.Properties
+			if len(values) != len(instChunk.InstanceIDs) {
+				addWarn("length of properties array (%d) does not equal length of type array (%d)", len(values), len(instChunk.InstanceIDs))
+				if len(values) > len(instChunk.InstanceIDs) {
+					values = values[:len(instChunk.InstanceIDs)]
+				}
 			}
 
 			var propType string
@@ -187,7 +299,7 @@ loop:
 				}
 			}
 
-			for i, bvalue := range chunk.Properties {
+			for i, bvalue := range values {
 				// If the value type is an enum, then verify that the value is
 				// correct for the enum.
 				if c.API != nil && bvalue.Type() == TypeToken {
@@ -203,7 +315,11 @@ loop:
 				}
 
 				inst := instLookup[instChunk.InstanceIDs[i]]
-				inst.Properties[chunk.PropertyName] = decodeValue(propType, instLookup, bvalue)
+				value := decodeValue(propType, instLookup, bvalue)
+				if cf, ok := value.(rbxfile.ValueCFrame); ok && c.OrthonormalizeCFrames {
+					value = cf.Orthonormalize()
+				}
+				inst.Properties[chunk.PropertyName] = value
 			}
 
 		case *ChunkParent:
@@ -257,7 +373,7 @@ loop:
 
 chunkErr:
 	err = fmt.Errorf("%s chunk (#%d): %s", chunkType, chunkNum, err)
-	return nil, err
+	return err
 }
 
 // Decode a bin.value to a rbxfile.Value based on a given value type.
@@ -442,11 +558,42 @@ func decodeValue(valueType string, refs map[int32]*rbxfile.Instance, bvalue Valu
 			G: bvalue.G,
 			B: bvalue.B,
 		}
+
+	case *ValueInt64:
+		value = rbxfile.ValueInt64(*bvalue)
+
+	case *ValueUniqueId:
+		value = rbxfile.ValueUniqueId{
+			Random: bvalue.Random,
+			Time:   bvalue.Time,
+			Index:  bvalue.Index,
+		}
+
+	case *ValueFont:
+		v := rbxfile.ValueFont{
+			Family:       make(rbxfile.ValueContent, len(bvalue.Family)),
+			Weight:       bvalue.Weight,
+			Style:        rbxfile.FontStyle(bvalue.Style),
+			CachedFaceId: make(rbxfile.ValueContent, len(bvalue.CachedFaceId)),
+		}
+		copy(v.Family, bvalue.Family)
+		copy(v.CachedFaceId, bvalue.CachedFaceId)
+		value = v
 	}
 
 	return
 }
 
+// Encode encodes root into a FormatModel. The output depends only on the
+// content of root, so encoding the same tree always produces the same
+// chunks, in the following order:
+//
+//   - An instance chunk for each class, ordered by ClassName. Instances are
+//     referred to by their position in a depth-first traversal of root.
+//   - For each instance chunk, a property chunk for each property, ordered
+//     by PropertyName.
+//   - A parent chunk.
+//   - An end chunk.
 func (c RobloxCodec) Encode(root *rbxfile.Root) (model *FormatModel, err error) {
 	if root == nil {
 		return nil, errors.New("Root is nil")
@@ -497,6 +644,23 @@ func (c RobloxCodec) Encode(root *rbxfile.Root) (model *FormatModel, err error)
 		addInstance(inst)
 	}
 
+	// Maps the Reference of each instance to the instance, for resolving
+	// references that have only a referent string. The first instance with
+	// a given Reference is used.
+	var lazyRefs rbxfile.References
+	resolveLazy := func(value rbxfile.ValueReference) (rbxfile.ValueReference, bool) {
+		if lazyRefs == nil {
+			lazyRefs = make(rbxfile.References, len(instList))
+			for _, inst := range instList {
+				if !rbxfile.IsEmptyReference(inst.Reference) && lazyRefs[inst.Reference] == nil {
+					lazyRefs[inst.Reference] = inst
+				}
+			}
+		}
+		referent := lazyRefs[value.Reference]
+		return rbxfile.ValueReference{Instance: referent}, referent != nil
+	}
+
 	// Group instances of the same ClassName into single chunks.
 	instChunkMap := map[string]*ChunkInstance{}
 	for ref, inst := range instList {
@@ -657,10 +821,24 @@ func (c RobloxCodec) Encode(root *rbxfile.Root) (model *FormatModel, err error)
 			}
 		}
 
+		// Sort the chunks by PropertyName. Chunks are processed in this
+		// order, so that warnings are also emitted in a consistent order.
+		propChunks := make(sortPropChunks, len(propChunkMap))
+		if len(propChunkMap) > 0 {
+			i := 0
+			for _, chunk := range propChunkMap {
+				propChunks[i] = chunk
+				i++
+			}
+
+			sort.Sort(propChunks)
+		}
+
 		if propAPI != nil && !c.ExcludeInvalidAPI {
 			// Check to see if all existing properties types match. If they
 			// do, prefer those types over the API's type.
-			for name, propChunk := range propChunkMap {
+			for _, propChunk := range propChunks {
+				name := propChunk.PropertyName
 				var instRef int32 = -1
 				dataType := rbxfile.TypeInvalid
 				matches := true
@@ -696,12 +874,18 @@ func (c RobloxCodec) Encode(root *rbxfile.Root) (model *FormatModel, err error)
 		}
 
 		// Set the values for each property chunk.
-		for name, propChunk := range propChunkMap {
+		for _, propChunk := range propChunks {
+			name := propChunk.PropertyName
 			for i, ref := range instChunk.InstanceIDs {
 				inst := instList[ref]
 
 				var bvalue Value
 				if value, ok := inst.Properties[name]; ok {
+					if v, ok := value.(rbxfile.ValueReference); ok && v.Instance == nil && v.Reference != "" {
+						if value, ok = resolveLazy(v); !ok {
+							addWarn("unresolved reference `%s` in instance #%d (%s.%s)", v.Reference, ref, inst.ClassName, name)
+						}
+					}
 					bvalue = encodeValue(refs, value)
 				}
 
@@ -731,18 +915,6 @@ func (c RobloxCodec) Encode(root *rbxfile.Root) (model *FormatModel, err error)
 			}
 		}
 
-		// Sort the chunks by PropertyName.
-		propChunks := make(sortPropChunks, len(propChunkMap))
-		if len(propChunkMap) > 0 {
-			i := 0
-			for _, chunk := range propChunkMap {
-				propChunks[i] = chunk
-				i++
-			}
-
-			sort.Sort(propChunks)
-		}
-
 		propChunkList = append(propChunkList, propChunks...)
 	}
 
@@ -807,6 +979,158 @@ func (c RobloxCodec) Encode(root *rbxfile.Root) (model *FormatModel, err error)
 	return
 }
 
+// AddInstance adds inst to the model as a child of the instance identified
+// by parent, without decoding or re-encoding the rest of the model. If parent
+// is -1, then the instance is added to the top level. Returns the ID of the
+// added instance, which may be used as the parent of subsequent instances.
+//
+// The instance is added to the instance group of its ClassName, which is
+// created if it does not exist. Each property of the instance is added to the
+// property chunk of the group with the same name, and property chunks are
+// created as needed. Instances in the group that do not have a property are
+// given the zero value of the property's type. A link from the instance to
+// its parent is added to the parent chunk.
+//
+// Only inst itself is added; its children are ignored. Because instances
+// outside of the model are not known, each ValueReference property is
+// written as nil, unless it refers to inst. An error is returned, and the
+// model is unchanged, if parent is not a valid ID, or if a property has an
+// unsupported type or does not match the type of an existing property chunk.
+//
+// Chunk sizes returned by ChunkSize are reset, since the chunks of the model
+// are changed.
+func (f *FormatModel) AddInstance(inst *rbxfile.Instance, parent int32) (id int32, err error) {
+	if inst == nil {
+		return -1, fmt.Errorf("instance is nil")
+	}
+	id = int32(f.InstanceCount)
+	if parent < -1 || parent >= id {
+		return -1, fmt.Errorf("invalid parent id %d", parent)
+	}
+
+	// Find the instance group of the class, and the first chunk after the
+	// instance chunks and property chunks.
+	var instChunk *ChunkInstance
+	propChunks := map[string]*ChunkProperty{}
+	lastInst, end := -1, len(f.Chunks)
+	for i, chunk := range f.Chunks {
+		if chunk, ok := chunk.(*ChunkInstance); ok {
+			lastInst = i
+			if chunk.ClassName == inst.ClassName {
+				instChunk = chunk
+			}
+		}
+	}
+	for i, chunk := range f.Chunks {
+		switch chunk := chunk.(type) {
+		case *ChunkProperty:
+			if instChunk != nil && chunk.TypeID == instChunk.TypeID {
+				propChunks[chunk.PropertyName] = chunk
+			}
+		case *ChunkParent, *ChunkEnd:
+			if i < end {
+				end = i
+			}
+		}
+	}
+
+	// Encode each property before changing the model.
+	refs := map[*rbxfile.Instance]int{nil: -1, inst: int(id)}
+	values := make(map[string]Value, len(inst.Properties))
+	for name, value := range inst.Properties {
+		bval := encodeValue(refs, value)
+		if bval == nil {
+			return -1, fmt.Errorf("property %s.`%s` has unsupported type %s", inst.ClassName, name, value.Type())
+		}
+		if chunk, ok := propChunks[name]; ok && chunk.DataType != bval.Type() {
+			return -1, fmt.Errorf("property %s.`%s` has type %s, expected %s", inst.ClassName, name, bval.Type(), chunk.DataType)
+		}
+		values[name] = bval
+	}
+
+	// Insert chunks before the chunk at index i.
+	insert := func(i int, chunks ...Chunk) {
+		f.Chunks = append(f.Chunks[:i], append(chunks, f.Chunks[i:]...)...)
+	}
+
+	if instChunk == nil {
+		instChunk = &ChunkInstance{
+			IsCompressed: true,
+			TypeID:       int32(f.TypeCount),
+			ClassName:    inst.ClassName,
+			InstanceIDs:  []int32{},
+		}
+		f.TypeCount++
+		insert(lastInst+1, instChunk)
+		end++
+	}
+	count := len(instChunk.InstanceIDs)
+	if inst.IsService && !instChunk.IsService {
+		instChunk.IsService = true
+		instChunk.GetService = make([]byte, count)
+	}
+	instChunk.InstanceIDs = append(instChunk.InstanceIDs, id)
+	if instChunk.IsService {
+		if inst.IsService {
+			instChunk.GetService = append(instChunk.GetService, 1)
+		} else {
+			instChunk.GetService = append(instChunk.GetService, 0)
+		}
+	}
+	f.InstanceCount++
+
+	for name, chunk := range propChunks {
+		value, ok := values[name]
+		if !ok {
+			value = NewValue(chunk.DataType)
+		}
+		chunk.Properties = append(chunk.Properties, value)
+	}
+
+	// Sort new properties, so that chunks are added in a consistent order.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if _, ok := propChunks[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := values[name]
+		chunk := &ChunkProperty{
+			IsCompressed: true,
+			TypeID:       instChunk.TypeID,
+			PropertyName: name,
+			DataType:     value.Type(),
+			Properties:   make([]Value, count+1),
+		}
+		for i := 0; i < count; i++ {
+			chunk.Properties[i] = NewValue(value.Type())
+		}
+		chunk.Properties[count] = value
+		insert(end, chunk)
+		end++
+	}
+
+	var parentChunk *ChunkParent
+	for _, chunk := range f.Chunks {
+		if chunk, ok := chunk.(*ChunkParent); ok {
+			parentChunk = chunk
+			break
+		}
+	}
+	if parentChunk == nil {
+		parentChunk = &ChunkParent{IsCompressed: true}
+		insert(end, parentChunk)
+	}
+	parentChunk.Children = append(parentChunk.Children, id)
+	parentChunk.Parents = append(parentChunk.Parents, parent)
+
+	f.chunkSizes = f.chunkSizes[:0]
+	f.chunkKnown = f.chunkKnown[:0]
+	return id, nil
+}
+
 type sortInstChunks []*ChunkInstance
 
 func (c sortInstChunks) Len() int {
@@ -1024,6 +1348,27 @@ func encodeValue(refs map[*rbxfile.Instance]int, value rbxfile.Value) (bvalue Va
 			G: value.G,
 			B: value.B,
 		}
+
+	case rbxfile.ValueInt64:
+		bvalue = (*ValueInt64)(&value)
+
+	case rbxfile.ValueUniqueId:
+		bvalue = &ValueUniqueId{
+			Random: value.Random,
+			Time:   value.Time,
+			Index:  value.Index,
+		}
+
+	case rbxfile.ValueFont:
+		v := ValueFont{
+			Family:       make(ValueString, len(value.Family)),
+			Weight:       value.Weight,
+			Style:        uint8(value.Style),
+			CachedFaceId: make(ValueString, len(value.CachedFaceId)),
+		}
+		copy(v.Family, value.Family)
+		copy(v.CachedFaceId, value.CachedFaceId)
+		bvalue = &v
 	}
 
 	return
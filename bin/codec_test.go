@@ -0,0 +1,516 @@
+package bin
+
+import (
+	"bytes"
+	"github.com/robloxapi/rbxfile"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// encodeTestModel returns a small model containing several top-level parts.
+func encodeTestModel(tb testing.TB) *FormatModel {
+	root := new(rbxfile.Root)
+	for i := 0; i < 8; i++ {
+		inst := rbxfile.NewInstance("Part", nil)
+		inst.SetName("Part")
+		inst.Set("Anchored", rbxfile.ValueBool(true))
+		inst.Set("Size", rbxfile.ValueVector3{X: 4, Y: 1, Z: 2})
+		rbxfile.NewInstance("Decal", inst)
+		root.Instances = append(root.Instances, inst)
+	}
+	model, err := RobloxCodec{Mode: ModeModel}.Encode(root)
+	if err != nil {
+		tb.Fatalf("failed to encode model: %s", err)
+	}
+	return model
+}
+
+func TestRobloxCodec_DecodeInto(t *testing.T) {
+	model := encodeTestModel(t)
+	stale := rbxfile.NewInstance("Model", nil)
+	root := &rbxfile.Root{Instances: make([]*rbxfile.Instance, 1, 16)}
+	root.Instances[0] = stale
+	backing := &root.Instances[:1][0]
+
+	if err := (RobloxCodec{}).DecodeInto(model, root); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(root.Instances) != 8 {
+		t.Fatalf("expected 8 instances, got %d", len(root.Instances))
+	}
+	if &root.Instances[0] != backing {
+		t.Errorf("expected Instances slice to be reused")
+	}
+	for _, inst := range root.Instances {
+		if inst == stale || inst.ClassName != "Part" || len(inst.Children) != 1 {
+			t.Errorf("unexpected instance %v", inst)
+		}
+	}
+
+	if err := (RobloxCodec{}).DecodeInto(model, nil); err == nil {
+		t.Errorf("expected error for nil root")
+	}
+}
+
+func TestRobloxCodec_MaxInstances(t *testing.T) {
+	model := encodeTestModel(t)
+	if _, err := (RobloxCodec{MaxInstances: 16}).Decode(model); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, max := range []int{15, 4} {
+		root, err := RobloxCodec{MaxInstances: max}.Decode(model)
+		if err != (rbxfile.ErrMaxInstances{Max: max}) {
+			t.Errorf("max %d: expected ErrMaxInstances, got %v", max, err)
+		}
+		if root != nil {
+			t.Errorf("max %d: expected nil root", max)
+		}
+	}
+}
+
+func TestRobloxCodec_EncodeLazyReferences(t *testing.T) {
+	a := rbxfile.NewInstance("ObjectValue", nil)
+	a.Reference = "RBX0"
+	a.Set("Value", rbxfile.ValueReference{Reference: "RBX1"})
+	b := rbxfile.NewInstance("ObjectValue", nil)
+	b.Reference = "RBX1"
+	b.Set("Value", rbxfile.ValueReference{Reference: "RBXMissing"})
+
+	model, err := RobloxCodec{Mode: ModeModel}.Encode(&rbxfile.Root{Instances: []*rbxfile.Instance{a, b}})
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	expected := "instance chunk #0: unresolved reference `RBXMissing` in instance #1 (ObjectValue.Value)"
+	if len(model.Warnings) != 1 || model.Warnings[0].Error() != expected {
+		t.Errorf("expected unresolved reference warning, got %v", model.Warnings)
+	}
+
+	root, err := RobloxCodec{}.Decode(model)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	a, b = root.Instances[0], root.Instances[1]
+	if v := a.Get("Value").(rbxfile.ValueReference); v.Instance != b {
+		t.Errorf("expected lazy reference to be resolved")
+	}
+	if v := b.Get("Value").(rbxfile.ValueReference); v.Instance != nil {
+		t.Errorf("expected unresolved reference to be nil")
+	}
+}
+
+func TestRobloxCodec_OrthonormalizeCFrames(t *testing.T) {
+	inst := rbxfile.NewInstance("CFrameValue", nil)
+	inst.Set("Value", rbxfile.ValueCFrame{
+		Rotation: [9]float32{1.01, 0, 0, 0, 0.99, 0, 0, 0, 1},
+	})
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}
+
+	for _, orthonormalize := range []bool{false, true} {
+		model, err := RobloxCodec{Mode: ModeModel}.Encode(root)
+		if err != nil {
+			t.Fatalf("failed to encode: %s", err)
+		}
+		decoded, err := RobloxCodec{OrthonormalizeCFrames: orthonormalize}.Decode(model)
+		if err != nil {
+			t.Fatalf("failed to decode: %s", err)
+		}
+		v := decoded.Instances[0].Properties["Value"].(rbxfile.ValueCFrame)
+		if orthonormalize && v.Rotation != [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1} {
+			t.Errorf("expected orthonormal rotation, got %v", v.Rotation)
+		} else if !orthonormalize && v.Rotation[0] != 1.01 {
+			t.Errorf("expected rotation to be unchanged, got %v", v.Rotation)
+		}
+	}
+}
+
+func TestRobloxCodec_DecodePropertyCountMismatch(t *testing.T) {
+	model := encodeTestModel(t)
+	for _, chunk := range model.Chunks {
+		chunk, ok := chunk.(*ChunkProperty)
+		if !ok {
+			continue
+		}
+		switch chunk.PropertyName {
+		case "Anchored":
+			chunk.Properties = chunk.Properties[:5]
+		case "Size":
+			chunk.Properties = append(chunk.Properties, chunk.Properties[:2]...)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := model.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+	model = new(FormatModel)
+	if _, err := model.ReadFrom(&buf); err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+
+	root, err := RobloxCodec{}.Decode(model)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(root.Instances) != 8 {
+		t.Fatalf("expected 8 instances, got %d", len(root.Instances))
+	}
+	for i, inst := range root.Instances {
+		if _, ok := inst.Properties["Anchored"]; ok != (i < 5) {
+			t.Errorf("instance %d: unexpected presence of Anchored: %t", i, ok)
+		}
+		if v := inst.Get("Size"); v != (rbxfile.ValueVector3{X: 4, Y: 1, Z: 2}) {
+			t.Errorf("instance %d: unexpected Size %#v", i, v)
+		}
+	}
+	if len(model.Warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %v", model.Warnings)
+	}
+}
+
+func TestRobloxCodec_InstanceIDs(t *testing.T) {
+	model := encodeTestModel(t)
+	ids := map[*rbxfile.Instance]int32{}
+	root, err := RobloxCodec{InstanceIDs: ids}.Decode(model)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(ids) != int(model.InstanceCount) {
+		t.Fatalf("expected %d IDs, got %d", model.InstanceCount, len(ids))
+	}
+
+	var parent *ChunkParent
+	for _, chunk := range model.Chunks {
+		if chunk, ok := chunk.(*ChunkParent); ok {
+			parent = chunk
+		}
+	}
+	if parent == nil {
+		t.Fatalf("missing parent chunk")
+	}
+	instances := map[int32]*rbxfile.Instance{}
+	for inst, id := range ids {
+		instances[id] = inst
+	}
+	for i, child := range parent.Children {
+		inst := instances[child]
+		if inst == nil {
+			t.Fatalf("child %d: no instance with ID %d", i, child)
+		}
+		if parent.Parents[i] == -1 {
+			if inst.Parent() != nil {
+				t.Errorf("child %d: expected no parent", i)
+			}
+		} else if inst.Parent() != instances[parent.Parents[i]] {
+			t.Errorf("child %d: expected parent with ID %d", i, parent.Parents[i])
+		}
+	}
+	for i, inst := range root.Instances {
+		if _, ok := ids[inst]; !ok {
+			t.Errorf("instance %d: missing ID", i)
+		}
+	}
+}
+
+func TestFormatModel_AddInstance(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := encodeTestModel(t).WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write model: %s", err)
+	}
+	model := new(FormatModel)
+	if _, err := model.ReadFrom(&buf); err != nil {
+		t.Fatalf("failed to read model: %s", err)
+	}
+	ids := map[*rbxfile.Instance]int32{}
+	root, err := RobloxCodec{InstanceIDs: ids}.Decode(model)
+	if err != nil {
+		t.Fatalf("failed to decode model: %s", err)
+	}
+
+	// Existing class with a new property.
+	part := rbxfile.NewInstance("Part", nil)
+	part.SetName("Added")
+	part.Set("Transparency", rbxfile.ValueFloat(0.5))
+	partID, err := model.AddInstance(part, ids[root.Instances[0]])
+	if err != nil {
+		t.Fatalf("failed to add part: %s", err)
+	}
+	// New class, as child of the added instance.
+	folder := rbxfile.NewInstance("Folder", nil)
+	folder.SetName("Folder")
+	if _, err := model.AddInstance(folder, partID); err != nil {
+		t.Fatalf("failed to add folder: %s", err)
+	}
+
+	bad := rbxfile.NewInstance("Part", nil)
+	bad.Set("Anchored", rbxfile.ValueInt(1))
+	if _, err := model.AddInstance(bad, -1); err == nil {
+		t.Errorf("expected error for mismatched property type")
+	}
+	if _, err := model.AddInstance(rbxfile.NewInstance("Part", nil), 100); err == nil {
+		t.Errorf("expected error for invalid parent")
+	}
+
+	buf.Reset()
+	if _, err := model.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write model: %s", err)
+	}
+	model = new(FormatModel)
+	if _, err := model.ReadFrom(&buf); err != nil {
+		t.Fatalf("failed to read model: %s", err)
+	}
+	if model.InstanceCount != 18 {
+		t.Errorf("expected InstanceCount 18, got %d", model.InstanceCount)
+	}
+	root, err = RobloxCodec{}.Decode(model)
+	if err != nil {
+		t.Fatalf("failed to decode model: %s", err)
+	}
+	if len(root.Instances) != 8 {
+		t.Fatalf("expected 8 top-level instances, got %d", len(root.Instances))
+	}
+	added := root.Instances[0].FindFirstChild("Added", false)
+	if added == nil || added.ClassName != "Part" {
+		t.Fatalf("expected added part")
+	}
+	if v, ok := added.Get("Transparency").(rbxfile.ValueFloat); !ok || v != 0.5 {
+		t.Errorf("unexpected Transparency %#v", added.Get("Transparency"))
+	}
+	if v, ok := added.Get("Anchored").(rbxfile.ValueBool); !ok || bool(v) {
+		t.Errorf("expected zero value for Anchored, got %#v", added.Get("Anchored"))
+	}
+	if v, ok := root.Instances[1].Get("Transparency").(rbxfile.ValueFloat); !ok || v != 0 {
+		t.Errorf("expected zero value for Transparency, got %#v", root.Instances[1].Get("Transparency"))
+	}
+	if child := added.FindFirstChild("Folder", false); child == nil || child.ClassName != "Folder" {
+		t.Errorf("expected added folder")
+	}
+}
+
+func TestDetectMode(t *testing.T) {
+	newRoot := func(classes ...string) *rbxfile.Root {
+		root := new(rbxfile.Root)
+		for _, class := range classes {
+			root.Instances = append(root.Instances, rbxfile.NewInstance(class, nil))
+		}
+		return root
+	}
+	service := rbxfile.NewInstance("CustomService", nil)
+	service.IsService = true
+
+	tests := []struct {
+		name string
+		root *rbxfile.Root
+		mode Mode
+		ok   bool
+	}{
+		{"place", newRoot("Workspace", "Lighting", "Players", "ReplicatedStorage", "StarterGui"), ModePlace, true},
+		{"flagged service", &rbxfile.Root{Instances: []*rbxfile.Instance{service}}, ModePlace, true},
+		{"model", newRoot("Model"), ModeModel, true},
+		{"multiple", newRoot("Part", "Part", "Script"), ModeModel, true},
+		{"mixed", newRoot("Workspace", "Part"), ModePlace, false},
+		{"empty", newRoot(), ModeModel, false},
+		{"nil", nil, ModeModel, false},
+	}
+	for _, test := range tests {
+		mode, ok := DetectMode(test.root)
+		if mode != test.mode || ok != test.ok {
+			t.Errorf("%s: expected (%d, %t), got (%d, %t)", test.name, test.mode, test.ok, mode, ok)
+		}
+	}
+}
+
+func TestRobloxCodec_ServiceRoundTrip(t *testing.T) {
+	workspace := rbxfile.NewInstance("Workspace", nil)
+	workspace.IsService = true
+	rbxfile.NewInstance("Part", workspace)
+	folder := rbxfile.NewInstance("Folder", nil)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{workspace, folder}}
+
+	roundTrip := func(mode Mode) *rbxfile.Root {
+		var buf bytes.Buffer
+		codec := RobloxCodec{Mode: mode}
+		ser := Serializer{Encoder: codec, Decoder: codec}
+		if err := ser.Serialize(&buf, root); err != nil {
+			t.Fatalf("failed to serialize: %s", err)
+		}
+		root, err := ser.Deserialize(&buf)
+		if err != nil {
+			t.Fatalf("failed to deserialize: %s", err)
+		}
+		if len(root.Instances) != 2 || len(root.Instances[0].Children) != 1 {
+			t.Fatalf("unexpected tree")
+		}
+		return root
+	}
+
+	decoded := roundTrip(ModePlace)
+	if !decoded.Instances[0].IsService {
+		t.Errorf("expected Workspace to be a service")
+	}
+	if decoded.Instances[0].Children[0].IsService || decoded.Instances[1].IsService {
+		t.Errorf("expected other instances not to be services")
+	}
+
+	// Models do not contain services.
+	decoded = roundTrip(ModeModel)
+	if decoded.Instances[0].IsService {
+		t.Errorf("expected service flag to be excluded from model")
+	}
+}
+
+func TestRobloxCodec_EncodeDeterministic(t *testing.T) {
+	// Builds the same tree, setting properties in the given order.
+	build := func(names []string) *rbxfile.Root {
+		values := map[string]rbxfile.Value{
+			"Name":         rbxfile.ValueString("Part"),
+			"Anchored":     rbxfile.ValueBool(true),
+			"Size":         rbxfile.ValueVector3{X: 4, Y: 1, Z: 2},
+			"Transparency": rbxfile.ValueFloat(0.5),
+			"Color":        rbxfile.ValueColor3{R: 1},
+			"Material":     rbxfile.ValueToken(256),
+		}
+		root := new(rbxfile.Root)
+		model := rbxfile.NewInstance("Model", nil)
+		for i := 0; i < 3; i++ {
+			part := rbxfile.NewInstance("Part", model)
+			for _, name := range names {
+				part.Set(name, values[name])
+			}
+			rbxfile.NewInstance("Decal", part).Set("Texture", rbxfile.ValueContent("rbxassetid://1"))
+		}
+		rbxfile.NewInstance("Folder", model)
+		root.Instances = append(root.Instances, model)
+		return root
+	}
+	encode := func(root *rbxfile.Root) []byte {
+		model, err := RobloxCodec{Mode: ModeModel}.Encode(root)
+		if err != nil {
+			t.Fatalf("failed to encode: %s", err)
+		}
+		var buf bytes.Buffer
+		if _, err := model.WriteTo(&buf); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+		return buf.Bytes()
+	}
+
+	names := []string{"Name", "Anchored", "Size", "Transparency", "Color", "Material"}
+	expected := encode(build(names))
+	for i := 0; i < 10; i++ {
+		// Reverse and rotate the order in which properties are set.
+		reordered := make([]string, len(names))
+		for j := range names {
+			reordered[j] = names[(len(names)-1-j+i)%len(names)]
+		}
+		if b := encode(build(reordered)); !bytes.Equal(b, expected) {
+			t.Fatalf("%d: encoding differs for the same tree", i)
+		}
+	}
+
+	model, _ := RobloxCodec{Mode: ModeModel}.Encode(build(names))
+	var order []string
+	for _, chunk := range model.Chunks {
+		switch chunk := chunk.(type) {
+		case *ChunkInstance:
+			order = append(order, "INST "+chunk.ClassName)
+		case *ChunkProperty:
+			order = append(order, "PROP "+chunk.PropertyName)
+		case *ChunkParent:
+			order = append(order, "PRNT")
+		case *ChunkEnd:
+			order = append(order, "END")
+		}
+	}
+	expectedOrder := []string{
+		"INST Decal", "INST Folder", "INST Model", "INST Part",
+		"PROP Texture",
+		"PROP Anchored", "PROP Color", "PROP Material", "PROP Name", "PROP Size", "PROP Transparency",
+		"PRNT", "END",
+	}
+	if !reflect.DeepEqual(order, expectedOrder) {
+		t.Errorf("unexpected chunk order:\n\texpected: %v\n\tgot:      %v", expectedOrder, order)
+	}
+}
+
+func TestCSGVersion_Studio(t *testing.T) {
+	found := false
+	for _, file := range studioFiles(t) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read fixture: %s", err)
+		}
+		model := new(FormatModel)
+		if _, err := model.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: failed to read: %s", file, err)
+		}
+		root, err := RobloxCodec{}.Decode(model)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %s", file, err)
+		}
+		instances, _ := rbxfile.Flatten(root)
+		for _, flat := range instances {
+			for _, name := range []string{"PhysicsData", "MeshData"} {
+				v, ok := flat.Instance.Properties[name].(rbxfile.ValueBinaryString)
+				if !ok || len(v) == 0 {
+					continue
+				}
+				found = true
+				if _, ok := rbxfile.CSGVersion(v); !ok {
+					t.Errorf("%s: %s.%s: unrecognized CSG sub-format", file, flat.Instance.ClassName, name)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Skip("no Studio fixture contains CSG data")
+	}
+}
+
+func BenchmarkRobloxCodec_Decode(b *testing.B) {
+	model := encodeTestModel(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := (RobloxCodec{}).Decode(model); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRobloxCodec_DecodeInto(b *testing.B) {
+	model := encodeTestModel(b)
+	root := new(rbxfile.Root)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := (RobloxCodec{}).DecodeInto(model, root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecodeFile(b *testing.B, skipProperties bool) {
+	data, err := ioutil.ReadFile("cframe.rbxl")
+	if err != nil {
+		b.Fatalf("failed to read fixture: %s", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		model := &FormatModel{SkipProperties: skipProperties}
+		if _, err := model.ReadFrom(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := (RobloxCodec{}).Decode(model); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode_Full(b *testing.B) {
+	benchmarkDecodeFile(b, false)
+}
+
+func BenchmarkDecode_StructureOnly(b *testing.B) {
+	benchmarkDecodeFile(b, true)
+}
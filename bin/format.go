@@ -38,6 +38,7 @@ package bin
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"github.com/robloxapi/rbxapi"
 	"github.com/robloxapi/rbxfile"
@@ -92,24 +93,63 @@ func NewSerializer(d Decoder, e Encoder) Serializer {
 	return s
 }
 
+// GzipSig is the signature at the start of a gzip stream.
+const GzipSig = "\x1f\x8b"
+
+// UnwrapGzip returns a buffered reader that reads from r. If the stream
+// begins with GzipSig, then the returned reader decompresses the stream.
+// Otherwise, the stream is read as-is. Because a Roblox file always begins
+// with RobloxSig, a gzip-compressed file can be detected unambiguously.
+func UnwrapGzip(r io.Reader) (*bufio.Reader, error) {
+	buf, ok := r.(*bufio.Reader)
+	if !ok {
+		buf = bufio.NewReader(r)
+	}
+	sig, err := buf.Peek(len(GzipSig))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(sig, []byte(GzipSig)) {
+		return buf, nil
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(zr), nil
+}
+
+// parseError wraps err, which occurred while reading a FormatModel, in a
+// rbxfile.DecodeError. An unexpected end of data is classified as
+// rbxfile.ErrTruncated.
+func parseError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = &rbxfile.ClassError{Class: rbxfile.ErrTruncated, Err: err}
+	}
+	return &rbxfile.DecodeError{Op: "parsing format", Err: err}
+}
+
 // Deserialize decodes data from r into a Root structure using the specified
-// decoder. An optional API can be given to ensure more correct data.
+// decoder. An optional API can be given to ensure more correct data. If the
+// data is compressed with gzip, then it is decompressed transparently.
 func (s Serializer) Deserialize(r io.Reader) (root *rbxfile.Root, err error) {
 	if s.Decoder == nil {
 		return nil, errors.New("a decoder has not been not specified")
 	}
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
 
-	if s.DecoderXML != nil {
-		var buf *bufio.Reader
-		if br, ok := r.(*bufio.Reader); ok {
-			buf = br
-		} else {
-			buf = bufio.NewReader(r)
-		}
+	buf, err := UnwrapGzip(r)
+	if err != nil {
+		return nil, err
+	}
+	r = buf
 
+	if s.DecoderXML != nil {
 		sig, err := buf.Peek(len(RobloxSig) + len(BinaryMarker))
 		if err != nil {
-			return nil, err
+			return nil, parseError(err)
 		}
 		if !bytes.Equal(sig[:len(RobloxSig)], []byte(RobloxSig)) {
 			return nil, ErrInvalidSig
@@ -118,23 +158,80 @@ func (s Serializer) Deserialize(r io.Reader) (root *rbxfile.Root, err error) {
 		if !bytes.Equal(sig[len(RobloxSig):], []byte(BinaryMarker)) {
 			return xml.NewSerializer(s.DecoderXML, nil).Deserialize(buf)
 		}
-		r = buf
 	}
 
 	model := new(FormatModel)
 
 	if _, err = model.ReadFrom(r); err != nil {
-		return nil, errors.New("error parsing format: " + err.Error())
+		return nil, parseError(err)
 	}
 
 	root, err = s.Decoder.Decode(model)
 	if err != nil {
-		return nil, errors.New("error decoding data: " + err.Error())
+		return nil, &rbxfile.DecodeError{Op: "decoding data", Err: err}
 	}
 
 	return root, nil
 }
 
+// DeserializeAll decodes a sequence of concatenated files from r into a Root
+// structure for each file, using the specified decoder. Each binary file is
+// read up to its end chunk, after which the next file begins.
+//
+// If DecoderXML is not nil, then a file in the XML format is decoded along
+// with any subsequent XML documents using xml.Serializer.DeserializeAll.
+// Because of this, a binary file cannot follow an XML file.
+//
+// If the data is compressed with gzip, then it is decompressed
+// transparently. The roots decoded before an error occurs are returned along
+// with the error.
+func (s Serializer) DeserializeAll(r io.Reader) (roots []*rbxfile.Root, err error) {
+	if s.Decoder == nil {
+		return nil, errors.New("a decoder has not been not specified")
+	}
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+
+	buf, err := UnwrapGzip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, err := buf.Peek(1); err == io.EOF {
+			return roots, nil
+		} else if err != nil {
+			return roots, err
+		}
+
+		if s.DecoderXML != nil {
+			sig, err := buf.Peek(len(RobloxSig) + len(BinaryMarker))
+			if err != nil {
+				return roots, parseError(err)
+			}
+			if !bytes.Equal(sig[:len(RobloxSig)], []byte(RobloxSig)) {
+				return roots, ErrInvalidSig
+			}
+			if !bytes.Equal(sig[len(RobloxSig):], []byte(BinaryMarker)) {
+				xroots, err := xml.NewSerializer(s.DecoderXML, nil).DeserializeAll(buf)
+				return append(roots, xroots...), err
+			}
+		}
+
+		model := new(FormatModel)
+		if _, err = model.ReadFrom(buf); err != nil {
+			return roots, parseError(err)
+		}
+
+		root, err := s.Decoder.Decode(model)
+		if err != nil {
+			return roots, &rbxfile.DecodeError{Op: "decoding data", Err: err}
+		}
+		roots = append(roots, root)
+	}
+}
+
 // Serialize encodes data from a Root structure to w using the specified
 // encoder.
 func (s Serializer) Serialize(w io.Writer, root *rbxfile.Root) (err error) {
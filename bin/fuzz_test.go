@@ -0,0 +1,43 @@
+//go:build go1.18
+// +build go1.18
+
+package bin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzFormatModel_ReadFrom checks that reading arbitrary data, and decoding
+// and re-encoding the result, does not panic. Errors are expected.
+func FuzzFormatModel_ReadFrom(f *testing.F) {
+	b, err := ioutil.ReadFile("cframe.rbxl")
+	if err != nil {
+		f.Fatalf("failed to read fixture: %s", err)
+	}
+	f.Add(b)
+	var buf bytes.Buffer
+	if _, err := encodeTestModel(f).WriteTo(&buf); err != nil {
+		f.Fatalf("failed to write model: %s", err)
+	}
+	f.Add(buf.Bytes())
+	// Lengths that exceed the data.
+	f.Add(append(b[:32:32], "INST\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF\x00\x00\x00\x00"...))
+	f.Add(append(b[:32:32], "PRNT\x00\x00\x00\x00\x09\x00\x00\x00\x00\x00\x00\x00\x00\xFF\xFF\xFF\xFF"...))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		model := new(FormatModel)
+		if _, err := model.ReadFrom(bytes.NewReader(b)); err != nil {
+			return
+		}
+		root, err := RobloxCodec{}.Decode(model)
+		if err != nil {
+			return
+		}
+		if model, err = (RobloxCodec{}).Encode(root); err != nil {
+			return
+		}
+		model.WriteTo(ioutil.Discard)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/bkaradzic/go-lz4"
+	"github.com/robloxapi/rbxfile"
 	"io"
 	"io/ioutil"
 )
@@ -18,22 +19,35 @@ const RobloxSig = "<roblox"
 // BinaryMarker indicates the start of a binary file, rather than an XML file.
 const BinaryMarker = "!"
 
-// BinaryHeader is the header magic of a binary file.
+// BinaryHeader is the header magic of a binary file. As with PNG, the line
+// endings and the \x1a terminator allow a file altered by a text-mode transfer
+// to be detected, so a header that differs in any way is rejected with
+// ErrCorruptHeader.
 const BinaryHeader = "\x89\xff\r\n\x1a\n"
 
+// Errors produced while decoding. Each is a *rbxfile.ClassError, belonging to
+// the class of decoding errors noted by its name.
 var (
-	ErrInvalidSig       = errors.New("invalid signature")
-	ErrCorruptHeader    = errors.New("the file header is corrupted")
-	ErrChunkParentArray = errors.New("length of parent array does not match children array")
+	ErrInvalidSig       error = &rbxfile.ClassError{Class: rbxfile.ErrFormat, Err: errors.New("invalid signature")}
+	ErrCorruptHeader    error = &rbxfile.ClassError{Class: rbxfile.ErrFormat, Err: errors.New("the file header is corrupted")}
+	ErrChunkParentArray error = &rbxfile.ClassError{Class: rbxfile.ErrCorrupt, Err: errors.New("length of parent array does not match children array")}
+	ErrNoEndChunk       error = &rbxfile.ClassError{Class: rbxfile.ErrTruncated, Err: errors.New("end chunk is missing")}
 )
 
+// ErrUnrecognizedVersion is returned when the version of a file is not
+// supported. It belongs to the rbxfile.ErrVersion class.
 type ErrUnrecognizedVersion uint16
 
 func (err ErrUnrecognizedVersion) Error() string {
 	return fmt.Sprintf("unrecognized version %d", err)
 }
 
-// ErrChunk is an error produced by a chunk of a certain type.
+func (err ErrUnrecognizedVersion) Is(target error) bool {
+	return target == rbxfile.ErrVersion
+}
+
+// ErrChunk is an error produced by a chunk of a certain type. It belongs to
+// the rbxfile.ErrCorrupt class.
 type ErrChunk struct {
 	Sig [4]byte
 	Err error
@@ -43,6 +57,14 @@ func (err ErrChunk) Error() string {
 	return fmt.Sprintf("chunk %s: %s", err.Sig, err.Err.Error())
 }
 
+func (err ErrChunk) Unwrap() error {
+	return err.Err
+}
+
+func (err ErrChunk) Is(target error) bool {
+	return target == rbxfile.ErrCorrupt
+}
+
 type ErrInvalidType struct {
 	Chunk *ChunkProperty
 	Bytes []byte
@@ -131,6 +153,71 @@ func (f *formatReader) readall() (data []byte, failed bool) {
 	return data, false
 }
 
+// checkLength fails with io.ErrUnexpectedEOF if the underlying reader is
+// known to have fewer than n bytes remaining. This prevents lengths read from
+// malformed data from causing large allocations.
+func (f *formatReader) checkLength(n uint64) (failed bool) {
+	if f.err != nil {
+		return true
+	}
+
+	if r, ok := f.r.(interface{ Len() int }); ok && n > uint64(r.Len()) {
+		f.err = io.ErrUnexpectedEOF
+		return true
+	}
+
+	return false
+}
+
+// readInto reads n bytes, appending them to buf. The buffer grows as data is
+// read, so that a large n does not cause a large allocation before the data
+// is known to exist.
+func (f *formatReader) readInto(buf *bytes.Buffer, n int64) (failed bool) {
+	if f.checkLength(uint64(n)) {
+		return true
+	}
+
+	const chunk = 1 << 20
+	if n <= chunk {
+		buf.Grow(int(n))
+	} else {
+		buf.Grow(chunk)
+	}
+
+	var m int64
+	m, f.err = io.CopyN(buf, f.r, n)
+	f.n += m
+
+	if f.err != nil {
+		if f.err == io.EOF {
+			f.err = io.ErrUnexpectedEOF
+		}
+		return true
+	}
+
+	return false
+}
+
+// discard reads and discards n bytes.
+func (f *formatReader) discard(n int64) (failed bool) {
+	if f.err != nil {
+		return true
+	}
+
+	var m int64
+	m, f.err = io.CopyN(ioutil.Discard, f.r, n)
+	f.n += m
+
+	if f.err != nil {
+		if f.err == io.EOF {
+			f.err = io.ErrUnexpectedEOF
+		}
+		return true
+	}
+
+	return false
+}
+
 func (f *formatReader) end() (n int64, err error) {
 	return f.n, f.err
 }
@@ -186,6 +273,10 @@ func (f *formatReader) readString(data *string) (failed bool) {
 		return true
 	}
 
+	if f.checkLength(uint64(length)) {
+		return true
+	}
+
 	s := make([]byte, length)
 	if f.read(s) {
 		return true
@@ -323,10 +414,163 @@ type FormatModel struct {
 	// instead emitted as errors.
 	Strict bool
 
+	// ForceCompression, if not nil, overrides the compression of every chunk
+	// when encoding. If true, every chunk is compressed, and if false, every
+	// chunk is written uncompressed. If nil, then each chunk is compressed
+	// according to its own Compressed method. The end chunk is exempt, as it
+	// must not be compressed.
+	ForceCompression *bool
+
+	// RetainCompressed determines whether ReadFrom retains the original
+	// compressed data of each compressed chunk. When WriteTo encodes a
+	// compressed chunk whose payload is unchanged from when it was read, the
+	// retained data is written verbatim instead of compressing the payload
+	// again. Because compression is not guaranteed to reproduce the original
+	// data, this is required for an unmodified model to be re-encoded
+	// byte-for-byte. Retained data is kept in memory until the next call to
+	// ReadFrom.
+	RetainCompressed bool
+
+	// SkipProperties determines whether ReadFrom skips property chunks. The
+	// payload of each property chunk is discarded without being decompressed
+	// or decoded, and the chunk is not added to Chunks. Decoding such a model
+	// produces only the structure of the tree: the class and parent of each
+	// instance, without property values. This is much faster when only the
+	// hierarchy is needed. Because property chunks are lost, a model read in
+	// this way should not be written back to a file.
+	SkipProperties bool
+
+	// InspectChunks determines whether ReadFrom reads only the structure of
+	// each chunk. The payload of every chunk is discarded without being
+	// decompressed or decoded, and the header of the chunk is added to
+	// RawChunks instead of a Chunk being added to Chunks. Reading stops after
+	// the end chunk. Because lengths are not checked against each other,
+	// this can be used to examine a file that would otherwise fail to read,
+	// such as one with a corrupt compressed payload. A model read in this way
+	// cannot be decoded or written back to a file.
+	InspectChunks bool
+
+	// RawChunks is a list of the chunks present in the model, as they were
+	// last read by ReadFrom with InspectChunks set. If the payload of a chunk
+	// is cut off, the header of the chunk is still included.
+	RawChunks []RawChunkInfo
+
 	// Warnings is a list of non-fatal problems that have occurred. This will
 	// be cleared and populated when calling either ReadFrom and WriteTo.
 	// Codecs may also clear and populate this when decoding or encoding.
 	Warnings []error
+
+	// chunkSizes contains the size of each chunk in Chunks, as it was last
+	// read or written. chunkKnown indicates whether each size is known; it is
+	// false for a chunk that was skipped.
+	chunkSizes []ChunkSize
+	chunkKnown []bool
+
+	// retained contains the original data of each chunk in Chunks, as it was
+	// last read, if RetainCompressed was true.
+	retained []retainedChunk
+}
+
+// retainedChunk holds the original data of a compressed chunk.
+type retainedChunk struct {
+	signature  [4]byte
+	payload    []byte
+	compressed []byte
+}
+
+// ChunkSize describes the size of a chunk's payload, as it appears in a file.
+type ChunkSize struct {
+	// Compressed is the length of the compressed payload. It is 0 if the
+	// payload is not compressed.
+	Compressed uint32
+
+	// Decompressed is the length of the payload after decompression.
+	Decompressed uint32
+}
+
+// RawChunkInfo describes the header of a chunk, as it appears in a file.
+type RawChunkInfo struct {
+	// Offset is the position of the chunk from the start of the file.
+	Offset int64
+
+	// Signature is the signature of the chunk.
+	Signature [4]byte
+
+	// Size is the size of the chunk's payload, as claimed by the header.
+	Size ChunkSize
+
+	// Reserved is the value of the reserved field of the header, which is
+	// expected to be 0.
+	Reserved uint32
+}
+
+// Compressed returns whether the payload of the chunk is compressed.
+func (info RawChunkInfo) Compressed() bool {
+	return info.Size.Compressed != 0
+}
+
+// ChunkSize returns the size of the chunk at index i of Chunks, as it was
+// last read by ReadFrom or written by WriteTo. Returns false if the size of
+// the chunk is not known, such as when the chunk was skipped by WriteTo.
+func (f *FormatModel) ChunkSize(i int) (size ChunkSize, ok bool) {
+	if i < 0 || i >= len(f.chunkSizes) || i >= len(f.Chunks) || !f.chunkKnown[i] {
+		return ChunkSize{}, false
+	}
+	return f.chunkSizes[i], true
+}
+
+// Equal returns whether the model is structurally equal to other, ignoring
+// whether each chunk is compressed. It is equivalent to
+// EqualOptions{IgnoreCompression: true}.Equal(f, other).
+func (f *FormatModel) Equal(other *FormatModel) bool {
+	return EqualOptions{IgnoreCompression: true}.Equal(f, other)
+}
+
+// EqualOptions configures the comparison of two FormatModels.
+type EqualOptions struct {
+	// IgnoreCompression determines whether models that differ only in
+	// whether each chunk is compressed are equal.
+	IgnoreCompression bool
+}
+
+// Equal returns whether model f is structurally equal to other. The Version,
+// TypeCount, and InstanceCount of each model are compared, followed by the
+// signature, compression flag, and decompressed payload of each chunk, in
+// order. A model is always equal to itself, and two nil models are equal.
+func (opts EqualOptions) Equal(f, other *FormatModel) bool {
+	if f == other {
+		return true
+	}
+	if f == nil || other == nil {
+		return false
+	}
+	if f.Version != other.Version ||
+		f.TypeCount != other.TypeCount ||
+		f.InstanceCount != other.InstanceCount ||
+		len(f.Chunks) != len(other.Chunks) {
+		return false
+	}
+	var a, b bytes.Buffer
+	for i, chunk := range f.Chunks {
+		if chunk.Signature() != other.Chunks[i].Signature() {
+			return false
+		}
+		if !opts.IgnoreCompression && chunk.Compressed() != other.Chunks[i].Compressed() {
+			return false
+		}
+		a.Reset()
+		b.Reset()
+		if _, err := chunk.WriteTo(&a); err != nil {
+			return false
+		}
+		if _, err := other.Chunks[i].WriteTo(&b); err != nil {
+			return false
+		}
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			return false
+		}
+	}
+	return true
 }
 
 // ReadFrom decodes data from r into the FormatModel.
@@ -340,102 +584,188 @@ func (f *FormatModel) ReadFrom(r io.Reader) (n int64, err error) {
 
 	fr := &formatReader{r: r}
 
+	// reuse space from previous slices
+	f.Warnings = f.Warnings[:0]
+	f.Chunks = f.Chunks[:0]
+	f.chunkSizes = f.chunkSizes[:0]
+	f.chunkKnown = f.chunkKnown[:0]
+	f.retained = f.retained[:0]
+	f.RawChunks = f.RawChunks[:0]
+
+	if f.readHeader(fr) {
+		return fr.end()
+	}
+
+loop:
+	for {
+		rawChunk := new(rawChunk)
+		rawChunk.retain = f.RetainCompressed
+		rawChunk.skipProperties = f.SkipProperties
+		rawChunk.inspect = f.InspectChunks
+		offset := fr.n
+		failed := rawChunk.ReadFrom(fr)
+		if f.InspectChunks && rawChunk.headerRead {
+			// Recorded even if the payload could not be read.
+			f.RawChunks = append(f.RawChunks, RawChunkInfo{
+				Offset:    offset,
+				Signature: rawChunk.signature,
+				Size:      rawChunk.size,
+				Reserved:  rawChunk.reserved,
+			})
+		}
+		if failed {
+			return fr.end()
+		}
+		if f.InspectChunks {
+			if rawChunk.signature == (ChunkEnd{}).Signature() {
+				break loop
+			}
+			continue loop
+		}
+		if rawChunk.skipped {
+			continue loop
+		}
+
+		newChunk := chunkGenerators(f.Version, rawChunk.signature)
+		if newChunk == nil {
+			f.Warnings = append(f.Warnings, WarnUnknownChunk(rawChunk.signature))
+			continue loop
+		}
+
+		chunk := newChunk()
+		chunk.SetCompressed(rawChunk.compressed)
+
+		if _, err := chunk.ReadFrom(bytes.NewReader(rawChunk.payload)); err != nil {
+			err = ErrChunk{Sig: rawChunk.signature, Err: err}
+			if f.Strict {
+				fr.err = err
+				return fr.end()
+			}
+			f.Warnings = append(f.Warnings, err)
+			continue loop
+		}
+
+		f.Chunks = append(f.Chunks, chunk)
+		f.chunkSizes = append(f.chunkSizes, rawChunk.size)
+		f.chunkKnown = append(f.chunkKnown, true)
+		if f.RetainCompressed {
+			f.retained = append(f.retained, retainedChunk{
+				signature:  rawChunk.signature,
+				payload:    rawChunk.payload,
+				compressed: rawChunk.compressedData,
+			})
+		}
+
+		if endChunk, ok := chunk.(*ChunkEnd); ok {
+			if endChunk.Compressed() {
+				f.Warnings = append(f.Warnings, WarnEndChunkCompressed)
+			}
+
+			if !bytes.Equal(endChunk.Content, []byte("</roblox>")) {
+				f.Warnings = append(f.Warnings, WarnEndChunkContent)
+			}
+
+			break loop
+		}
+	}
+
+	return fr.end()
+}
+
+// Reads the file header into the FormatModel.
+func (f *FormatModel) readHeader(fr *formatReader) (failed bool) {
 	sig := make([]byte, len(RobloxSig+BinaryMarker))
 	if fr.read(sig) {
-		return fr.end()
+		return true
 	}
 
 	if !bytes.Equal(sig, []byte(RobloxSig+BinaryMarker)) {
 		fr.err = ErrInvalidSig
-		return fr.end()
+		return true
 	}
 
 	header := make([]byte, len(BinaryHeader))
 	if fr.read(header) {
-		return fr.end()
+		return true
 	}
 
 	if !bytes.Equal(header, []byte(BinaryHeader)) {
 		fr.err = ErrCorruptHeader
-		return fr.end()
+		return true
 	}
 
 	var version uint16
 	if fr.readNumber(binary.LittleEndian, &version) {
-		return fr.end()
+		return true
 	}
 
 	switch version {
 	default:
 		fr.err = ErrUnrecognizedVersion(version)
-		return fr.end()
+		return true
 	case 0:
 	}
 
 	f.Version = version
 
-	// reuse space from previous slices
-	f.Warnings = f.Warnings[:0]
-	f.Chunks = f.Chunks[:0]
-
 	if fr.readNumber(binary.LittleEndian, &f.TypeCount) {
-		return fr.end()
+		return true
 	}
 
 	if fr.readNumber(binary.LittleEndian, &f.InstanceCount) {
-		return fr.end()
+		return true
 	}
 
 	var reserved uint64
 	if fr.readNumber(binary.LittleEndian, &reserved) {
-		return fr.end()
+		return true
 	}
 	if reserved != 0 {
 		f.Warnings = append(f.Warnings, WarnReserveNonZero)
 	}
 
-loop:
-	for {
-		rawChunk := new(rawChunk)
-		if rawChunk.ReadFrom(fr) {
-			return fr.end()
-		}
+	return false
+}
 
-		newChunk := chunkGenerators(f.Version, rawChunk.signature)
-		if newChunk == nil {
-			f.Warnings = append(f.Warnings, WarnUnknownChunk(rawChunk.signature))
-			continue loop
-		}
+// Verify checks the structure of a binary file read from r, without decoding
+// the content of any chunks. The header is validated, and the length of each
+// chunk is checked for consistency, including the decompressed length of
+// compressed chunks. The file must contain an end chunk with the expected
+// content. This is much cheaper than a full decode, and is enough to detect
+// truncated files.
+//
+// Returns nil if the file is valid.
+func Verify(r io.Reader) error {
+	if r == nil {
+		return errors.New("reader is nil")
+	}
 
-		chunk := newChunk()
-		chunk.SetCompressed(rawChunk.compressed)
+	fr := &formatReader{r: r}
+	if new(FormatModel).readHeader(fr) {
+		return fr.err
+	}
 
-		if _, err := chunk.ReadFrom(bytes.NewReader(rawChunk.payload)); err != nil {
-			err = ErrChunk{Sig: rawChunk.signature, Err: err}
-			if f.Strict {
-				fr.err = err
-				return fr.end()
+	for {
+		start := fr.n
+		rawChunk := new(rawChunk)
+		if rawChunk.ReadFrom(fr) {
+			if fr.err == io.EOF {
+				if fr.n == start {
+					return ErrNoEndChunk
+				}
+				// Chunk was cut off between fields.
+				return io.ErrUnexpectedEOF
 			}
-			f.Warnings = append(f.Warnings, err)
-			continue loop
+			return fr.err
 		}
 
-		f.Chunks = append(f.Chunks, chunk)
-
-		if endChunk, ok := chunk.(*ChunkEnd); ok {
-			if endChunk.Compressed() {
-				f.Warnings = append(f.Warnings, WarnEndChunkCompressed)
-			}
-
-			if !bytes.Equal(endChunk.Content, []byte("</roblox>")) {
-				f.Warnings = append(f.Warnings, WarnEndChunkContent)
+		if rawChunk.signature == newChunkEnd().Signature() {
+			if !bytes.Equal(rawChunk.payload, []byte("</roblox>")) {
+				return WarnEndChunkContent
 			}
-
-			break loop
+			return nil
 		}
 	}
-
-	return fr.end()
 }
 
 // WriteTo encodes the FormatModel as bytes to w.
@@ -445,6 +775,8 @@ func (f *FormatModel) WriteTo(w io.Writer) (n int64, err error) {
 	}
 
 	f.Warnings = f.Warnings[:0]
+	f.chunkSizes = make([]ChunkSize, len(f.Chunks))
+	f.chunkKnown = make([]bool, len(f.Chunks))
 
 	fw := &formatWriter{w: w}
 
@@ -475,8 +807,13 @@ func (f *FormatModel) WriteTo(w io.Writer) (n int64, err error) {
 			continue
 		}
 
+		compressed := chunk.Compressed()
+		if _, ok := chunk.(*ChunkEnd); f.ForceCompression != nil && !ok {
+			compressed = *f.ForceCompression
+		}
+
 		if endChunk, ok := chunk.(*ChunkEnd); ok {
-			if endChunk.IsCompressed {
+			if compressed {
 				f.Warnings = append(f.Warnings, WarnEndChunkCompressed)
 			}
 
@@ -491,7 +828,7 @@ func (f *FormatModel) WriteTo(w io.Writer) (n int64, err error) {
 
 		rawChunk := new(rawChunk)
 		rawChunk.signature = chunk.Signature()
-		rawChunk.compressed = chunk.Compressed()
+		rawChunk.compressed = compressed
 
 		buf := new(bytes.Buffer)
 		if _, fw.err = chunk.WriteTo(buf); fw.err != nil {
@@ -499,10 +836,20 @@ func (f *FormatModel) WriteTo(w io.Writer) (n int64, err error) {
 		}
 
 		rawChunk.payload = buf.Bytes()
+		if compressed && i < len(f.retained) {
+			// Reuse the original compressed data if the payload has not
+			// changed.
+			r := f.retained[i]
+			if r.compressed != nil && r.signature == rawChunk.signature && bytes.Equal(r.payload, rawChunk.payload) {
+				rawChunk.compressedData = r.compressed
+			}
+		}
 
 		if rawChunk.WriteTo(fw) {
 			return fw.end()
 		}
+		f.chunkSizes[i] = rawChunk.size
+		f.chunkKnown[i] = true
 	}
 
 	return fw.end()
@@ -537,6 +884,27 @@ type rawChunk struct {
 	signature  [4]byte
 	compressed bool
 	payload    []byte
+	size       ChunkSize
+
+	// retain indicates whether ReadFrom should set compressedData.
+	retain bool
+	// compressedData is the compressed form of payload, excluding the
+	// decompressed length. If set, WriteTo writes it instead of compressing
+	// the payload.
+	compressedData []byte
+
+	// skipProperties indicates whether ReadFrom should discard the payload
+	// of a property chunk, in which case skipped is set.
+	skipProperties bool
+	skipped        bool
+
+	// inspect indicates whether ReadFrom should discard the payload of any
+	// chunk, in which case skipped is set.
+	inspect bool
+	// reserved is the value of the reserved field of the chunk header.
+	reserved uint32
+	// headerRead indicates whether ReadFrom read the entire chunk header.
+	headerRead bool
 }
 
 // Reads out a raw chunk from a stream, decompressing the chunk if necessary.
@@ -555,29 +923,45 @@ func (c *rawChunk) ReadFrom(fr *formatReader) bool {
 		return true
 	}
 
-	var reserved uint32
-	if fr.readNumber(binary.LittleEndian, &reserved) {
+	if fr.readNumber(binary.LittleEndian, &c.reserved) {
 		return true
 	}
 
-	c.payload = make([]byte, decompressedLength)
+	c.size = ChunkSize{Compressed: compressedLength, Decompressed: decompressedLength}
+	c.headerRead = true
+	if c.inspect || c.skipProperties && c.signature == newChunkProperty().Signature() {
+		c.skipped = true
+		if compressedLength == 0 {
+			return fr.discard(int64(decompressedLength))
+		}
+		return fr.discard(int64(compressedLength))
+	}
 	// If compressed length is 0, then the data is not compressed.
 	if compressedLength == 0 {
 		c.compressed = false
-		if fr.read(c.payload) {
+		var buf bytes.Buffer
+		if fr.readInto(&buf, int64(decompressedLength)) {
 			return true
 		}
+		c.payload = buf.Bytes()
 	} else {
 		c.compressed = true
 
 		// Prepare compressed data for reading by lz4, which requires the
 		// uncompressed length before the compressed data.
-		compressedData := make([]byte, compressedLength+4)
-		binary.LittleEndian.PutUint32(compressedData, decompressedLength)
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, decompressedLength)
+		if fr.readInto(&buf, int64(compressedLength)) {
+			return true
+		}
+		compressedData := buf.Bytes()
 
-		if fr.read(compressedData[4:]) {
+		// LZ4 cannot expand data by a ratio of more than 255 to 1.
+		if uint64(decompressedLength) > uint64(compressedLength)*255+16 {
+			fr.err = fmt.Errorf("lz4: decompressed length %d too large for compressed length %d", decompressedLength, compressedLength)
 			return true
 		}
+		c.payload = make([]byte, decompressedLength)
 
 		// ROBLOX ERROR: "Malformed data ([true decompressed length] != [given
 		// decompressed length])". lz4 already does some kind of size
@@ -587,6 +971,10 @@ func (c *rawChunk) ReadFrom(fr *formatReader) bool {
 			fr.err = fmt.Errorf("lz4: %s", err.Error())
 			return true
 		}
+
+		if c.retain {
+			c.compressedData = compressedData[4:]
+		}
 	}
 
 	return false
@@ -599,20 +987,24 @@ func (c *rawChunk) WriteTo(fw *formatWriter) bool {
 	}
 
 	if c.compressed {
-		var compressedData []byte
-		compressedData, fw.err = lz4.Encode(compressedData, c.payload)
-		if fw.err != nil {
-			return true
-		}
+		compressedPayload := c.compressedData
+		if compressedPayload == nil {
+			var compressedData []byte
+			compressedData, fw.err = lz4.Encode(compressedData, c.payload)
+			if fw.err != nil {
+				return true
+			}
 
-		// lz4 sanity check
-		if binary.LittleEndian.Uint32(compressedData[:4]) != uint32(len(c.payload)) {
-			panic("lz4 uncompressed length does not match payload length")
-		}
+			// lz4 sanity check
+			if binary.LittleEndian.Uint32(compressedData[:4]) != uint32(len(c.payload)) {
+				panic("lz4 uncompressed length does not match payload length")
+			}
 
-		// Compressed length; lz4 prepends the length of the uncompressed
-		// payload, so it must be excluded.
-		compressedPayload := compressedData[4:]
+			// Compressed length; lz4 prepends the length of the uncompressed
+			// payload, so it must be excluded.
+			compressedPayload = compressedData[4:]
+		}
+		c.size = ChunkSize{Compressed: uint32(len(compressedPayload)), Decompressed: uint32(len(c.payload))}
 
 		if fw.writeNumber(binary.LittleEndian, uint32(len(compressedPayload))) {
 			return true
@@ -632,6 +1024,8 @@ func (c *rawChunk) WriteTo(fw *formatWriter) bool {
 			return true
 		}
 	} else {
+		c.size = ChunkSize{Compressed: 0, Decompressed: uint32(len(c.payload))}
+
 		// If the data is not compressed, then the compressed length is 0
 		if fw.writeNumber(binary.LittleEndian, uint32(0)) {
 			return true
@@ -729,9 +1123,13 @@ func (c *ChunkInstance) ReadFrom(r io.Reader) (n int64, err error) {
 		return fr.end()
 	}
 
+	if fr.checkLength(uint64(groupLength) * 4) {
+		return fr.end()
+	}
+
 	c.InstanceIDs = make([]int32, groupLength)
 	if groupLength > 0 {
-		raw := make([]byte, groupLength*4)
+		raw := make([]byte, uint64(groupLength)*4)
 		if fr.read(raw) {
 			return fr.end()
 		}
@@ -904,9 +1302,13 @@ func (c *ChunkParent) ReadFrom(r io.Reader) (n int64, err error) {
 		return fr.end()
 	}
 
+	if fr.checkLength(uint64(instanceCount) * 8) {
+		return fr.end()
+	}
+
 	c.Children = make([]int32, instanceCount)
 	if instanceCount > 0 {
-		raw := make([]byte, instanceCount*4)
+		raw := make([]byte, uint64(instanceCount)*4)
 		if fr.read(raw) {
 			return fr.end()
 		}
@@ -923,7 +1325,7 @@ func (c *ChunkParent) ReadFrom(r io.Reader) (n int64, err error) {
 
 	c.Parents = make([]int32, instanceCount)
 	if instanceCount > 0 {
-		raw := make([]byte, instanceCount*4)
+		raw := make([]byte, uint64(instanceCount)*4)
 		if fr.read(raw) {
 			return fr.end()
 		}
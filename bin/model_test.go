@@ -2,8 +2,16 @@ package bin
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"errors"
+	"github.com/robloxapi/rbxfile"
+	"github.com/robloxapi/rbxfile/xml"
 	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"unicode/utf8"
 )
@@ -453,3 +461,746 @@ func TestFormatModel_WriteTo(t *testing.T) {
 		t.Error("expected error (chunk write), got:", err)
 	}
 }
+
+func TestFormatModel_ChunkSize(t *testing.T) {
+	f := &FormatModel{
+		Chunks: []Chunk{
+			&ChunkParent{
+				IsCompressed: true,
+				Children:     make([]int32, 64),
+				Parents:      make([]int32, 64),
+			},
+			&ChunkEnd{
+				IsCompressed: false,
+				Content:      []byte("</roblox>"),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	b := buf.Bytes()
+
+	g := new(FormatModel)
+	if _, err := g.ReadFrom(bytes.NewReader(b)); err != nil {
+		t.Fatalf("unexpected read error: %s", err)
+	}
+
+	// Walk the raw chunk headers that follow the file header.
+	i := len(RobloxSig+BinaryMarker+BinaryHeader) + 2 + 4 + 4 + 8
+	for n := range f.Chunks {
+		compressed := binary.LittleEndian.Uint32(b[i+4:])
+		decompressed := binary.LittleEndian.Uint32(b[i+8:])
+		expected := ChunkSize{Compressed: compressed, Decompressed: decompressed}
+
+		if size, ok := f.ChunkSize(n); !ok || size != expected {
+			t.Errorf("chunk %d: written size does not match raw bytes (expected %v, got %v)", n, expected, size)
+		}
+		if size, ok := g.ChunkSize(n); !ok || size != expected {
+			t.Errorf("chunk %d: read size does not match raw bytes (expected %v, got %v)", n, expected, size)
+		}
+
+		if compressed == 0 {
+			i += 16 + int(decompressed)
+		} else {
+			i += 16 + int(compressed)
+		}
+	}
+	if i != len(b) {
+		t.Errorf("expected chunks to end at %d, ended at %d", len(b), i)
+	}
+
+	if size, _ := g.ChunkSize(0); size.Compressed == 0 || size.Decompressed != 1+4+64*4*2 {
+		t.Errorf("unexpected size of compressed chunk: %v", size)
+	}
+	if _, ok := g.ChunkSize(len(g.Chunks)); ok {
+		t.Error("expected no size for out-of-range chunk")
+	}
+
+	// Chunks that are invalid for the version are skipped when writing.
+	f.Version = 1
+	if _, err := f.WriteTo(ioutil.Discard); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	for n := range f.Chunks {
+		if size, ok := f.ChunkSize(n); ok {
+			t.Errorf("chunk %d: expected no size for skipped chunk, got %v", n, size)
+		}
+	}
+}
+
+func TestFormatModel_ForceCompression(t *testing.T) {
+	f := &FormatModel{
+		Chunks: []Chunk{
+			&ChunkParent{
+				IsCompressed: true,
+				Children:     []int32{0, 1, 2},
+				Parents:      []int32{-1, 0, 0},
+			},
+			&ChunkEnd{
+				IsCompressed: false,
+				Content:      []byte("</roblox>"),
+			},
+		},
+	}
+
+	uncompressed := false
+	f.ForceCompression = &uncompressed
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	for i := range f.Chunks {
+		if size, _ := f.ChunkSize(i); size.Compressed != 0 {
+			t.Errorf("chunk %d: expected uncompressed chunk", i)
+		}
+	}
+	if !f.Chunks[0].Compressed() {
+		t.Error("expected compression of chunk to be unchanged")
+	}
+
+	g := new(FormatModel)
+	if _, err := g.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected read error: %s", err)
+	}
+	if len(g.Chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(g.Chunks))
+	}
+	if p, ok := g.Chunks[0].(*ChunkParent); !ok || p.Compressed() || len(p.Children) != 3 || p.Parents[2] != 0 {
+		t.Errorf("unexpected parent chunk: %#v", g.Chunks[0])
+	}
+
+	compressed := true
+	f.ForceCompression = &compressed
+	buf.Reset()
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if size, _ := f.ChunkSize(0); size.Compressed == 0 {
+		t.Errorf("expected compressed parent chunk")
+	}
+	if size, _ := f.ChunkSize(1); size.Compressed != 0 {
+		t.Errorf("expected end chunk to remain uncompressed")
+	}
+	if len(f.Warnings) > 0 {
+		t.Errorf("unexpected write warnings: %v", f.Warnings)
+	}
+	if _, err := g.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected read error: %s", err)
+	}
+	if len(g.Warnings) > 0 {
+		t.Errorf("unexpected read warnings: %v", g.Warnings)
+	}
+	if !g.Chunks[0].Compressed() || g.Chunks[1].Compressed() {
+		t.Errorf("unexpected compression of chunks read back")
+	}
+}
+
+func TestFormatModel_RoundTrip(t *testing.T) {
+	// A place file saved by Roblox, with compressed chunks.
+	b, err := ioutil.ReadFile("cframe.rbxl")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %s", err)
+	}
+
+	f := new(FormatModel)
+	if _, err := f.ReadFrom(bytes.NewReader(b)); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if len(f.Warnings) > 0 {
+		t.Errorf("unexpected decode warnings: %v", f.Warnings)
+	}
+	sizes := make([]ChunkSize, len(f.Chunks))
+	for i := range f.Chunks {
+		sizes[i], _ = f.ChunkSize(i)
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	if len(f.Warnings) > 0 {
+		t.Errorf("unexpected encode warnings: %v", f.Warnings)
+	}
+	for i := range f.Chunks {
+		if size, _ := f.ChunkSize(i); size != sizes[i] {
+			t.Errorf("chunk %d: size %v does not match original %v", i, size, sizes[i])
+		}
+	}
+	if out := buf.Bytes(); !bytes.Equal(out, b) {
+		for i := 0; i < len(b) && i < len(out); i++ {
+			if b[i] != out[i] {
+				t.Fatalf("output differs from input at offset %d", i)
+			}
+		}
+		t.Fatalf("output length %d differs from input length %d", len(out), len(b))
+	}
+}
+
+func TestFormatModel_Equal(t *testing.T) {
+	b, err := ioutil.ReadFile("cframe.rbxl")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %s", err)
+	}
+	read := func(b []byte) *FormatModel {
+		f := new(FormatModel)
+		if _, err := f.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Fatalf("failed to decode: %s", err)
+		}
+		return f
+	}
+
+	f := read(b)
+	if !f.Equal(f) {
+		t.Errorf("expected model to equal itself")
+	}
+	if !f.Equal(read(b)) {
+		t.Errorf("expected model to equal model read from same data")
+	}
+
+	// Compression is ignored.
+	uncompressed := false
+	g := read(b)
+	g.ForceCompression = &uncompressed
+	var buf bytes.Buffer
+	if _, err := g.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	if bytes.Equal(buf.Bytes(), b) {
+		t.Fatalf("expected uncompressed output to differ")
+	}
+	if !f.Equal(read(buf.Bytes())) {
+		t.Errorf("expected model to equal uncompressed model")
+	}
+	if (EqualOptions{}).Equal(f, read(buf.Bytes())) {
+		t.Errorf("expected model to differ from uncompressed model when compression is compared")
+	}
+	if !(EqualOptions{}).Equal(f, read(b)) {
+		t.Errorf("expected model to equal model read from same data when compression is compared")
+	}
+
+	g = read(b)
+	changed := false
+	for _, chunk := range g.Chunks {
+		if chunk, ok := chunk.(*ChunkProperty); ok && chunk.PropertyName == "Name" {
+			v := ValueString("Changed")
+			chunk.Properties[0] = &v
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		t.Fatalf("expected Name property chunk")
+	}
+	if f.Equal(g) || g.Equal(f) {
+		t.Errorf("expected models with different values to differ")
+	}
+
+	g = read(b)
+	g.InstanceCount++
+	if f.Equal(g) {
+		t.Errorf("expected models with different headers to differ")
+	}
+	if f.Equal(nil) || !(*FormatModel)(nil).Equal(nil) {
+		t.Errorf("unexpected comparison with nil")
+	}
+
+	// A model that cannot be written still equals itself.
+	g = &FormatModel{Chunks: []Chunk{&ChunkProperty{DataType: 0xFF}}}
+	if !g.Equal(g) {
+		t.Errorf("expected unwritable model to equal itself")
+	}
+}
+
+// lz4Literal returns b as an lz4 block consisting of a single sequence of
+// literals. This is valid, but differs from the output of the lz4 encoder.
+func lz4Literal(b []byte) []byte {
+	n := len(b)
+	if n < 15 {
+		return append([]byte{byte(n << 4)}, b...)
+	}
+	c := []byte{0xF0}
+	for n -= 15; n >= 255; n -= 255 {
+		c = append(c, 255)
+	}
+	c = append(c, byte(n))
+	return append(c, b...)
+}
+
+func TestFormatModel_RetainCompressed(t *testing.T) {
+	b, err := ioutil.ReadFile("cframe.rbxl")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %s", err)
+	}
+	f := new(FormatModel)
+	if _, err := f.ReadFrom(bytes.NewReader(b)); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	// Rewrite the fixture so that each compressed chunk is compressed
+	// differently than the encoder would compress it.
+	var file bytes.Buffer
+	file.Write(b[:32])
+	for _, chunk := range f.Chunks {
+		var payload bytes.Buffer
+		if _, err := chunk.WriteTo(&payload); err != nil {
+			t.Fatalf("failed to write chunk: %s", err)
+		}
+		sig := chunk.Signature()
+		file.Write(sig[:])
+		if chunk.Compressed() {
+			data := lz4Literal(payload.Bytes())
+			binary.Write(&file, binary.LittleEndian, [3]uint32{uint32(len(data)), uint32(payload.Len()), 0})
+			file.Write(data)
+		} else {
+			binary.Write(&file, binary.LittleEndian, [3]uint32{0, uint32(payload.Len()), 0})
+			file.Write(payload.Bytes())
+		}
+	}
+	b = file.Bytes()
+
+	for _, retain := range []bool{false, true} {
+		f := &FormatModel{RetainCompressed: retain}
+		if _, err := f.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Fatalf("retain %t: failed to decode: %s", retain, err)
+		}
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("retain %t: failed to encode: %s", retain, err)
+		}
+		if equal := bytes.Equal(buf.Bytes(), b); equal != retain {
+			t.Errorf("retain %t: expected output equal to input to be %t", retain, retain)
+		}
+
+		if !retain {
+			continue
+		}
+		// A modified chunk is compressed again.
+		for _, chunk := range f.Chunks {
+			if chunk, ok := chunk.(*ChunkInstance); ok {
+				chunk.ClassName += "!"
+			}
+		}
+		buf.Reset()
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("failed to encode modified: %s", err)
+		}
+		g := new(FormatModel)
+		if _, err := g.ReadFrom(&buf); err != nil {
+			t.Fatalf("failed to decode modified: %s", err)
+		}
+		for i, chunk := range g.Chunks {
+			if chunk, ok := chunk.(*ChunkInstance); ok && !strings.HasSuffix(chunk.ClassName, "!") {
+				t.Errorf("chunk %d: expected modified class name, got %s", i, chunk.ClassName)
+			}
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	f := &FormatModel{
+		Chunks: []Chunk{
+			&ChunkParent{
+				IsCompressed: true,
+				Children:     []int32{0, 1, 2},
+				Parents:      []int32{-1, 0, 0},
+			},
+			&ChunkEnd{
+				IsCompressed: false,
+				Content:      []byte("</roblox>"),
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	b := buf.Bytes()
+
+	if err := Verify(bytes.NewReader(b)); err != nil {
+		t.Errorf("expected valid file, got error: %s", err)
+	}
+
+	// Truncated at every point within the file.
+	for i := 0; i < len(b); i++ {
+		if err := Verify(bytes.NewReader(b[:i])); err == nil {
+			t.Errorf("expected error for file truncated to %d bytes", i)
+		}
+	}
+
+	// Without end chunk.
+	f.Chunks = f.Chunks[:1]
+	buf.Reset()
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if err := Verify(bytes.NewReader(buf.Bytes())); err != ErrNoEndChunk {
+		t.Errorf("expected missing end chunk error, got: %v", err)
+	}
+
+	if err := Verify(bytes.NewReader([]byte("<roblox!"))); err == nil {
+		t.Error("expected error for truncated header")
+	}
+	if err := Verify(bytes.NewReader(app("<roblox ", BinaryHeader))); err != ErrInvalidSig {
+		t.Errorf("expected invalid signature error, got: %v", err)
+	}
+}
+
+func TestSerializer_DeserializeAll(t *testing.T) {
+	names := []string{"First", "Second", "Third", "Fourth"}
+
+	var buf bytes.Buffer
+	for i, name := range names {
+		inst := rbxfile.NewInstance("Part", nil)
+		inst.SetName(name)
+		root := &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}
+		// Binary files followed by XML documents.
+		var err error
+		if i < 2 {
+			err = SerializeModel(&buf, nil, root)
+		} else {
+			err = xml.Serialize(&buf, nil, root)
+			buf.WriteString("\n")
+		}
+		if err != nil {
+			t.Fatalf("failed to serialize %s: %s", name, err)
+		}
+	}
+
+	roots, err := NewSerializer(nil, nil).DeserializeAll(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(roots) != len(names) {
+		t.Fatalf("expected %d roots, got %d", len(names), len(roots))
+	}
+	for i, root := range roots {
+		if len(root.Instances) != 1 || root.Instances[0].Name() != names[i] {
+			t.Errorf("root %d: expected single instance named %s", i, names[i])
+		}
+	}
+}
+
+func TestSerializer_DeserializeGzip(t *testing.T) {
+	inst := rbxfile.NewInstance("Part", nil)
+	inst.SetName("Compressed")
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := SerializeModel(zw, nil, root); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress: %s", err)
+	}
+	b := buf.Bytes()
+
+	root, err := DeserializeModel(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("failed to deserialize: %s", err)
+	}
+	if len(root.Instances) != 1 || root.Instances[0].Name() != "Compressed" {
+		t.Errorf("expected single instance named Compressed")
+	}
+
+	roots, err := NewSerializer(nil, nil).DeserializeAll(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %s", err)
+	}
+	if len(roots) != 1 {
+		t.Errorf("expected 1 root, got %d", len(roots))
+	}
+
+	// Truncated gzip stream.
+	if _, err := DeserializeModel(bytes.NewReader(b[:len(b)/2]), nil); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestFormatModel_SkipProperties(t *testing.T) {
+	b, err := ioutil.ReadFile("cframe.rbxl")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %s", err)
+	}
+
+	full := new(FormatModel)
+	if _, err := full.ReadFrom(bytes.NewReader(b)); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	skip := &FormatModel{SkipProperties: true}
+	n, err := skip.ReadFrom(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if n != int64(len(b)) {
+		t.Errorf("expected %d bytes read, got %d", len(b), n)
+	}
+	if len(skip.Warnings) > 0 {
+		t.Errorf("unexpected warnings: %v", skip.Warnings)
+	}
+	for _, chunk := range skip.Chunks {
+		if _, ok := chunk.(*ChunkProperty); ok {
+			t.Fatalf("unexpected property chunk")
+		}
+	}
+
+	fullRoot, err := RobloxCodec{}.Decode(full)
+	if err != nil {
+		t.Fatalf("failed to decode full model: %s", err)
+	}
+	skipRoot, err := RobloxCodec{}.Decode(skip)
+	if err != nil {
+		t.Fatalf("failed to decode structure: %s", err)
+	}
+	var compare func(path string, a, b []*rbxfile.Instance)
+	compare = func(path string, a, b []*rbxfile.Instance) {
+		if len(a) != len(b) {
+			t.Fatalf("%s: expected %d children, got %d", path, len(a), len(b))
+		}
+		for i := range a {
+			if a[i].ClassName != b[i].ClassName {
+				t.Errorf("%s[%d]: expected class %s, got %s", path, i, a[i].ClassName, b[i].ClassName)
+			}
+			if len(b[i].Properties) > 0 {
+				t.Errorf("%s[%d]: expected no properties", path, i)
+			}
+			compare(path+"."+a[i].ClassName, a[i].Children, b[i].Children)
+		}
+	}
+	compare("root", fullRoot.Instances, skipRoot.Instances)
+
+	// Truncated property chunks are still detected.
+	if _, err := skip.ReadFrom(bytes.NewReader(b[:len(b)-40])); err == nil {
+		t.Errorf("expected error for truncated file")
+	}
+}
+
+func TestFormatModel_ReadHeaderVariants(t *testing.T) {
+	// Variants are generated from the output of the encoder. Files saved by
+	// Studio are tested by TestFormatModel_ReadStudioFiles.
+	var buf bytes.Buffer
+	if _, err := encodeTestModel(t).WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write model: %s", err)
+	}
+	orig := buf.Bytes()
+	headerLen := len(RobloxSig + BinaryMarker + BinaryHeader)
+	variant := func(fn func(b []byte) []byte) []byte {
+		return fn(append([]byte{}, orig...))
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		warn error
+	}{
+		{"unmodified", orig, nil},
+		{"non-zero header reserved", variant(func(b []byte) []byte {
+			b[headerLen+2+4+4] = 1
+			return b
+		}), WarnReserveNonZero},
+		{"non-zero chunk reserved", variant(func(b []byte) []byte {
+			b[headerLen+2+4+4+8+4+4+4] = 1
+			return b
+		}), nil},
+		{"trailing padding", variant(func(b []byte) []byte {
+			return append(b, 0, 0, 0, 0)
+		}), nil},
+	} {
+		f := new(FormatModel)
+		if _, err := f.ReadFrom(bytes.NewReader(test.data)); err != nil {
+			t.Errorf("%s: failed to read: %s", test.name, err)
+			continue
+		}
+		if test.warn != nil && !hasWarning(f, test.warn) {
+			t.Errorf("%s: expected warning %q", test.name, test.warn)
+		} else if test.warn == nil && len(f.Warnings) > 0 {
+			t.Errorf("%s: unexpected warnings: %v", test.name, f.Warnings)
+		}
+		if _, err := (RobloxCodec{}).Decode(f); err != nil {
+			t.Errorf("%s: failed to decode: %s", test.name, err)
+		}
+		if err := Verify(bytes.NewReader(test.data)); err != nil {
+			t.Errorf("%s: failed to verify: %s", test.name, err)
+		}
+	}
+
+	// A header whose line endings were converted in transfer is corrupt.
+	mangled := bytes.Replace(orig[:headerLen], []byte("\r\n"), []byte("\n"), 1)
+	mangled = append(mangled, orig[headerLen:]...)
+	if _, err := new(FormatModel).ReadFrom(bytes.NewReader(mangled)); err != ErrCorruptHeader {
+		t.Errorf("expected corrupt header error, got %v", err)
+	}
+}
+
+// studioFiles returns the paths of binary files saved by Studio that are
+// available as fixtures. Files saved by other versions of Studio may be added
+// to testdata/studio.
+func studioFiles(tb testing.TB) []string {
+	files, err := filepath.Glob(filepath.Join("testdata", "studio", "*.rbx[lm]"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return append([]string{"cframe.rbxl"}, files...)
+}
+
+func TestFormatModel_ReadStudioFiles(t *testing.T) {
+	for _, file := range studioFiles(t) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read fixture: %s", err)
+		}
+		f := new(FormatModel)
+		if _, err := f.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Errorf("%s: failed to read: %s", file, err)
+			continue
+		}
+		if len(f.Warnings) > 0 {
+			t.Errorf("%s: unexpected warnings: %v", file, f.Warnings)
+		}
+		if _, err := (RobloxCodec{}).Decode(f); err != nil {
+			t.Errorf("%s: failed to decode: %s", file, err)
+		}
+		if err := Verify(bytes.NewReader(b)); err != nil {
+			t.Errorf("%s: failed to verify: %s", file, err)
+		}
+	}
+}
+
+func TestSerializer_DeserializeErrorClasses(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := encodeTestModel(t).WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write model: %s", err)
+	}
+	model := buf.Bytes()
+	header := app(RobloxSig, BinaryMarker, BinaryHeader, 0, 0, make([]byte, 16))
+
+	deserialize := func(codec RobloxCodec, b []byte) error {
+		_, err := NewSerializer(codec, codec).Deserialize(bytes.NewReader(b))
+		return err
+	}
+
+	// Bad header.
+	err := deserialize(RobloxCodec{}, app(RobloxSig, BinaryMarker, make([]byte, len(BinaryHeader))))
+	var decodeErr *rbxfile.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Op != "parsing format" {
+		t.Errorf("bad header: expected DecodeError, got %v", err)
+	}
+	var classErr *rbxfile.ClassError
+	if !errors.As(err, &classErr) || classErr != ErrCorruptHeader {
+		t.Errorf("bad header: expected ErrCorruptHeader, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrFormat) {
+		t.Errorf("bad header: expected ErrFormat class, got %v", err)
+	}
+
+	// Unrecognized version.
+	err = deserialize(RobloxCodec{}, app(RobloxSig, BinaryMarker, BinaryHeader, 255, 1))
+	var versionErr ErrUnrecognizedVersion
+	if !errors.As(err, &versionErr) || versionErr != 511 {
+		t.Errorf("bad version: expected ErrUnrecognizedVersion, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrVersion) {
+		t.Errorf("bad version: expected ErrVersion class, got %v", err)
+	}
+
+	// Truncated data.
+	err = deserialize(RobloxCodec{}, model[:len(header)+4])
+	if !errors.Is(err, rbxfile.ErrTruncated) {
+		t.Errorf("truncated: expected ErrTruncated class, got %v", err)
+	}
+
+	// Corrupt chunk, which is an error only when strict.
+	end := app("END\x00", 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, "</roblox>")
+	_, err = (&FormatModel{Strict: true}).ReadFrom(bytes.NewReader(app(header, "INST", make([]byte, 12), end)))
+	var chunkErr ErrChunk
+	if !errors.As(err, &chunkErr) || string(chunkErr.Sig[:]) != "INST" {
+		t.Errorf("corrupt chunk: expected ErrChunk, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrCorrupt) {
+		t.Errorf("corrupt chunk: expected ErrCorrupt class, got %v", err)
+	}
+
+	// Exceeded limit.
+	err = deserialize(RobloxCodec{MaxInstances: 1}, model)
+	var maxErr rbxfile.ErrMaxInstances
+	if !errors.As(err, &maxErr) || maxErr.Max != 1 {
+		t.Errorf("limit: expected ErrMaxInstances, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrLimit) {
+		t.Errorf("limit: expected ErrLimit class, got %v", err)
+	}
+}
+
+func TestFormatModel_InspectChunks(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := encodeTestModel(t).WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write model: %s", err)
+	}
+	b := buf.Bytes()
+
+	full := new(FormatModel)
+	if _, err := full.ReadFrom(bytes.NewReader(b)); err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	inspect := &FormatModel{InspectChunks: true}
+	n, err := inspect.ReadFrom(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to inspect: %s", err)
+	}
+	if n != int64(len(b)) {
+		t.Errorf("expected %d bytes read, got %d", len(b), n)
+	}
+	if len(inspect.Chunks) > 0 {
+		t.Errorf("unexpected chunks")
+	}
+	if len(inspect.RawChunks) != len(full.Chunks) {
+		t.Fatalf("expected %d raw chunks, got %d", len(full.Chunks), len(inspect.RawChunks))
+	}
+	for i, info := range inspect.RawChunks {
+		if info.Signature != full.Chunks[i].Signature() {
+			t.Errorf("chunk %d: expected signature %q, got %q", i, full.Chunks[i].Signature(), info.Signature)
+		}
+		if size, _ := full.ChunkSize(i); info.Size != size {
+			t.Errorf("chunk %d: expected size %v, got %v", i, size, info.Size)
+		}
+		if info.Compressed() != full.Chunks[i].Compressed() {
+			t.Errorf("chunk %d: expected compressed %t", i, full.Chunks[i].Compressed())
+		}
+	}
+
+	// A chunk that claims far more decompressed data than its compressed
+	// payload could produce.
+	header := app(RobloxSig, BinaryMarker, BinaryHeader, 0, 0, make([]byte, 16))
+	corrupt := app(header,
+		"INST", 2, 0, 0, 0, 0, 0, 0xA0, 0, 0, 0, 0, 0, 0xFF, 0xFF,
+		"END\x00", 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, "</roblox>",
+	)
+	if _, err := new(FormatModel).ReadFrom(bytes.NewReader(corrupt)); err == nil {
+		t.Fatalf("expected error reading corrupt chunk")
+	}
+	if _, err := inspect.ReadFrom(bytes.NewReader(corrupt)); err != nil {
+		t.Fatalf("failed to inspect corrupt chunk: %s", err)
+	}
+	expected := []RawChunkInfo{
+		{Offset: int64(len(header)), Signature: [4]byte{'I', 'N', 'S', 'T'}, Size: ChunkSize{Compressed: 2, Decompressed: 10 << 20}},
+		{Offset: int64(len(header)) + 18, Signature: [4]byte{'E', 'N', 'D', 0}, Size: ChunkSize{Decompressed: 9}},
+	}
+	if !reflect.DeepEqual(inspect.RawChunks, expected) {
+		t.Errorf("expected raw chunks %v, got %v", expected, inspect.RawChunks)
+	}
+
+	// A file whose last chunk is cut off within its payload.
+	truncated := app(header, "INST", 0, 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, make([]byte, 10))
+	if _, err := inspect.ReadFrom(bytes.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+	expected = []RawChunkInfo{
+		{Offset: int64(len(header)), Signature: [4]byte{'I', 'N', 'S', 'T'}, Size: ChunkSize{Decompressed: 64}},
+	}
+	if !reflect.DeepEqual(inspect.RawChunks, expected) {
+		t.Errorf("expected truncated raw chunks %v, got %v", expected, inspect.RawChunks)
+	}
+}
@@ -48,6 +48,9 @@ const (
 	TypeRect2D             Type = 0x18
 	TypePhysicalProperties Type = 0x19
 	TypeColor3uint8        Type = 0x1A
+	TypeInt64              Type = 0x1B
+	TypeUniqueId           Type = 0x1F
+	TypeFont               Type = 0x20
 )
 
 var typeStrings = map[Type]string{
@@ -77,6 +80,9 @@ var typeStrings = map[Type]string{
 	TypeRect2D:             "Rect2D",
 	TypePhysicalProperties: "PhysicalProperties",
 	TypeColor3uint8:        "Color3uint8",
+	TypeInt64:              "Int64",
+	TypeUniqueId:           "UniqueId",
+	TypeFont:               "Font",
 }
 
 // Value is a property value of a certain Type.
@@ -139,6 +145,35 @@ var valueGenerators = map[Type]valueGenerator{
 	TypeRect2D:             newValueRect2D,
 	TypePhysicalProperties: newValuePhysicalProperties,
 	TypeColor3uint8:        newValueColor3uint8,
+	TypeInt64:              newValueInt64,
+	TypeUniqueId:           newValueUniqueId,
+	TypeFont:               newValueFont,
+}
+
+// ErrTypeRegistered is returned by RegisterValueType when a type is already
+// registered.
+var ErrTypeRegistered = errors.New("type is already registered")
+
+// RegisterValueType registers a new Value type with the given identifier,
+// allowing the type to be decoded and encoded in property chunks. name is
+// returned by Type.String, and gen generates a new Value of the type.
+//
+// If the type is already registered, including built-in types, then
+// ErrTypeRegistered is returned, unless override is true, in which case the
+// existing type is replaced.
+//
+// RegisterValueType is not safe to call concurrently with other functions
+// in the package, and should be called during initialization.
+func RegisterValueType(typ Type, name string, gen func() Value, override bool) error {
+	if gen == nil {
+		return errors.New("generator is nil")
+	}
+	if _, ok := valueGenerators[typ]; ok && !override {
+		return ErrTypeRegistered
+	}
+	valueGenerators[typ] = gen
+	typeStrings[typ] = name
+	return nil
 }
 
 ////////////////////////////////////////////////////////////////
@@ -342,6 +377,14 @@ func decodeZigzag(n uint32) int32 {
 	return int32((n >> 1) ^ uint32((int32(n&1)<<31)>>31))
 }
 
+func encodeZigzag64(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func decodeZigzag64(n uint64) int64 {
+	return int64((n >> 1) ^ uint64((int64(n&1)<<63)>>63))
+}
+
 // Encodes a Binary32 float with sign at LSB instead of MSB.
 func encodeRobloxFloat(f float32) uint32 {
 	n := math.Float32bits(f)
@@ -1999,3 +2042,205 @@ func (v ValueColor3uint8) fieldGet(i int) (b []byte) {
 }
 
 ////////////////////////////////////////////////////////////////
+
+type ValueFont struct {
+	Family       ValueString
+	Weight       uint16
+	Style        uint8
+	CachedFaceId ValueString
+}
+
+func newValueFont() Value {
+	return new(ValueFont)
+}
+
+func (ValueFont) Type() Type {
+	return TypeFont
+}
+
+func (v *ValueFont) ArrayBytes(a []Value) (b []byte, err error) {
+	return appendValueBytes(v.Type(), a)
+}
+
+// Fonts are not interleaved. Each value is written in full, one after the
+// other.
+func (v ValueFont) FromArrayBytes(b []byte) (a []Value, err error) {
+	for len(b) > 0 {
+		font := new(ValueFont)
+		n, err := font.read(b)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, font)
+		b = b[n:]
+	}
+	return a, nil
+}
+
+func (v ValueFont) Bytes() []byte {
+	b := make([]byte, 0, 4+len(v.Family)+3+4+len(v.CachedFaceId))
+	b = append(b, v.Family.Bytes()...)
+	b = append(b, byte(v.Weight), byte(v.Weight>>8))
+	b = append(b, v.Style)
+	b = append(b, v.CachedFaceId.Bytes()...)
+	return b
+}
+
+func (v *ValueFont) FromBytes(b []byte) error {
+	n, err := v.read(b)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("expected array length of %d", n)
+	}
+	return nil
+}
+
+// Reads a single font from the start of b, returning the number of bytes
+// read.
+func (v *ValueFont) read(b []byte) (n int, err error) {
+	readString := func(s *ValueString) error {
+		if len(b[n:]) < 4 {
+			return errors.New("expected 4 more bytes in array")
+		}
+		length := int(binary.LittleEndian.Uint32(b[n:]))
+		if len(b[n+4:]) < length {
+			return fmt.Errorf("expected %d more bytes in array", length)
+		}
+		if err := s.FromBytes(b[n : n+4+length]); err != nil {
+			return err
+		}
+		n += 4 + length
+		return nil
+	}
+
+	if err = readString(&v.Family); err != nil {
+		return 0, err
+	}
+	if len(b[n:]) < 3 {
+		return 0, errors.New("expected 3 more bytes in array")
+	}
+	v.Weight = binary.LittleEndian.Uint16(b[n:])
+	v.Style = b[n+2]
+	n += 3
+	if err = readString(&v.CachedFaceId); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+////////////////////////////////////////////////////////////////
+
+type ValueUniqueId struct {
+	Index  uint32
+	Time   uint32
+	Random int64
+}
+
+func newValueUniqueId() Value {
+	return new(ValueUniqueId)
+}
+
+func (ValueUniqueId) Type() Type {
+	return TypeUniqueId
+}
+
+func (v ValueUniqueId) ArrayBytes(a []Value) (b []byte, err error) {
+	return interleaveFields(v.Type(), a)
+}
+
+func (v ValueUniqueId) FromArrayBytes(b []byte) (a []Value, err error) {
+	return deinterleaveFields(v.Type(), b)
+}
+
+func (v ValueUniqueId) Bytes() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b[0:4], v.Index)
+	binary.BigEndian.PutUint32(b[4:8], v.Time)
+	binary.BigEndian.PutUint64(b[8:16], encodeZigzag64(v.Random))
+	return b
+}
+
+func (v *ValueUniqueId) FromBytes(b []byte) error {
+	if len(b) != 16 {
+		return errors.New("array length must be 16")
+	}
+
+	v.Index = binary.BigEndian.Uint32(b[0:4])
+	v.Time = binary.BigEndian.Uint32(b[4:8])
+	v.Random = decodeZigzag64(binary.BigEndian.Uint64(b[8:16]))
+
+	return nil
+}
+
+// The entire value is interleaved as a single field.
+func (ValueUniqueId) fieldLen() []int {
+	return []int{16}
+}
+
+func (v *ValueUniqueId) fieldSet(i int, b []byte) (err error) {
+	return v.FromBytes(b)
+}
+
+func (v ValueUniqueId) fieldGet(i int) (b []byte) {
+	return v.Bytes()
+}
+
+////////////////////////////////////////////////////////////////
+
+type ValueInt64 int64
+
+func newValueInt64() Value {
+	return new(ValueInt64)
+}
+
+func (ValueInt64) Type() Type {
+	return TypeInt64
+}
+
+func (v *ValueInt64) ArrayBytes(a []Value) (b []byte, err error) {
+	b, err = appendValueBytes(v.Type(), a)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = interleave(b, 8); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (v ValueInt64) FromArrayBytes(b []byte) (a []Value, err error) {
+	bc := make([]byte, len(b))
+	copy(bc, b)
+	if err = deinterleave(bc, 8); err != nil {
+		return nil, err
+	}
+
+	a, err = appendByteValues(v.Type(), bc, 8, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (v ValueInt64) Bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, encodeZigzag64(int64(v)))
+	return b
+}
+
+func (v *ValueInt64) FromBytes(b []byte) error {
+	if len(b) != 8 {
+		return errors.New("array length must be 8")
+	}
+
+	*v = ValueInt64(decodeZigzag64(binary.BigEndian.Uint64(b)))
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
@@ -0,0 +1,455 @@
+package bin
+
+import (
+	"bytes"
+	"github.com/robloxapi/rbxfile"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testArrayRoundTrip checks that an array of values encodes to the expected
+// bytes, and that the bytes decode back to the same values.
+func testArrayRoundTrip(t *testing.T, typ Type, values []Value, expected []byte) {
+	b, err := NewValue(typ).ArrayBytes(values)
+	if err != nil {
+		t.Fatalf("%s: unexpected encode error: %s", typ, err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("%s: unexpected bytes:\n\texpected: % 02x\n\tgot:      % 02x", typ, expected, b)
+	}
+
+	a, err := NewValue(typ).FromArrayBytes(b)
+	if err != nil {
+		t.Fatalf("%s: unexpected decode error: %s", typ, err)
+	}
+	if !reflect.DeepEqual(a, values) {
+		t.Errorf("%s: values do not match:\n\texpected: %v\n\tgot:      %v", typ, values, a)
+	}
+}
+
+func TestValueInt(t *testing.T) {
+	values := []Value{}
+	for _, v := range []int32{0, 1, -1, math.MaxInt32, math.MinInt32, 1000, -1000, 150} {
+		v := ValueInt(v)
+		values = append(values, &v)
+	}
+	// Each value is zigzag-encoded, then interleaved.
+	data := []byte{
+		0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0xff, 0xff, 0x07, 0x07, 0x01,
+		0x00, 0x02, 0x01, 0xfe, 0xff, 0xd0, 0xcf, 0x2c,
+	}
+	testArrayRoundTrip(t, TypeInt, values, data)
+
+	// Property chunk containing the same values.
+	chunk := app(
+		3, 0, 0, 0,
+		5, 0, 0, 0, "Value",
+		byte(TypeInt),
+		data,
+	)
+	c := new(ChunkProperty)
+	if _, err := c.ReadFrom(bytes.NewReader(chunk)); err != nil {
+		t.Fatalf("failed to read chunk: %s", err)
+	}
+	if c.TypeID != 3 || c.PropertyName != "Value" || !reflect.DeepEqual(c.Properties, values) {
+		t.Errorf("unexpected chunk: %d %s %v", c.TypeID, c.PropertyName, c.Properties)
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write chunk: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), chunk) {
+		t.Errorf("unexpected chunk bytes:\n\texpected: % 02x\n\tgot:      % 02x", chunk, buf.Bytes())
+	}
+}
+
+func TestValueInt64(t *testing.T) {
+	values := []Value{}
+	for _, v := range []int64{0, -1, 1, 1 << 62, math.MinInt64, math.MaxInt64, 123456789012, -123456789012} {
+		v := ValueInt64(v)
+		values = append(values, &v)
+	}
+	testArrayRoundTrip(t, TypeInt64, values, []byte{
+		0x00, 0x00, 0x00, 0x80, 0xff, 0xff, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x39, 0x39,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x7d, 0x7d,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x32, 0x32,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x34, 0x34,
+		0x00, 0x01, 0x02, 0x00, 0xff, 0xfe, 0x28, 0x27,
+	})
+}
+
+func TestValueInt_Studio(t *testing.T) {
+	testStudioProperties(t, TypeInt, TypeInt64)
+}
+
+// testStudioProperties checks that each property chunk of the given types,
+// read from the files in studioFiles, writes back to the bytes saved by Studio.
+// The test is skipped if no file contains such a chunk.
+func testStudioProperties(t *testing.T, types ...Type) {
+	found := false
+	for _, file := range studioFiles(t) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read fixture: %s", err)
+		}
+		f := &FormatModel{RetainCompressed: true}
+		if _, err := f.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: failed to read: %s", file, err)
+		}
+		for i, chunk := range f.Chunks {
+			chunk, ok := chunk.(*ChunkProperty)
+			if !ok {
+				continue
+			}
+			for _, typ := range types {
+				if chunk.DataType != typ {
+					continue
+				}
+				found = true
+				var buf bytes.Buffer
+				if _, err := chunk.WriteTo(&buf); err != nil {
+					t.Errorf("%s: %s: failed to write chunk: %s", file, chunk.PropertyName, err)
+				} else if !bytes.Equal(buf.Bytes(), f.retained[i].payload) {
+					t.Errorf("%s: %s: chunk does not match bytes saved by Studio", file, chunk.PropertyName)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Skipf("no Studio fixture contains properties of type %v", types)
+	}
+}
+
+func TestValueNumberRange(t *testing.T) {
+	testArrayRoundTrip(t, TypeNumberRange,
+		[]Value{
+			&ValueNumberRange{Min: 0, Max: 1},
+			&ValueNumberRange{Min: 0.5, Max: 2},
+		},
+		[]byte{
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x3f,
+			0x00, 0x00, 0x00, 0x3f, 0x00, 0x00, 0x00, 0x40,
+		},
+	)
+}
+
+func TestValueNumberSequence(t *testing.T) {
+	testArrayRoundTrip(t, TypeNumberSequence,
+		[]Value{
+			&ValueNumberSequence{
+				{Time: 0, Value: 1, Envelope: 0},
+				{Time: 1, Value: 0.5, Envelope: 0.25},
+			},
+			&ValueNumberSequence{},
+		},
+		[]byte{
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x3f, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x80, 0x3f, 0x00, 0x00, 0x00, 0x3f, 0x00, 0x00, 0x80, 0x3e,
+			0x00, 0x00, 0x00, 0x00,
+		},
+	)
+}
+
+func TestValueColorSequence(t *testing.T) {
+	testArrayRoundTrip(t, TypeColorSequence,
+		[]Value{
+			&ValueColorSequence{
+				{Time: 0, Value: ValueColor3{R: 1, G: 0.5, B: 0}, Envelope: 0},
+				{Time: 1, Value: ValueColor3{R: 0, G: 0.25, B: 1}, Envelope: 0},
+			},
+		},
+		[]byte{
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x80, 0x3f, 0x00, 0x00, 0x00, 0x3f, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x80, 0x3f,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x3e, 0x00, 0x00, 0x80, 0x3f,
+			0x00, 0x00, 0x00, 0x00,
+		},
+	)
+}
+
+func TestValueSequences_Studio(t *testing.T) {
+	testStudioProperties(t, TypeNumberRange, TypeNumberSequence, TypeColorSequence)
+}
+
+func TestValueRect2D(t *testing.T) {
+	testArrayRoundTrip(t, TypeRect2D,
+		[]Value{
+			&ValueRect2D{
+				Min: ValueVector2{X: 0.5, Y: 0.25},
+				Max: ValueVector2{X: 1.5, Y: 100.75},
+			},
+			&ValueRect2D{
+				Min: ValueVector2{X: -2.125, Y: 0},
+				Max: ValueVector2{X: 3.375, Y: 4.5},
+			},
+		},
+		[]byte{
+			// Min.X
+			0x7e, 0x80, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01,
+			// Min.Y
+			0x7d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			// Max.X
+			0x7f, 0x80, 0x80, 0xb0, 0x00, 0x00, 0x00, 0x00,
+			// Max.Y
+			0x85, 0x81, 0x93, 0x20, 0x00, 0x00, 0x00, 0x00,
+		},
+	)
+}
+
+func TestValueFont(t *testing.T) {
+	const family = "rbxasset://fonts/families/SourceSansPro.json"
+	const face = "rbxasset://fonts/SourceSansPro-BoldItalic.ttf"
+
+	font := rbxfile.ValueFont{
+		Family:       rbxfile.ValueContent(family),
+		Weight:       700,
+		Style:        rbxfile.FontStyleItalic,
+		CachedFaceId: rbxfile.ValueContent(face),
+	}
+	testArrayRoundTrip(t, TypeFont,
+		[]Value{
+			encodeValue(nil, font),
+			&ValueFont{Family: ValueString{}, Weight: 400, Style: 0, CachedFaceId: ValueString{}},
+		},
+		app(
+			len(family), 0, 0, 0, family,
+			0xBC, 0x02,
+			1,
+			len(face), 0, 0, 0, face,
+
+			0, 0, 0, 0,
+			0x90, 0x01,
+			0,
+			0, 0, 0, 0,
+		),
+	)
+
+	if v := decodeValue(TypeFont.String(), nil, encodeValue(nil, font)); !reflect.DeepEqual(v, font) {
+		t.Errorf("font does not match after round-trip:\n\texpected: %v\n\tgot:      %v", font, v)
+	}
+}
+
+func TestValueUniqueId(t *testing.T) {
+	// IDs share prefixes, and must remain distinct.
+	values := []Value{
+		&ValueUniqueId{Index: 1, Time: 0x02e9c68d, Random: 0x44b188dace632b47},
+		&ValueUniqueId{Index: 2, Time: 0x02e9c68d, Random: 0x44b188dace632b47},
+		&ValueUniqueId{Index: 2, Time: 0x02e9c68e, Random: 0x44b188dace632b47},
+		&ValueUniqueId{Index: 2, Time: 0x02e9c68e, Random: -0x44b188dace632b47},
+	}
+
+	if b := values[0].Bytes(); !bytes.Equal(b, []byte{
+		0x00, 0x00, 0x00, 0x01,
+		0x02, 0xe9, 0xc6, 0x8d,
+		0x89, 0x63, 0x11, 0xb5, 0x9c, 0xc6, 0x56, 0x8e,
+	}) {
+		t.Errorf("unexpected bytes: % 02x", b)
+	}
+
+	// Each 16-byte value is interleaved as a whole.
+	expected := make([]byte, 0, 16*len(values))
+	for i := 0; i < 16; i++ {
+		for _, v := range values {
+			expected = append(expected, v.Bytes()[i])
+		}
+	}
+	testArrayRoundTrip(t, TypeUniqueId, values, expected)
+}
+
+// testValue is a Value of a fake type, where each value is one byte.
+type testValue byte
+
+const testType Type = 0xF0
+
+func (testValue) Type() Type {
+	return testType
+}
+
+func (v testValue) Bytes() []byte {
+	return []byte{byte(v)}
+}
+
+func (v *testValue) FromBytes(b []byte) error {
+	*v = testValue(b[0])
+	return nil
+}
+
+func (testValue) FromArrayBytes(b []byte) (a []Value, err error) {
+	for _, c := range b {
+		v := testValue(c)
+		a = append(a, &v)
+	}
+	return a, nil
+}
+
+func (testValue) ArrayBytes(a []Value) (b []byte, err error) {
+	for _, v := range a {
+		b = append(b, v.Bytes()...)
+	}
+	return b, nil
+}
+
+func TestRegisterValueType(t *testing.T) {
+	gen := func() Value { return new(testValue) }
+	if err := RegisterValueType(TypeString, "Fake", gen, false); err != ErrTypeRegistered {
+		t.Fatalf("expected error when overwriting built-in type, got %v", err)
+	}
+	if TypeString.String() != "String" {
+		t.Fatalf("built-in type was overwritten")
+	}
+
+	if err := RegisterValueType(testType, "Fake", gen, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer func() {
+		delete(valueGenerators, testType)
+		delete(typeStrings, testType)
+	}()
+	if err := RegisterValueType(testType, "Fake", gen, false); err != ErrTypeRegistered {
+		t.Errorf("expected error when registering type twice, got %v", err)
+	}
+	if err := RegisterValueType(testType, "Fake", gen, true); err != nil {
+		t.Errorf("unexpected error when overriding: %s", err)
+	}
+	if s := testType.String(); s != "Fake" {
+		t.Errorf("expected type name Fake, got %s", s)
+	}
+
+	chunk := new(ChunkProperty)
+	if _, err := chunk.ReadFrom(bytes.NewReader(app(
+		0, 0, 0, 0,
+		5, 0, 0, 0, "Value",
+		byte(testType),
+		1, 2, 3,
+	))); err != nil {
+		t.Fatalf("failed to read chunk: %s", err)
+	}
+	if chunk.DataType != testType {
+		t.Errorf("expected data type %s, got %s", testType, chunk.DataType)
+	}
+	expected := []Value{new(testValue), new(testValue), new(testValue)}
+	for i := range expected {
+		*expected[i].(*testValue) = testValue(i + 1)
+	}
+	if !reflect.DeepEqual(chunk.Properties, expected) {
+		t.Errorf("unexpected properties: %v", chunk.Properties)
+	}
+}
+
+// randomVector3s returns n random Vector3 values, beginning with values whose
+// bytes are easily distinguished.
+func randomVector3s(n int, seed int64) []Value {
+	special := []ValueVector3{
+		{X: 0, Y: ValueFloat(math.Copysign(0, -1)), Z: 1},
+		{X: -1, Y: math.MaxFloat32, Z: math.SmallestNonzeroFloat32},
+		{X: ValueFloat(math.Inf(1)), Y: ValueFloat(math.Inf(-1)), Z: 0.5},
+	}
+	r := rand.New(rand.NewSource(seed))
+	a := make([]Value, n)
+	for i := range a {
+		v := new(ValueVector3)
+		if i < len(special) {
+			*v = special[i]
+		} else {
+			v.X = ValueFloat(r.NormFloat64() * 1000)
+			v.Y = ValueFloat(r.NormFloat64() * 1000)
+			v.Z = ValueFloat(r.NormFloat64() * 1000)
+		}
+		a[i] = v
+	}
+	return a
+}
+
+// referenceVector3Bytes encodes an array of Vector3 values without using the
+// field interleaving of ArrayBytes. Each component forms a plane, within which
+// the big-endian bytes of every value's rotated float are grouped by byte
+// position.
+func referenceVector3Bytes(a []Value) []byte {
+	n := len(a)
+	b := make([]byte, n*12)
+	for i, v := range a {
+		v := v.(*ValueVector3)
+		for c, f := range []ValueFloat{v.X, v.Y, v.Z} {
+			u := encodeRobloxFloat(float32(f))
+			for j := 0; j < 4; j++ {
+				b[c*4*n+j*n+i] = byte(u >> uint(24-8*j))
+			}
+		}
+	}
+	return b
+}
+
+func TestValueVector3_ArrayBytes(t *testing.T) {
+	// Lengths that are smaller than, equal to, and larger than the size of a
+	// field, which the interleave transform handles differently.
+	for _, n := range []int{1, 3, 4, 5, 1000} {
+		values := randomVector3s(n, int64(n))
+		expected := referenceVector3Bytes(values)
+
+		b, err := ValueVector3{}.ArrayBytes(values)
+		if err != nil {
+			t.Fatalf("length %d: unexpected encode error: %s", n, err)
+		}
+		if len(b) != len(expected) {
+			t.Fatalf("length %d: expected %d bytes, got %d", n, len(expected), len(b))
+		}
+		for i := range b {
+			if b[i] != expected[i] {
+				t.Fatalf("length %d: interleaved byte %d of value %d of component %d is %02x, expected %02x",
+					n, (i%(n*4))/n, i%n, i/(n*4), b[i], expected[i])
+			}
+		}
+
+		a, err := ValueVector3{}.FromArrayBytes(expected)
+		if err != nil {
+			t.Fatalf("length %d: unexpected decode error: %s", n, err)
+		}
+		if len(a) != n {
+			t.Fatalf("length %d: expected %d values, got %d", n, n, len(a))
+		}
+		for i := range a {
+			if !reflect.DeepEqual(a[i], values[i]) {
+				t.Fatalf("length %d: value %d is %v, expected %v", n, i, a[i], values[i])
+			}
+		}
+	}
+}
+
+func BenchmarkValueVector3_ArrayBytes(b *testing.B) {
+	values := randomVector3s(100000, 1)
+	b.SetBytes(int64(len(values) * 12))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := (ValueVector3{}).ArrayBytes(values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValueVector3_FromArrayBytes(b *testing.B) {
+	data := referenceVector3Bytes(randomVector3s(100000, 1))
+	buf := make([]byte, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// FromArrayBytes transforms its input in place.
+		b.StopTimer()
+		copy(buf, data)
+		b.StartTimer()
+		if _, err := (ValueVector3{}).FromArrayBytes(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package rbxfile
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// csgMagics contains the signatures that begin the CSG binary sub-formats of
+// BinaryString properties, such as the PhysicsData and MeshData properties of
+// unions and mesh parts.
+var csgMagics = [][]byte{
+	[]byte("CSGPHS"), // PhysicsData.
+	[]byte("CSGMDL"), // MeshData.
+}
+
+// CSGVersion returns the version of the CSG binary sub-format of v. The value
+// is expected to begin with a CSG signature, followed by the version as a
+// little-endian 32-bit integer. Returns false if v does not begin with a
+// known signature, or is too short to contain the version. The remaining
+// content of v is not validated.
+func CSGVersion(v ValueBinaryString) (int, bool) {
+	for _, magic := range csgMagics {
+		if !bytes.HasPrefix(v, magic) {
+			continue
+		}
+		if len(v) < len(magic)+4 {
+			return 0, false
+		}
+		return int(binary.LittleEndian.Uint32(v[len(magic):])), true
+	}
+	return 0, false
+}
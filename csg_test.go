@@ -0,0 +1,28 @@
+package rbxfile
+
+import (
+	"testing"
+)
+
+func TestCSGVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+		ok      bool
+	}{
+		// Synthetic values following the layout read by CSGVersion.
+		{"physics", "CSGPHS\x03\x00\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00", 3, true},
+		{"physics v6", "CSGPHS\x06\x00\x00\x00", 6, true},
+		{"mesh", "CSGMDL\x02\x00\x00\x00\x7B\x2A", 2, true},
+		{"truncated", "CSGPHS\x03\x00", 0, false},
+		{"unknown", "CSGXXX\x03\x00\x00\x00", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, test := range tests {
+		version, ok := CSGVersion(ValueBinaryString(test.data))
+		if version != test.version || ok != test.ok {
+			t.Errorf("%s: expected (%d, %t), got (%d, %t)", test.name, test.version, test.ok, version, ok)
+		}
+	}
+}
@@ -14,6 +14,8 @@
 package declare
 
 import (
+	"fmt"
+	"github.com/robloxapi/rbxapi"
 	"github.com/robloxapi/rbxfile"
 )
 
@@ -21,28 +23,59 @@ import (
 // declarations.
 type Root []instance
 
+// build generates an instance and its descendants from an Instance
+// declaration. The tree is built iteratively rather than recursively, so that
+// deeply nested declarations do not exhaust the stack.
 func build(dinst instance, refs rbxfile.References, props map[*rbxfile.Instance][]property) *rbxfile.Instance {
-	inst := rbxfile.NewInstance(dinst.className, nil)
-
-	if dinst.reference != "" {
-		refs[dinst.reference] = inst
-		inst.Reference = dinst.reference
+	type frame struct {
+		dinst  instance
+		parent *rbxfile.Instance
 	}
 
-	inst.Properties = make(map[string]rbxfile.Value, len(dinst.properties))
-	props[inst] = dinst.properties
+	var root *rbxfile.Instance
+	stack := []frame{{dinst: dinst}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		inst := rbxfile.NewInstance(f.dinst.className, nil)
+
+		if f.dinst.reference != "" {
+			refs[f.dinst.reference] = inst
+			inst.Reference = f.dinst.reference
+		}
+
+		inst.Properties = make(map[string]rbxfile.Value, len(f.dinst.properties))
+		props[inst] = f.dinst.properties
 
-	for _, dchild := range dinst.children {
-		child := build(dchild, refs, props)
-		inst.AddChild(child)
+		if f.parent == nil {
+			root = inst
+		} else {
+			f.parent.AddChild(inst)
+		}
+
+		// Push in reverse, so that children are added in order.
+		for i := len(f.dinst.children) - 1; i >= 0; i-- {
+			stack = append(stack, frame{dinst: f.dinst.children[i], parent: inst})
+		}
 	}
 
-	return inst
+	return root
 }
 
 // Declare evaluates the Root declaration, generating instances and property
 // values, setting up the instance hierarchy, and resolving references.
 func (droot Root) Declare() *rbxfile.Root {
+	return droot.DeclareWithAPI(nil)
+}
+
+// DeclareWithAPI is like Declare, but additionally uses api to resolve the
+// values of Token properties given as the name of an enum item. The enum of
+// such a property is determined by the class of the instance the property
+// is declared in. If the name cannot be resolved, then the value of the
+// property will be zero, and no error is reported. Use DeclareChecked to
+// detect names that cannot be resolved.
+func (droot Root) DeclareWithAPI(api *rbxapi.API) *rbxfile.Root {
 	root := &rbxfile.Root{
 		Instances: make([]*rbxfile.Instance, 0, len(droot)),
 	}
@@ -56,6 +89,9 @@ func (droot Root) Declare() *rbxfile.Root {
 
 	for inst, properties := range props {
 		for _, prop := range properties {
+			if api != nil && prop.typ == Token {
+				prop, _ = resolveToken(api, inst.ClassName, prop)
+			}
 			inst.Properties[prop.name] = prop.typ.value(refs, prop.value)
 		}
 	}
@@ -63,6 +99,80 @@ func (droot Root) Declare() *rbxfile.Root {
 	return root
 }
 
+// DeclareChecked is like DeclareWithAPI, but first checks each property
+// declaration as with Property.DeclareChecked. The name of an enum item given
+// to a Token property must also be resolved by api. Returns an error for the
+// first property that fails the check, in the order of declaration, in which
+// case no root is generated.
+func (droot Root) DeclareChecked(api *rbxapi.API) (*rbxfile.Root, error) {
+	stack := make([]instance, 0, len(droot))
+	for i := len(droot) - 1; i >= 0; i-- {
+		stack = append(stack, droot[i])
+	}
+	for len(stack) > 0 {
+		dinst := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, prop := range dinst.properties {
+			if err := prop.typ.check(prop.value); err != nil {
+				return nil, fmt.Errorf("property %s.%s: %s", dinst.className, prop.name, err)
+			}
+			if prop.typ == Token {
+				if _, err := resolveToken(api, dinst.className, prop); err != nil {
+					return nil, fmt.Errorf("property %s.%s: %s", dinst.className, prop.name, err)
+				}
+			}
+		}
+		for i := len(dinst.children) - 1; i >= 0; i-- {
+			stack = append(stack, dinst.children[i])
+		}
+	}
+	return droot.DeclareWithAPI(api), nil
+}
+
+// resolveToken returns a copy of a Token property, with the name of an enum
+// item replaced by the item's value. The property is returned unchanged if
+// its value is not a name. If the name cannot be resolved, the value is
+// replaced with zero, and an error is returned.
+func resolveToken(api *rbxapi.API, className string, prop property) (property, error) {
+	if len(prop.value) == 0 {
+		return prop, nil
+	}
+	var name string
+	switch v := prop.value[0].(type) {
+	case string:
+		name = v
+	case []byte:
+		name = string(v)
+	default:
+		return prop, nil
+	}
+	prop.value = []interface{}{nil}
+	if api == nil {
+		return prop, fmt.Errorf("no API to resolve enum item %q", name)
+	}
+
+	for class := api.Classes[className]; class != nil; class = api.Classes[class.Superclass] {
+		for _, member := range class.MemberList() {
+			member, ok := member.(*rbxapi.Property)
+			if !ok || member.MemberName != prop.name {
+				continue
+			}
+			enum := api.Enums[member.ValueType]
+			if enum == nil {
+				return prop, fmt.Errorf("no enum %s to resolve item %q", member.ValueType, name)
+			}
+			for _, item := range enum.Items {
+				if item.Name == name {
+					prop.value[0] = item.Value
+					return prop, nil
+				}
+			}
+			return prop, fmt.Errorf("unknown item %q of enum %s", name, enum.Name)
+		}
+	}
+	return prop, fmt.Errorf("unknown property to resolve enum item %q", name)
+}
+
 type element interface {
 	element()
 }
@@ -80,23 +190,10 @@ func (instance) element() {}
 // descendants, and property values, setting up the instance hierarchy, and
 // resolving references.
 func (dinst instance) Declare() *rbxfile.Instance {
-	inst := rbxfile.NewInstance(dinst.className, nil)
-
 	refs := rbxfile.References{}
 	props := map[*rbxfile.Instance][]property{}
 
-	if dinst.reference != "" {
-		refs[dinst.reference] = inst
-		inst.Reference = dinst.reference
-	}
-
-	inst.Properties = make(map[string]rbxfile.Value, len(dinst.properties))
-	props[inst] = dinst.properties
-
-	for _, dchild := range dinst.children {
-		child := build(dchild, refs, props)
-		inst.AddChild(child)
-	}
+	inst := build(dinst, refs, props)
 
 	for inst, properties := range props {
 		for _, prop := range properties {
@@ -160,15 +257,19 @@ func (property) element() {}
 //
 // Otherwise, for a given type, values must be the following:
 //
-//     String, BinaryString, ProtectedString, Content:
+//     String, BinaryString, ProtectedString, Content, SharedString:
 //         A single string or []byte. Extra values are ignored.
 //
 //     Bool:
 //         A single bool. Extra values are ignored.
 //
-//     Int, Float, Double, BrickColor, Token:
+//     Int, Int64, Float, Double, BrickColor, Token:
 //         A single number. Extra values are ignored.
 //
+//         When declared with Root.DeclareWithAPI, a Token may also be a
+//         string or []byte, which is the name of an item of the property's
+//         enum.
+//
 //     UDim:
 //         2 numbers, corresponding to the Scale and Offset fields.
 //
@@ -251,6 +352,15 @@ func (property) element() {}
 //
 //     Color3uint8:
 //         3 numbers, corresponding to the R, G, and B fields.
+//
+//     Font:
+//         1) A string or []byte, and 2 numbers, corresponding to the Family,
+//            Weight, and Style fields.
+//         2) Same as 1, followed by a string or []byte corresponding to the
+//            CachedFaceId field.
+//
+//     UniqueId:
+//         3 numbers, corresponding to the Random, Time, and Index fields.
 func Property(name string, typ Type, value ...interface{}) property {
 	return property{name: name, typ: typ, value: value}
 }
@@ -263,6 +373,17 @@ func (prop property) Declare() rbxfile.Value {
 	return prop.typ.value(refs, prop.value)
 }
 
+// DeclareChecked is like Declare, but returns an error if a number in the
+// value does not fit within the corresponding field of the type, instead of
+// letting it wrap around. The components of Vector2int16 and Vector3int16
+// are checked against the range of an int16.
+func (prop property) DeclareChecked() (rbxfile.Value, error) {
+	if err := prop.typ.check(prop.value); err != nil {
+		return nil, err
+	}
+	return prop.Declare(), nil
+}
+
 // Ref declares a string that can be used to refer to the Instance under which
 // it was declared. This will also set the instance's Reference field.
 type Ref string
@@ -1,6 +1,11 @@
 package declare_test
 
-import . "github.com/robloxapi/rbxfile/declare"
+import (
+	"github.com/robloxapi/rbxapi"
+	"github.com/robloxapi/rbxfile"
+	. "github.com/robloxapi/rbxfile/declare"
+	"testing"
+)
 
 func Example() {
 	root := Root{
@@ -20,3 +25,151 @@ func Example() {
 		),
 	}.Declare()
 }
+
+func TestRoot_DeclareWithAPI(t *testing.T) {
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"BasePart": &rbxapi.Class{
+				Name: "BasePart",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Material", MemberClass: "BasePart", ValueType: "Material"},
+				},
+			},
+			"Part": &rbxapi.Class{
+				Name:       "Part",
+				Superclass: "BasePart",
+			},
+		},
+		Enums: map[string]*rbxapi.Enum{
+			"Material": &rbxapi.Enum{
+				Name: "Material",
+				Items: []*rbxapi.EnumItem{
+					{Enum: "Material", Name: "Plastic", Value: 256},
+					{Enum: "Material", Name: "Wood", Value: 512},
+				},
+			},
+		},
+	}
+
+	root := Root{
+		Instance("Part",
+			Property("Material", Token, "Plastic"),
+		),
+		Instance("Part",
+			Property("Material", Token, 512),
+		),
+		Instance("Part",
+			Property("Material", Token, "Unknown"),
+		),
+	}.DeclareWithAPI(api)
+
+	for i, expected := range []rbxfile.ValueToken{256, 512, 0} {
+		if v := root.Instances[i].Properties["Material"]; v != expected {
+			t.Errorf("instance %d: expected %v, got %#v", i, expected, v)
+		}
+	}
+
+	// Names that cannot be resolved are errors when checked.
+	for _, test := range []struct {
+		api   *rbxapi.API
+		value string
+		err   string
+	}{
+		{api, "Plastic", ""},
+		{api, "Unknown", `property Part.Material: unknown item "Unknown" of enum Material`},
+		{nil, "Plastic", `property Part.Material: no API to resolve enum item "Plastic"`},
+	} {
+		_, err := Root{Instance("Part", Property("Material", Token, test.value))}.DeclareChecked(test.api)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.value, test.err, err)
+		}
+	}
+}
+
+func TestInstance_DeclareDeep(t *testing.T) {
+	const depth = 5000
+
+	dinst := Instance("Folder", Property("Name", String, "Leaf"))
+	for i := 1; i < depth; i++ {
+		dinst = Instance("Folder",
+			Instance("Part"),
+			dinst,
+			Instance("Part"),
+		)
+	}
+
+	for _, root := range []*rbxfile.Instance{
+		dinst.Declare(),
+		Root{dinst}.Declare().Instances[0],
+	} {
+		n := 1
+		inst := root
+		for len(inst.Children) > 0 {
+			if len(inst.Children) != 3 || inst.Children[0].ClassName != "Part" || inst.Children[2].ClassName != "Part" {
+				t.Fatalf("depth %d: unexpected children %v", n, inst.Children)
+			}
+			inst = inst.Children[1]
+			n++
+		}
+		if n != depth {
+			t.Errorf("expected depth %d, got %d", depth, n)
+		}
+		if inst.Name() != "Leaf" {
+			t.Errorf("expected leaf to have name, got %q", inst.Name())
+		}
+	}
+}
+
+func TestProperty_DeclareChecked(t *testing.T) {
+	v, err := Property("Value", Vector3int16, 1, -32768, 32767).DeclareChecked()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v != (rbxfile.ValueVector3int16{X: 1, Y: -32768, Z: 32767}) {
+		t.Errorf("unexpected value %#v", v)
+	}
+
+	for _, prop := range []interface{ DeclareChecked() (rbxfile.Value, error) }{
+		Property("Value", Vector3int16, 1, 2, 40000),
+		Property("Value", Vector3int16, -32769, 0, 0),
+		Property("Value", Vector2int16, 0, 1e6),
+	} {
+		if v, err := prop.DeclareChecked(); err == nil {
+			t.Errorf("expected overflow error, got %#v", v)
+		}
+	}
+
+	// Without checking, the component wraps around.
+	if v := Property("Value", Vector3int16, 1, 2, 40000).Declare(); v != (rbxfile.ValueVector3int16{X: 1, Y: 2, Z: -25536}) {
+		t.Errorf("unexpected value %#v", v)
+	}
+}
+
+func TestRoot_DeclareChecked(t *testing.T) {
+	root := Root{
+		Instance("Model",
+			Instance("Part",
+				Property("Size", Vector3int16, 4, 1, 2),
+			),
+			Instance("Part",
+				Property("Size", Vector3int16, 4, 70000, 2),
+			),
+		),
+	}
+	if _, err := root.DeclareChecked(nil); err == nil || err.Error() != "property Part.Size: Vector3int16 component Y value 70000 overflows int16" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	root[0] = Instance("Model",
+		Instance("Part",
+			Property("Size", Vector3int16, 4, 1, 2),
+		),
+	)
+	r, err := root.DeclareChecked(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(r.Instances) != 1 || len(r.Instances[0].Children) != 1 {
+		t.Errorf("unexpected tree")
+	}
+}
@@ -1,7 +1,9 @@
 package declare
 
 import (
+	"fmt"
 	"github.com/robloxapi/rbxfile"
+	"math"
 	"strings"
 )
 
@@ -48,6 +50,10 @@ const (
 	Rect2D
 	PhysicalProperties
 	Color3uint8
+	Font
+	UniqueId
+	SharedString
+	Int64
 )
 
 // TypeFromString returns a Type from its string representation. Type(0) is
@@ -91,6 +97,10 @@ var typeStrings = map[Type]string{
 	Rect2D:             "Rect2D",
 	PhysicalProperties: "PhysicalProperties",
 	Color3uint8:        "Color3uint8",
+	Font:               "Font",
+	UniqueId:           "UniqueId",
+	SharedString:       "SharedString",
+	Int64:              "Int64",
 }
 
 func normUint8(v interface{}) uint8 {
@@ -124,6 +134,37 @@ func normUint8(v interface{}) uint8 {
 	return 0
 }
 
+func normUint16(v interface{}) uint16 {
+	switch v := v.(type) {
+	case int:
+		return uint16(v)
+	case uint:
+		return uint16(v)
+	case uint8:
+		return uint16(v)
+	case uint16:
+		return uint16(v)
+	case uint32:
+		return uint16(v)
+	case uint64:
+		return uint16(v)
+	case int8:
+		return uint16(v)
+	case int16:
+		return uint16(v)
+	case int32:
+		return uint16(v)
+	case int64:
+		return uint16(v)
+	case float32:
+		return uint16(v)
+	case float64:
+		return uint16(v)
+	}
+
+	return 0
+}
+
 func normInt16(v interface{}) int16 {
 	switch v := v.(type) {
 	case int:
@@ -155,6 +196,62 @@ func normInt16(v interface{}) int16 {
 	return 0
 }
 
+// inInt16 returns whether v is a number within the range of an int16. Values
+// that are not numbers are considered to be in range, since they are converted
+// to zero.
+func inInt16(v interface{}) bool {
+	var f float64
+	switch v := v.(type) {
+	case int:
+		f = float64(v)
+	case uint:
+		f = float64(v)
+	case uint16:
+		f = float64(v)
+	case uint32:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	case int32:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case float32:
+		f = float64(v)
+	case float64:
+		f = v
+	default:
+		// uint8, int8, and int16 always fit.
+		return true
+	}
+	return f >= math.MinInt16 && f <= math.MaxInt16
+}
+
+// check returns an error if v contains a number that does not fit within the
+// corresponding field of the type, which would otherwise wrap around when
+// converted. Only the components of Vector2int16 and Vector3int16 are
+// checked.
+func (t Type) check(v []interface{}) error {
+	var n int
+	switch t {
+	case Vector2int16:
+		n = 2
+	case Vector3int16:
+		n = 3
+	default:
+		return nil
+	}
+	if len(v) != n {
+		return nil
+	}
+	for i, c := range v {
+		if !inInt16(c) {
+			return fmt.Errorf("%s component %c value %v overflows int16", t, "XYZ"[i], c)
+		}
+	}
+	return nil
+}
+
 func normInt32(v interface{}) int32 {
 	switch v := v.(type) {
 	case int:
@@ -186,6 +283,37 @@ func normInt32(v interface{}) int32 {
 	return 0
 }
 
+func normInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return int64(v)
+	case float32:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+
+	return 0
+}
+
 func normUint32(v interface{}) uint32 {
 	switch v := v.(type) {
 	case int:
@@ -342,6 +470,14 @@ func assertValue(t Type, v interface{}) (value rbxfile.Value, ok bool) {
 		value, ok = v.(rbxfile.ValuePhysicalProperties)
 	case Color3uint8:
 		value, ok = v.(rbxfile.ValueColor3uint8)
+	case Font:
+		value, ok = v.(rbxfile.ValueFont)
+	case UniqueId:
+		value, ok = v.(rbxfile.ValueUniqueId)
+	case SharedString:
+		value, ok = v.(rbxfile.ValueSharedString)
+	case Int64:
+		value, ok = v.(rbxfile.ValueInt64)
 	}
 	return
 }
@@ -391,6 +527,8 @@ func (t Type) value(refs rbxfile.References, v []interface{}) rbxfile.Value {
 		}
 	case Int:
 		return rbxfile.ValueInt(normInt32(v[0]))
+	case Int64:
+		return rbxfile.ValueInt64(normInt64(v[0]))
 	case Float:
 		return rbxfile.ValueFloat(normFloat32(v[0]))
 	case Double:
@@ -671,6 +809,43 @@ func (t Type) value(refs rbxfile.References, v []interface{}) rbxfile.Value {
 				B: normUint8(v[2]),
 			}
 		}
+	case Font:
+		if len(v) == 3 || len(v) == 4 {
+			font := rbxfile.ValueFont{
+				Weight: normUint16(v[1]),
+				Style:  rbxfile.FontStyle(normUint8(v[2])),
+			}
+			switch f := v[0].(type) {
+			case string:
+				font.Family = rbxfile.ValueContent(f)
+			case []byte:
+				font.Family = rbxfile.ValueContent(f)
+			}
+			if len(v) == 4 {
+				switch c := v[3].(type) {
+				case string:
+					font.CachedFaceId = rbxfile.ValueContent(c)
+				case []byte:
+					font.CachedFaceId = rbxfile.ValueContent(c)
+				}
+			}
+			return font
+		}
+	case UniqueId:
+		if len(v) == 3 {
+			return rbxfile.ValueUniqueId{
+				Random: normInt64(v[0]),
+				Time:   normUint32(v[1]),
+				Index:  normUint32(v[2]),
+			}
+		}
+	case SharedString:
+		switch v := v[0].(type) {
+		case string:
+			return rbxfile.ValueSharedString(v)
+		case []byte:
+			return rbxfile.ValueSharedString(v)
+		}
 	}
 
 zero:
@@ -22,8 +22,13 @@
 package rbxfile
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/robloxapi/rbxapi"
+	"sort"
+	"strconv"
 )
 
 ////////////////////////////////////////////////////////////////
@@ -33,6 +38,15 @@ import (
 type Root struct {
 	// Instances contains root instances contained in the tree.
 	Instances []*Instance
+
+	// EndComments contains comments that appear after the last instance in
+	// the tree, for formats that support comments.
+	EndComments []string
+
+	// ProcessingInstructions contains processing instructions that appear
+	// before the tree, for formats that support them. Each is the text
+	// between "<?" and "?>".
+	ProcessingInstructions []string
 }
 
 // Copy creates a copy of the root and its contents.
@@ -43,7 +57,9 @@ type Root struct {
 // instance.
 func (root *Root) Copy() *Root {
 	clone := &Root{
-		Instances: make([]*Instance, len(root.Instances)),
+		Instances:              make([]*Instance, len(root.Instances)),
+		EndComments:            append([]string(nil), root.EndComments...),
+		ProcessingInstructions: append([]string(nil), root.ProcessingInstructions...),
 	}
 
 	refs := make(References)
@@ -62,6 +78,633 @@ func (root *Root) Copy() *Root {
 	return clone
 }
 
+// Clone returns a copy of the root and its contents, as with Copy. It is
+// provided for symmetry with Instance.Clone.
+//
+// Because every top-level instance is copied together, a reference between
+// two top-level instances, or their descendants, is resolved so that it
+// points to the copy of the original referent.
+func (root *Root) Clone() *Root {
+	return root.Copy()
+}
+
+// Append moves the top-level instances of other into root, leaving other
+// empty.
+//
+// The reference of a moved instance is regenerated if it is empty, or if it
+// collides with a reference already within root or with that of another moved
+// instance. Because a ValueReference points directly to its
+// referent, references between instances within other remain intact after
+// being moved, as do references that point to instances outside of either
+// tree.
+func (root *Root) Append(other *Root) {
+	if other == nil || other == root {
+		return
+	}
+
+	refs := make(References)
+	var mark func(instances []*Instance)
+	mark = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if !IsEmptyReference(inst.Reference) && refs[inst.Reference] == nil {
+				refs[inst.Reference] = inst
+			}
+			mark(inst.Children)
+		}
+	}
+	mark(root.Instances)
+
+	var remap func(instances []*Instance)
+	remap = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			refs.Get(inst)
+			remap(inst.Children)
+		}
+	}
+	remap(other.Instances)
+
+	root.Instances = append(root.Instances, other.Instances...)
+	other.Instances = nil
+}
+
+// Split returns a root for each top-level instance of root, containing a copy
+// of the instance and its descendants.
+//
+// A copied reference within the subtree of an instance is resolved so that it
+// points to the corresponding copy of the original referent. A reference to an
+// instance outside of the subtree, including one within another top-level
+// instance, is set to nil, and is returned in broken. The Instance of each
+// broken property is the copy, while the Referent is the original instance
+// that was referred to.
+//
+// The original instances are not modified. Each copy has the reference of its
+// original, unless the reference is empty or duplicated within the subtree, in
+// which case a new reference is generated for the copy.
+func (root *Root) Split() (roots []*Root, broken []ReferenceProperty) {
+	for _, inst := range root.Instances {
+		if inst == nil {
+			continue
+		}
+
+		copies := map[*Instance]*Instance{}
+		clone := splitCopy(inst, make(References), copies)
+		for _, prop := range CollectReferences(&Root{Instances: []*Instance{inst}}) {
+			if prop.Referent == nil {
+				continue
+			}
+			c := copies[prop.Instance]
+			if prop.Internal {
+				c.Properties[prop.Property] = ValueReference{Instance: copies[prop.Referent]}
+				continue
+			}
+			// Referents outside of the subtree resolve to nil.
+			c.Properties[prop.Property] = ValueReference{}
+			prop.Instance = c
+			broken = append(broken, prop)
+		}
+
+		roots = append(roots, &Root{Instances: []*Instance{clone}})
+	}
+	return roots, broken
+}
+
+// splitCopy copies inst and its descendants without modifying them, mapping
+// each original to its copy in copies. References of the copies are made
+// unique with refs. Reference properties are copied as-is, and must be
+// resolved by the caller.
+func splitCopy(inst *Instance, refs References, copies map[*Instance]*Instance) *Instance {
+	clone := &Instance{
+		ClassName:  inst.ClassName,
+		Reference:  inst.Reference,
+		IsService:  inst.IsService,
+		Children:   make([]*Instance, len(inst.Children)),
+		Properties: make(map[string]Value, len(inst.Properties)),
+
+		Comments:    append([]string(nil), inst.Comments...),
+		EndComments: append([]string(nil), inst.EndComments...),
+	}
+	refs.Get(clone)
+	copies[inst] = clone
+	for name, value := range inst.Properties {
+		clone.Properties[name] = value.Copy()
+	}
+	for i, child := range inst.Children {
+		c := splitCopy(child, refs, copies)
+		clone.Children[i] = c
+		c.parent = clone
+	}
+	return clone
+}
+
+// Summary contains statistics about the instances in a tree.
+type Summary struct {
+	// ClassCounts maps each class name to the number of instances of that
+	// class.
+	ClassCounts map[string]int
+
+	// Instances is the total number of instances.
+	Instances int
+
+	// MaxDepth is the greatest depth of any instance, where instances
+	// directly under the root have a depth of 1. MaxDepth is 0 if the tree
+	// contains no instances.
+	MaxDepth int
+}
+
+// Summarize returns a summary of the instances in the tree under root.
+func Summarize(root *Root) Summary {
+	summary := Summary{ClassCounts: make(map[string]int)}
+	if root == nil {
+		return summary
+	}
+	var walk func(instances []*Instance, depth int)
+	walk = func(instances []*Instance, depth int) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			summary.ClassCounts[inst.ClassName]++
+			summary.Instances++
+			if depth > summary.MaxDepth {
+				summary.MaxDepth = depth
+			}
+			walk(inst.Children, depth+1)
+		}
+	}
+	walk(root.Instances, 1)
+	return summary
+}
+
+// ClassNames returns the class names of the instances in the tree under root,
+// sorted, with each name appearing once.
+func ClassNames(root *Root) []string {
+	counts := Summarize(root).ClassCounts
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContentHash returns a SHA-256 hash of the content of the tree under root,
+// which may be used to detect changes. The hash covers the structure of the
+// tree, and the class name, IsService flag, and properties of each instance.
+// Values are hashed by their type and every field of their content.
+//
+// The Reference strings of instances are not hashed. Instead, a reference to
+// an instance within the tree is hashed by the position of the referent
+// within the tree, so a tree hashes the same as its copy. An unresolved
+// reference is hashed in the same way, by finding the instance that has the
+// referent string. A reference to an instance outside of the tree is hashed
+// as a reference to nothing.
+func ContentHash(root *Root) [32]byte {
+	h := sha256.New()
+	write := func(s string) {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(s)))
+		h.Write(n[:])
+		h.Write([]byte(s))
+	}
+
+	instances, ids := Flatten(root)
+	refIDs := referenceIDs(instances)
+
+	for _, flat := range instances {
+		inst := flat.Instance
+		write(strconv.Itoa(flat.ParentID))
+		write(inst.ClassName)
+		write(strconv.FormatBool(inst.IsService))
+		names := make([]string, 0, len(inst.Properties))
+		for name := range inst.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		write(strconv.Itoa(len(names)))
+		for _, name := range names {
+			write(name)
+			switch value := inst.Properties[name].(type) {
+			case nil:
+				write("")
+			case ValueReference:
+				id, ok := ids[value.Instance]
+				if value.Instance == nil {
+					id, ok = refIDs[value.Reference]
+				}
+				write(value.Type().String())
+				if ok {
+					write(strconv.Itoa(id))
+				} else {
+					write("")
+				}
+			case ValueString, ValueBinaryString, ValueProtectedString, ValueContent, ValueSharedString:
+				// The string representation is the content itself.
+				write(value.Type().String())
+				write(value.String())
+			default:
+				// String is a display format that may omit fields, whereas
+				// the Go syntax representation includes every field.
+				write(value.Type().String())
+				write(fmt.Sprintf("%#v", value))
+			}
+		}
+	}
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// referenceIDs maps the Reference of each instance in instances to the ID of
+// the instance. If more than one instance has the same Reference, the first
+// is used.
+func referenceIDs(instances []FlatInstance) map[string]int {
+	refIDs := make(map[string]int, len(instances))
+	for _, flat := range instances {
+		if ref := flat.Instance.Reference; !IsEmptyReference(ref) {
+			if _, ok := refIDs[ref]; !ok {
+				refIDs[ref] = flat.ID
+			}
+		}
+	}
+	return refIDs
+}
+
+// isScriptClass returns whether a class name is that of a script.
+func isScriptClass(className string) bool {
+	switch className {
+	case "Script", "LocalScript", "ModuleScript":
+		return true
+	}
+	return false
+}
+
+// StripScripts walks through the tree of root, and clears the source of every
+// Script, LocalScript, and ModuleScript, by setting each of their
+// ProtectedString properties to an empty value. Other properties are left
+// untouched.
+//
+// If remove is true, then script instances are instead removed from the tree
+// entirely, along with their descendants.
+func StripScripts(root *Root, remove bool) {
+	if root == nil {
+		return
+	}
+	var walk func(instances []*Instance) []*Instance
+	walk = func(instances []*Instance) []*Instance {
+		kept := instances[:0]
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if isScriptClass(inst.ClassName) {
+				if remove {
+					inst.parent = nil
+					continue
+				}
+				for name, value := range inst.Properties {
+					if _, ok := value.(ValueProtectedString); ok {
+						inst.Properties[name] = ValueProtectedString("")
+					}
+				}
+			}
+			inst.Children = walk(inst.Children)
+			kept = append(kept, inst)
+		}
+		for i := len(kept); i < len(instances); i++ {
+			instances[i] = nil
+		}
+		return kept
+	}
+	root.Instances = walk(root.Instances)
+}
+
+// ExtractScripts walks through the tree of root, and returns the source of
+// every Script, LocalScript, and ModuleScript, mapped by the full name of the
+// instance, as returned by GetFullName. The source is the value of the Source
+// property, which must be a ProtectedString; scripts without such a property
+// are skipped.
+//
+// Instances are visited depth-first. If multiple scripts have the same full
+// name, only the first is included.
+func ExtractScripts(root *Root) map[string]string {
+	scripts := map[string]string{}
+	if root == nil {
+		return scripts
+	}
+	var walk func(instances []*Instance)
+	walk = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if isScriptClass(inst.ClassName) {
+				if source, ok := inst.Properties["Source"].(ValueProtectedString); ok {
+					name := inst.GetFullName()
+					if _, ok := scripts[name]; !ok {
+						scripts[name] = string(source)
+					}
+				}
+			}
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+	return scripts
+}
+
+// InjectScripts is the inverse of ExtractScripts. It walks through the tree of
+// root, and sets the Source property of each Script, LocalScript, and
+// ModuleScript whose full name is a key of sources to the corresponding
+// value, as a ProtectedString.
+//
+// Returns the paths that were applied, and the paths that were not, each
+// sorted. A path that does not match any script is missing. A path that is
+// ambiguous, matching more than one script, is not applied to any of them,
+// and is also included in missing.
+func InjectScripts(root *Root, sources map[string]string) (applied, missing []string) {
+	matches := map[string][]*Instance{}
+	if root != nil {
+		var walk func(instances []*Instance)
+		walk = func(instances []*Instance) {
+			for _, inst := range instances {
+				if inst == nil {
+					continue
+				}
+				if isScriptClass(inst.ClassName) {
+					name := inst.GetFullName()
+					if _, ok := sources[name]; ok {
+						matches[name] = append(matches[name], inst)
+					}
+				}
+				walk(inst.Children)
+			}
+		}
+		walk(root.Instances)
+	}
+
+	for name, source := range sources {
+		if insts := matches[name]; len(insts) == 1 {
+			insts[0].Set("Source", ValueProtectedString(source))
+			applied = append(applied, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(applied)
+	sort.Strings(missing)
+	return applied, missing
+}
+
+// MigrateProperties walks through the tree of root, and renames the
+// properties of each instance according to rules. Each key of rules is a
+// class name, mapped to a set of rules for instances of that class. Each rule
+// maps an old property name to a new property name. The class name "*"
+// applies to instances of every class. If both a class and "*" have a rule
+// for the same property, then the rule for the class is used.
+//
+// Properties are renamed all at once, so a renamed property is not renamed
+// again by another rule. A renamed property replaces any existing property of
+// the same name.
+func MigrateProperties(root *Root, rules map[string]map[string]string) {
+	if root == nil || len(rules) == 0 {
+		return
+	}
+	wildcard := rules["*"]
+	var walk func(instances []*Instance)
+	walk = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			migrateProperties(inst, rules[inst.ClassName], wildcard)
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+}
+
+// migrateProperties renames the properties of inst according to class and
+// wildcard rules.
+func migrateProperties(inst *Instance, class, wildcard map[string]string) {
+	if len(class) == 0 && len(wildcard) == 0 {
+		return
+	}
+	renamed := map[string]Value{}
+	for name, value := range inst.Properties {
+		to, ok := class[name]
+		if !ok {
+			if to, ok = wildcard[name]; !ok {
+				continue
+			}
+		}
+		if to == name {
+			continue
+		}
+		renamed[to] = value
+		delete(inst.Properties, name)
+	}
+	for name, value := range renamed {
+		inst.Properties[name] = value
+	}
+}
+
+// DeduplicateStrings walks through the tree of root, and finds String and
+// BinaryString property values of at least minLen bytes that are identical to
+// at least one other such value. Only properties whose type is declared as
+// SharedString by api, in the class of the instance or a superclass, are
+// considered. Each of these values is replaced with a ValueSharedString, such
+// that identical values share the same underlying data. Returns the number of
+// values that were duplicates of another. Returns 0 if api is nil.
+func DeduplicateStrings(root *Root, api *rbxapi.API, minLen int) int {
+	if root == nil || api == nil {
+		return 0
+	}
+
+	// Cache the SharedString properties of each class, including inherited
+	// properties.
+	classShared := map[string]map[string]bool{}
+	isShared := func(className, name string) bool {
+		shared, ok := classShared[className]
+		if !ok {
+			shared = map[string]bool{}
+			class, ok := api.Classes[className]
+			for ok {
+				for _, member := range class.MemberList() {
+					if prop, ok := member.(*rbxapi.Property); ok && prop.ValueType == "SharedString" {
+						shared[prop.MemberName] = true
+					}
+				}
+				class, ok = api.Classes[class.Superclass]
+			}
+			classShared[className] = shared
+		}
+		return shared[name]
+	}
+
+	type occurrence struct {
+		inst *Instance
+		name string
+	}
+	groups := map[string][]occurrence{}
+	var walk func(instances []*Instance)
+	walk = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			for name, value := range inst.Properties {
+				var b []byte
+				switch v := value.(type) {
+				case ValueString:
+					b = v
+				case ValueBinaryString:
+					b = v
+				default:
+					continue
+				}
+				if len(b) < minLen || !isShared(inst.ClassName, name) {
+					continue
+				}
+				groups[string(b)] = append(groups[string(b)], occurrence{inst, name})
+			}
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+
+	n := 0
+	for s, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		shared := ValueSharedString(s)
+		for _, o := range group {
+			o.inst.Properties[o.name] = shared
+		}
+		n += len(group) - 1
+	}
+	return n
+}
+
+// Classes of errors that occur while decoding. The errors returned by the
+// decoders of each format belong to one of these classes, which can be
+// checked with errors.Is. The specific error can be extracted with errors.As.
+var (
+	// ErrFormat indicates that the data is not in the expected format, such
+	// as when the signature or header of a file is invalid.
+	ErrFormat = errors.New("invalid format")
+
+	// ErrVersion indicates that the version of the format is not supported.
+	ErrVersion = errors.New("unsupported version")
+
+	// ErrTruncated indicates that the data ended unexpectedly.
+	ErrTruncated = errors.New("unexpected end of data")
+
+	// ErrCorrupt indicates that the data is malformed, such as when a chunk
+	// or tag is invalid.
+	ErrCorrupt = errors.New("corrupt data")
+
+	// ErrLimit indicates that decoding exceeded a configured limit.
+	ErrLimit = errors.New("limit exceeded")
+)
+
+// ClassError is an error that belongs to a class of decoding errors, such as
+// ErrFormat. errors.Is reports that a ClassError matches its class.
+type ClassError struct {
+	// Class is the class of the error.
+	Class error
+	// Err is the underlying error.
+	Err error
+}
+
+func (err *ClassError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ClassError) Unwrap() error {
+	return err.Err
+}
+
+func (err *ClassError) Is(target error) bool {
+	return target == err.Class
+}
+
+// DecodeError is returned by a serializer when a stage of decoding fails. It
+// retains the underlying error, which can be inspected with errors.Is and
+// errors.As.
+type DecodeError struct {
+	// Op describes the stage that failed, such as "parsing format".
+	Op string
+	// Err is the underlying error.
+	Err error
+}
+
+func (err *DecodeError) Error() string {
+	return "error " + err.Op + ": " + err.Err.Error()
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
+// ErrMaxInstances is returned by a decoder when decoding would produce more
+// instances than a configured maximum. It belongs to the ErrLimit class.
+type ErrMaxInstances struct {
+	// Max is the maximum number of instances that was exceeded.
+	Max int
+}
+
+func (err ErrMaxInstances) Error() string {
+	return fmt.Sprintf("number of instances exceeds maximum of %d", err.Max)
+}
+
+func (err ErrMaxInstances) Is(target error) bool {
+	return target == ErrLimit
+}
+
+// FlatInstance is an instance within a flattened tree.
+type FlatInstance struct {
+	// ID is the position of the instance in the flattened tree.
+	ID int
+	// ParentID is the ID of the instance's parent, or -1 if the instance is
+	// a top-level instance.
+	ParentID int
+	// Instance is the instance itself.
+	Instance *Instance
+}
+
+// Flatten walks through the tree of root in pre-order, returning a list of
+// each instance, along with a map of each instance to its ID. IDs are
+// assigned in the order in which instances are visited, starting at 0, so
+// that the ID of each instance is also its index in the list.
+func Flatten(root *Root) (instances []FlatInstance, ids map[*Instance]int) {
+	ids = make(map[*Instance]int)
+	if root == nil {
+		return nil, ids
+	}
+	var walk func(children []*Instance, parent int)
+	walk = func(children []*Instance, parent int) {
+		for _, inst := range children {
+			if inst == nil {
+				continue
+			}
+			id := len(instances)
+			ids[inst] = id
+			instances = append(instances, FlatInstance{ID: id, ParentID: parent, Instance: inst})
+			walk(inst.Children, id)
+		}
+	}
+	walk(root.Instances, -1)
+	return instances, ids
+}
+
 // Instance represents a single Roblox instance.
 type Instance struct {
 	// ClassName indicates the instance's type.
@@ -83,6 +726,12 @@ type Instance struct {
 	// instance.
 	Children []*Instance
 
+	// Comments contains comments that appear directly before the instance,
+	// and EndComments contains comments that appear after its last child,
+	// for formats that support comments.
+	Comments    []string
+	EndComments []string
+
 	// The parent of the instance. Can be nil.
 	parent *Instance
 }
@@ -234,10 +883,13 @@ func (inst *Instance) clone(refs, crefs References, propRefs *[]PropRef) *Instan
 		IsService:  inst.IsService,
 		Children:   make([]*Instance, len(inst.Children)),
 		Properties: make(map[string]Value, len(inst.Properties)),
+
+		Comments:    append([]string(nil), inst.Comments...),
+		EndComments: append([]string(nil), inst.EndComments...),
 	}
 	crefs[clone.Reference] = clone
 	for name, value := range inst.Properties {
-		if value, ok := value.(ValueReference); ok {
+		if value, ok := value.(ValueReference); ok && value.Instance != nil {
 			*propRefs = append(*propRefs, PropRef{
 				Instance:  clone,
 				Property:  name,
@@ -395,3 +1047,139 @@ func (inst *Instance) Set(property string, value Value) {
 		inst.Properties[property] = value
 	}
 }
+
+// SetProperty sets the value of a property in the instance, converting v to a
+// Value according to its type:
+//
+//	Value:     used as-is
+//	nil:       the property is deleted
+//	bool:      ValueBool
+//	string:    ValueString
+//	[]byte:    ValueBinaryString
+//	int:       ValueInt, if within the range of an int32
+//	int32:     ValueInt
+//	int64:     ValueInt64
+//	float32:   ValueFloat
+//	float64:   ValueDouble
+//	*Instance: ValueReference
+//
+// Other types, or values that cannot be converted, return an error, and the
+// property is left unchanged. For types that could map to more than one
+// Value, such as a float64 for a float property, a Value should be given
+// explicitly.
+func (inst *Instance) SetProperty(name string, v interface{}) error {
+	var value Value
+	switch v := v.(type) {
+	case Value:
+		value = v
+	case nil:
+		delete(inst.Properties, name)
+		return nil
+	case bool:
+		value = ValueBool(v)
+	case string:
+		value = ValueString(v)
+	case []byte:
+		value = ValueBinaryString(v)
+	case int:
+		if int(int32(v)) != v {
+			return fmt.Errorf("value %d of property %s overflows int32", v, name)
+		}
+		value = ValueInt(v)
+	case int32:
+		value = ValueInt(v)
+	case int64:
+		value = ValueInt64(v)
+	case float32:
+		value = ValueFloat(v)
+	case float64:
+		value = ValueDouble(v)
+	case *Instance:
+		value = ValueReference{Instance: v}
+	default:
+		return fmt.Errorf("cannot convert %T to value of property %s", v, name)
+	}
+	inst.Properties[name] = value
+	return nil
+}
+
+// GetString returns the value of a string property, or def if the property is
+// not defined or is not a ValueString.
+func (inst *Instance) GetString(name, def string) string {
+	if v, ok := inst.Properties[name].(ValueString); ok {
+		return string(v)
+	}
+	return def
+}
+
+// GetBool returns the value of a bool property, or def if the property is not
+// defined or is not a ValueBool.
+func (inst *Instance) GetBool(name string, def bool) bool {
+	if v, ok := inst.Properties[name].(ValueBool); ok {
+		return bool(v)
+	}
+	return def
+}
+
+// GetInt returns the value of an int property, or def if the property is not
+// defined or is not a ValueInt.
+func (inst *Instance) GetInt(name string, def int32) int32 {
+	if v, ok := inst.Properties[name].(ValueInt); ok {
+		return int32(v)
+	}
+	return def
+}
+
+// GetFloat returns the value of a float property, or def if the property is
+// not defined or is not a ValueFloat.
+func (inst *Instance) GetFloat(name string, def float32) float32 {
+	if v, ok := inst.Properties[name].(ValueFloat); ok {
+		return float32(v)
+	}
+	return def
+}
+
+// GetDouble returns the value of a double property, or def if the property is
+// not defined or is not a ValueDouble.
+func (inst *Instance) GetDouble(name string, def float64) float64 {
+	if v, ok := inst.Properties[name].(ValueDouble); ok {
+		return float64(v)
+	}
+	return def
+}
+
+// GetVector3 returns the value of a Vector3 property, or def if the property
+// is not defined or is not a ValueVector3.
+func (inst *Instance) GetVector3(name string, def ValueVector3) ValueVector3 {
+	if v, ok := inst.Properties[name].(ValueVector3); ok {
+		return v
+	}
+	return def
+}
+
+// GetCFrame returns the value of a CFrame property, or def if the property is
+// not defined or is not a ValueCFrame.
+func (inst *Instance) GetCFrame(name string, def ValueCFrame) ValueCFrame {
+	if v, ok := inst.Properties[name].(ValueCFrame); ok {
+		return v
+	}
+	return def
+}
+
+// GetColor3 returns the value of a Color3 property, or def if the property is
+// not defined or is not a ValueColor3.
+func (inst *Instance) GetColor3(name string, def ValueColor3) ValueColor3 {
+	if v, ok := inst.Properties[name].(ValueColor3); ok {
+		return v
+	}
+	return def
+}
+
+// GetReference returns the instance referred to by a reference property, or
+// def if the property is not defined or is not a ValueReference.
+func (inst *Instance) GetReference(name string, def *Instance) *Instance {
+	if v, ok := inst.Properties[name].(ValueReference); ok {
+		return v.Instance
+	}
+	return def
+}
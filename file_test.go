@@ -2,6 +2,8 @@ package rbxfile
 
 import (
 	"bytes"
+	"github.com/robloxapi/rbxapi"
+	"reflect"
 	"regexp"
 	"strconv"
 	"testing"
@@ -88,6 +90,754 @@ func TestRootCopy(t *testing.T) {
 	}
 }
 
+func TestRoot_Clone(t *testing.T) {
+	a := NewInstance("ObjectValue", nil)
+	b := NewInstance("ObjectValue", nil)
+	child := NewInstance("Part", b)
+	a.Set("Value", ValueReference{Instance: b})
+	b.Set("Value", ValueReference{Instance: a})
+	child.Set("Target", ValueReference{Instance: a})
+	root := &Root{Instances: []*Instance{a, b}}
+
+	clone := root.Clone()
+	if len(clone.Instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(clone.Instances))
+	}
+	ca, cb := clone.Instances[0], clone.Instances[1]
+	if ca == a || cb == b {
+		t.Fatalf("expected instances to be copied")
+	}
+	if v := ca.GetReference("Value", nil); v != cb {
+		t.Errorf("expected reference to remap to clone of b, got %v", v)
+	}
+	if v := cb.GetReference("Value", nil); v != ca {
+		t.Errorf("expected reference to remap to clone of a, got %v", v)
+	}
+	if v := cb.Children[0].GetReference("Target", nil); v != ca {
+		t.Errorf("expected descendant reference to remap to clone of a, got %v", v)
+	}
+	if v := a.GetReference("Value", nil); v != b {
+		t.Errorf("expected original reference to be unchanged, got %v", v)
+	}
+}
+
+func TestRoot_Append(t *testing.T) {
+	external := NewInstance("Part", nil)
+	newTree := func() *Root {
+		model := NewInstance("Model", nil)
+		model.Reference = "RBX0"
+		part := NewInstance("Part", model)
+		part.Reference = "RBX1"
+		value := NewInstance("ObjectValue", model)
+		value.Reference = "RBX2"
+		value.Set("Value", ValueReference{Instance: part})
+		model.Set("PrimaryPart", ValueReference{Instance: part})
+		part.Set("External", ValueReference{Instance: external})
+		return &Root{Instances: []*Instance{model}}
+	}
+
+	root := newTree()
+	other := newTree()
+	moved := other.Instances[0]
+	root.Append(other)
+
+	if len(other.Instances) != 0 {
+		t.Errorf("expected other to be empty, got %d instances", len(other.Instances))
+	}
+	if len(root.Instances) != 2 || root.Instances[1] != moved {
+		t.Fatalf("expected moved instance to be appended")
+	}
+
+	refs := map[string]bool{}
+	for _, model := range root.Instances {
+		for _, inst := range append([]*Instance{model}, model.Children...) {
+			if refs[inst.Reference] {
+				t.Errorf("duplicate reference %s", inst.Reference)
+			}
+			refs[inst.Reference] = true
+		}
+	}
+	if root.Instances[0].Reference != "RBX0" {
+		t.Errorf("expected references in root to be unchanged, got %s", root.Instances[0].Reference)
+	}
+
+	for i, model := range root.Instances {
+		part, value := model.Children[0], model.Children[1]
+		if v := value.Get("Value").(ValueReference); v.Instance != part {
+			t.Errorf("tree %d: expected Value to refer to part within tree", i)
+		}
+		if v := model.Get("PrimaryPart").(ValueReference); v.Instance != part {
+			t.Errorf("tree %d: expected PrimaryPart to refer to part within tree", i)
+		}
+		if v := part.Get("External").(ValueReference); v.Instance != external {
+			t.Errorf("tree %d: expected external reference to be unchanged", i)
+		}
+	}
+
+	// Empty and duplicate references within other are regenerated.
+	root = newTree()
+	other = newTree()
+	model := other.Instances[0]
+	part, value := model.Children[0], model.Children[1]
+	model.Reference = "RBX9"
+	part.Reference = ""
+	value.Reference = "RBX9"
+	root.Append(other)
+	if model.Reference != "RBX9" {
+		t.Errorf("expected unique reference to be unchanged, got %s", model.Reference)
+	}
+	if IsEmptyReference(part.Reference) {
+		t.Errorf("expected empty reference to be regenerated")
+	}
+	if value.Reference == "RBX9" || value.Reference == part.Reference {
+		t.Errorf("expected duplicate reference to be regenerated, got %s", value.Reference)
+	}
+}
+
+func TestRoot_Split(t *testing.T) {
+	model := NewInstance("Model", nil)
+	part := NewInstance("Part", model)
+	value := NewInstance("ObjectValue", model)
+	value.Set("Value", ValueReference{Instance: part})
+	model.Set("PrimaryPart", ValueReference{Instance: part})
+
+	folder := NewInstance("Folder", nil)
+	link := NewInstance("ObjectValue", folder)
+	link.Set("Value", ValueReference{Instance: part})
+	link.Set("Empty", ValueReference{})
+
+	// Empty and duplicate references are regenerated only on the copies.
+	part.Reference = ""
+	value.Reference = model.Reference
+	originals := []*Instance{model, part, value, folder, link}
+	references := make([]string, len(originals))
+	for i, inst := range originals {
+		references[i] = inst.Reference
+	}
+
+	root := &Root{Instances: []*Instance{model, nil, folder}}
+	roots, broken := root.Split()
+	if len(roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(roots))
+	}
+	for i, original := range []*Instance{model, folder} {
+		if len(roots[i].Instances) != 1 {
+			t.Fatalf("root %d: expected 1 instance, got %d", i, len(roots[i].Instances))
+		}
+		if inst := roots[i].Instances[0]; inst == original || inst.ClassName != original.ClassName {
+			t.Errorf("root %d: expected copy of %s", i, original.ClassName)
+		}
+	}
+
+	modelCopy := roots[0].Instances[0]
+	partCopy, valueCopy := modelCopy.Children[0], modelCopy.Children[1]
+	if v := valueCopy.Get("Value").(ValueReference); v.Instance != partCopy {
+		t.Errorf("expected Value to refer to copied part")
+	}
+	if v := modelCopy.Get("PrimaryPart").(ValueReference); v.Instance != partCopy {
+		t.Errorf("expected PrimaryPart to refer to copied part")
+	}
+
+	linkCopy := roots[1].Instances[0].Children[0]
+	if v := linkCopy.Get("Value").(ValueReference); v.Instance != nil {
+		t.Errorf("expected cross-subtree reference to be nil")
+	}
+	if v := linkCopy.Get("Empty").(ValueReference); v.Instance != nil {
+		t.Errorf("expected empty reference to remain empty")
+	}
+
+	if len(broken) != 1 {
+		t.Fatalf("expected 1 broken reference, got %d", len(broken))
+	}
+	if b := broken[0]; b.Instance != linkCopy || b.Property != "Value" || b.Referent != part || b.Internal {
+		t.Errorf("unexpected broken reference: %+v", b)
+	}
+
+	// Original is unchanged.
+	if v := link.Get("Value").(ValueReference); v.Instance != part {
+		t.Errorf("expected original reference to be unchanged")
+	}
+	for i, inst := range originals {
+		if inst.Reference != references[i] {
+			t.Errorf("expected reference of original %s to be unchanged, got %q", inst.ClassName, inst.Reference)
+		}
+	}
+	if modelCopy.Reference != model.Reference {
+		t.Errorf("expected copied reference %q, got %q", model.Reference, modelCopy.Reference)
+	}
+	if partCopy.Reference == "" || valueCopy.Reference == model.Reference {
+		t.Errorf("expected copies to have unique references")
+	}
+
+	// References of copies match the originals.
+	part.Reference = "RBX1"
+	roots, _ = (&Root{Instances: []*Instance{model}}).Split()
+	if r := roots[0].Instances[0].Children[0].Reference; r != "RBX1" {
+		t.Errorf("expected copied reference RBX1, got %q", r)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	r := &Root{
+		Instances: []*Instance{
+			NewInstance("Model", nil),
+			NewInstance("Part", nil),
+		},
+	}
+	inner := NewInstance("Model", r.Instances[0])
+	NewInstance("Part", inner)
+	NewInstance("Part", NewInstance("Model", inner))
+
+	s := Summarize(r)
+	if s.Instances != 6 {
+		t.Errorf("expected 6 instances, got %d", s.Instances)
+	}
+	if s.MaxDepth != 4 {
+		t.Errorf("expected max depth of 4, got %d", s.MaxDepth)
+	}
+	expected := map[string]int{"Model": 3, "Part": 3}
+	if !reflect.DeepEqual(s.ClassCounts, expected) {
+		t.Errorf("unexpected class counts (expected %v, got %v)", expected, s.ClassCounts)
+	}
+
+	if s := Summarize(&Root{}); s.Instances != 0 || s.MaxDepth != 0 || len(s.ClassCounts) != 0 {
+		t.Errorf("expected empty summary, got %v", s)
+	}
+}
+
+func TestClassNames(t *testing.T) {
+	r := &Root{
+		Instances: []*Instance{
+			NewInstance("Workspace", nil),
+			NewInstance("Lighting", nil),
+		},
+	}
+	model := NewInstance("Model", r.Instances[0])
+	NewInstance("Part", model)
+	NewInstance("Part", NewInstance("Model", model))
+	NewInstance("Sky", r.Instances[1])
+	NewInstance("Decal", NewInstance("Part", r.Instances[0]))
+
+	expected := []string{"Decal", "Lighting", "Model", "Part", "Sky", "Workspace"}
+	if names := ClassNames(r); !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected class names (expected %v, got %v)", expected, names)
+	}
+	if names := ClassNames(nil); len(names) != 0 {
+		t.Errorf("expected no class names, got %v", names)
+	}
+}
+
+func TestStripScripts(t *testing.T) {
+	newTree := func() (*Root, *Instance, *Instance) {
+		model := NewInstance("Model", nil)
+		part := NewInstance("Part", model)
+		part.Set("Name", ValueString("Part"))
+		script := NewInstance("Script", model)
+		script.Set("Name", ValueString("Script"))
+		script.Set("Disabled", ValueBool(true))
+		script.Set("Source", ValueProtectedString("print('hello')"))
+		NewInstance("StringValue", script)
+		module := NewInstance("ModuleScript", nil)
+		module.Set("Source", ValueProtectedString("return {}"))
+		return &Root{Instances: []*Instance{model, module}}, part, script
+	}
+
+	root, part, script := newTree()
+	StripScripts(root, false)
+	if v, ok := script.Get("Source").(ValueProtectedString); !ok || len(v) != 0 {
+		t.Errorf("expected source to be empty, got %#v", script.Get("Source"))
+	}
+	if v := script.Name(); v != "Script" {
+		t.Errorf("expected name to remain, got %q", v)
+	}
+	if v := script.Get("Disabled"); v != ValueBool(true) {
+		t.Errorf("expected Disabled to remain, got %#v", v)
+	}
+	if len(script.Children) != 1 || len(part.Parent().Children) != 2 {
+		t.Errorf("expected children to remain")
+	}
+	if v, ok := root.Instances[1].Get("Source").(ValueProtectedString); !ok || len(v) != 0 {
+		t.Errorf("expected top-level source to be empty, got %#v", root.Instances[1].Get("Source"))
+	}
+
+	root, part, script = newTree()
+	StripScripts(root, true)
+	if len(root.Instances) != 1 {
+		t.Fatalf("expected 1 top-level instance, got %d", len(root.Instances))
+	}
+	if children := root.Instances[0].Children; len(children) != 1 || children[0] != part {
+		t.Errorf("expected only part to remain, got %v", children)
+	}
+	if script.Parent() != nil {
+		t.Errorf("expected removed script to have no parent")
+	}
+}
+
+func TestExtractScripts(t *testing.T) {
+	model := NewInstance("Model", nil)
+	model.SetName("Model")
+	script := NewInstance("Script", model)
+	script.SetName("Main")
+	script.Set("Source", ValueProtectedString("print('hello')"))
+	module := NewInstance("ModuleScript", script)
+	module.SetName("Util")
+	module.Set("Source", ValueProtectedString("return {}"))
+	value := NewInstance("StringValue", model)
+	value.SetName("Value")
+	value.Set("Value", ValueString("not a script"))
+	NewInstance("LocalScript", model).SetName("Empty")
+	// Same full name as the first script.
+	other := NewInstance("Script", model)
+	other.SetName("Main")
+	other.Set("Source", ValueProtectedString("error('shadowed')"))
+
+	scripts := ExtractScripts(&Root{Instances: []*Instance{model}})
+	expected := map[string]string{
+		"Model.Main":      "print('hello')",
+		"Model.Main.Util": "return {}",
+	}
+	if !reflect.DeepEqual(scripts, expected) {
+		t.Errorf("unexpected scripts %q", scripts)
+	}
+
+	if scripts := ExtractScripts(nil); len(scripts) != 0 {
+		t.Errorf("expected no scripts, got %q", scripts)
+	}
+}
+
+func TestInjectScripts(t *testing.T) {
+	model := NewInstance("Model", nil)
+	model.SetName("Model")
+	script := NewInstance("Script", model)
+	script.SetName("Main")
+	script.Set("Source", ValueProtectedString("print('hello')"))
+	module := NewInstance("ModuleScript", script)
+	module.SetName("Util")
+	// Two scripts with the same full name.
+	for i := 0; i < 2; i++ {
+		dup := NewInstance("LocalScript", model)
+		dup.SetName("Dup")
+		dup.Set("Source", ValueProtectedString("original"))
+	}
+	root := &Root{Instances: []*Instance{model}}
+
+	applied, missing := InjectScripts(root, map[string]string{
+		"Model.Main":      "print('edited')",
+		"Model.Main.Util": "return 1",
+		"Model.Dup":       "edited",
+		"Model.Gone":      "edited",
+	})
+	if !reflect.DeepEqual(applied, []string{"Model.Main", "Model.Main.Util"}) {
+		t.Errorf("unexpected applied paths %q", applied)
+	}
+	if !reflect.DeepEqual(missing, []string{"Model.Dup", "Model.Gone"}) {
+		t.Errorf("unexpected missing paths %q", missing)
+	}
+	source := func(inst *Instance) string {
+		v, _ := inst.Get("Source").(ValueProtectedString)
+		return string(v)
+	}
+	if v := source(script); v != "print('edited')" {
+		t.Errorf("unexpected source %q", v)
+	}
+	if v := source(module); v != "return 1" {
+		t.Errorf("unexpected source %q", v)
+	}
+	for _, dup := range model.Children[2:] {
+		if v := source(dup); v != "original" {
+			t.Errorf("expected ambiguous script to be unchanged, got %q", v)
+		}
+	}
+
+	if scripts := ExtractScripts(root); scripts["Model.Main"] != "print('edited')" {
+		t.Errorf("expected extracted source to be edited, got %q", scripts["Model.Main"])
+	}
+}
+
+func TestMigrateProperties(t *testing.T) {
+	model := NewInstance("Model", nil)
+	model.Set("BrickColor", ValueBrickColor(194))
+	part := NewInstance("Part", model)
+	part.Set("BrickColor", ValueBrickColor(194))
+	part.Set("Size", ValueVector3{X: 4, Y: 1, Z: 2})
+	part.Set("Old", ValueInt(1))
+	decal := NewInstance("Decal", part)
+	decal.Set("Old", ValueInt(2))
+	decal.Set("Texture", ValueContent("rbxassetid://1"))
+
+	MigrateProperties(&Root{Instances: []*Instance{model}}, map[string]map[string]string{
+		"Part": {"BrickColor": "Color", "Old": "Part"},
+		"*":    {"Old": "New"},
+	})
+
+	if v := part.Get("Color"); v != ValueBrickColor(194) {
+		t.Errorf("expected Color to be renamed from BrickColor, got %#v", v)
+	}
+	if v := part.Get("BrickColor"); v != nil {
+		t.Errorf("expected BrickColor to be removed, got %#v", v)
+	}
+	if v := part.Get("Size"); v != (ValueVector3{X: 4, Y: 1, Z: 2}) {
+		t.Errorf("expected Size to remain, got %#v", v)
+	}
+	if v := part.Get("Part"); v != ValueInt(1) {
+		t.Errorf("expected class rule to take precedence, got %#v", v)
+	}
+	if v := part.Get("New"); v != nil {
+		t.Errorf("expected wildcard rule to be overridden, got %#v", v)
+	}
+	if v := model.Get("BrickColor"); v != ValueBrickColor(194) {
+		t.Errorf("expected other class to be untouched, got %#v", v)
+	}
+	if v := decal.Get("New"); v != ValueInt(2) {
+		t.Errorf("expected wildcard rule to apply, got %#v", v)
+	}
+	if len(decal.Properties) != 2 {
+		t.Errorf("expected 2 properties, got %d", len(decal.Properties))
+	}
+}
+
+func TestDeduplicateStrings(t *testing.T) {
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"BasePart": &rbxapi.Class{
+				Name: "BasePart",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "PhysicsData", MemberClass: "BasePart", ValueType: "SharedString"},
+				},
+			},
+			"MeshPart": &rbxapi.Class{Name: "MeshPart", Superclass: "BasePart"},
+			"StringValue": &rbxapi.Class{
+				Name: "StringValue",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Value", MemberClass: "StringValue", ValueType: "string"},
+				},
+			},
+		},
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	model := NewInstance("Model", nil)
+	a := NewInstance("MeshPart", model)
+	a.Set("PhysicsData", ValueBinaryString(data))
+	b := NewInstance("MeshPart", model)
+	b.Set("PhysicsData", ValueString(data))
+	c := NewInstance("MeshPart", b)
+	c.Set("PhysicsData", ValueBinaryString(data[:512]))
+	d := NewInstance("StringValue", model)
+	d.Set("Value", ValueString(data))
+	root := &Root{Instances: []*Instance{model}}
+
+	if n := DeduplicateStrings(root, nil, 1024); n != 0 {
+		t.Errorf("expected no duplicates without API, got %d", n)
+	}
+	if n := DeduplicateStrings(root, api, 1024); n != 1 {
+		t.Errorf("expected 1 duplicate, got %d", n)
+	}
+	va, ok := a.Get("PhysicsData").(ValueSharedString)
+	if !ok || !bytes.Equal(va, data) {
+		t.Fatalf("expected shared string, got %#v", a.Get("PhysicsData"))
+	}
+	vb, ok := b.Get("PhysicsData").(ValueSharedString)
+	if !ok || !bytes.Equal(vb, data) {
+		t.Fatalf("expected shared string, got %#v", b.Get("PhysicsData"))
+	}
+	if &va[0] != &vb[0] {
+		t.Errorf("expected shared strings to share data")
+	}
+	if _, ok := c.Get("PhysicsData").(ValueBinaryString); !ok {
+		t.Errorf("expected short string to be unchanged, got %#v", c.Get("PhysicsData"))
+	}
+	if _, ok := d.Get("Value").(ValueString); !ok {
+		t.Errorf("expected string property to be unchanged, got %#v", d.Get("Value"))
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	a := NewInstance("Model", nil)
+	b := NewInstance("Folder", a)
+	c := NewInstance("Part", b)
+	d := NewInstance("Part", a)
+	e := NewInstance("Model", nil)
+	f := NewInstance("Part", e)
+
+	instances, ids := Flatten(&Root{Instances: []*Instance{a, e}})
+	expected := []FlatInstance{
+		{ID: 0, ParentID: -1, Instance: a},
+		{ID: 1, ParentID: 0, Instance: b},
+		{ID: 2, ParentID: 1, Instance: c},
+		{ID: 3, ParentID: 0, Instance: d},
+		{ID: 4, ParentID: -1, Instance: e},
+		{ID: 5, ParentID: 4, Instance: f},
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Fatalf("unexpected flattened tree:\n\texpected: %v\n\tgot:      %v", expected, instances)
+	}
+	if len(ids) != len(expected) {
+		t.Errorf("expected %d IDs, got %d", len(expected), len(ids))
+	}
+	for _, flat := range expected {
+		if id, ok := ids[flat.Instance]; !ok || id != flat.ID {
+			t.Errorf("expected ID %d for %s, got %d", flat.ID, flat.Instance.ClassName, id)
+		}
+	}
+}
+
+func TestCollectReferences(t *testing.T) {
+	external := NewInstance("Part", nil)
+	model := NewInstance("Model", nil)
+	part := NewInstance("Part", model)
+	value := NewInstance("ObjectValue", model)
+	value.Set("Value", ValueReference{Instance: part})
+	value.Set("Empty", ValueReference{})
+	part.Set("External", ValueReference{Instance: external})
+	part.Set("Name", ValueString("Part"))
+
+	props := CollectReferences(&Root{Instances: []*Instance{model}})
+	expected := []ReferenceProperty{
+		{Instance: part, Property: "External", Referent: external, Internal: false},
+		{Instance: value, Property: "Empty", Referent: nil, Internal: false},
+		{Instance: value, Property: "Value", Referent: part, Internal: true},
+	}
+	if len(props) != len(expected) {
+		t.Fatalf("expected %d references, got %d", len(expected), len(props))
+	}
+	for i, prop := range props {
+		if prop != expected[i] {
+			t.Errorf("reference %d: expected %v, got %v", i, expected[i], prop)
+		}
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	model := NewInstance("Model", nil)
+	model.SetName("Model")
+	part := NewInstance("Part", model)
+	part.Set("Size", ValueVector3{X: 4, Y: 1, Z: 2})
+	value := NewInstance("ObjectValue", model)
+	value.Set("Value", ValueReference{Instance: part})
+	root := &Root{Instances: []*Instance{model}}
+
+	hash := ContentHash(root)
+	clone := root.Clone()
+	// Referents of the clone are regenerated.
+	flat, _ := Flatten(clone)
+	for _, f := range flat {
+		f.Instance.Reference = GenerateReference()
+	}
+	if ContentHash(clone) != hash {
+		t.Errorf("expected clone to hash the same as the original")
+	}
+	if ContentHash(root) != hash {
+		t.Errorf("expected hash to be stable")
+	}
+
+	// Unresolved references are hashed as their referents.
+	lazy := root.Clone()
+	lazyValue := lazy.Instances[0].Children[1]
+	lazyValue.Set("Value", ValueReference{Reference: lazy.Instances[0].Children[0].Reference})
+	if ContentHash(lazy) != hash {
+		t.Errorf("expected unresolved reference to hash the same as resolved reference")
+	}
+
+	clone.Instances[0].Children[0].Set("Size", ValueVector3{X: 4, Y: 1, Z: 3})
+	if ContentHash(clone) == hash {
+		t.Errorf("expected changed property to change hash")
+	}
+	clone = root.Clone()
+	clone.Instances[0].Children[1].Set("Value", ValueReference{Instance: clone.Instances[0]})
+	if ContentHash(clone) == hash {
+		t.Errorf("expected changed reference to change hash")
+	}
+	clone = root.Clone()
+	clone.Instances[0].Children[0].SetParent(clone.Instances[0].Children[1])
+	if ContentHash(clone) == hash {
+		t.Errorf("expected changed structure to change hash")
+	}
+
+	// Fields omitted by String are hashed.
+	for _, values := range [][2]Value{
+		{ValueRaw{Format: "xml", Data: []byte("<a/>")}, ValueRaw{Format: "bin", Data: []byte("<a/>")}},
+		{ValueFont{Family: ValueContent("Arial"), CachedFaceId: ValueContent("a")}, ValueFont{Family: ValueContent("Arial"), CachedFaceId: ValueContent("b")}},
+	} {
+		a, b := root.Clone(), root.Clone()
+		a.Instances[0].Set("Value", values[0])
+		b.Instances[0].Set("Value", values[1])
+		if ContentHash(a) == ContentHash(b) {
+			t.Errorf("expected %#v and %#v to hash differently", values[0], values[1])
+		}
+	}
+}
+
+func TestReferenceGraph(t *testing.T) {
+	model := NewInstance("Model", nil)
+	a := NewInstance("Part", model)
+	b := NewInstance("Part", model)
+	weld := NewInstance("Weld", model)
+	weld.Set("Part0", ValueReference{Instance: a})
+	weld.Set("Part1", ValueReference{Instance: b})
+	a.Set("Empty", ValueReference{})
+
+	graph := ReferenceGraph(&Root{Instances: []*Instance{model}})
+	expected := map[*Instance]map[string]*Instance{
+		weld: {"Part0": a, "Part1": b},
+	}
+	if !reflect.DeepEqual(graph, expected) {
+		t.Errorf("unexpected graph %v", graph)
+	}
+	if graph := ReferenceGraph(nil); len(graph) != 0 {
+		t.Errorf("expected empty graph, got %v", graph)
+	}
+}
+
+func TestCanonicalizeReferent(t *testing.T) {
+	tests := []struct {
+		ref, expected string
+	}{
+		{"RBX0123456789ABCDEF0123456789ABCDEF", "RBX0123456789ABCDEF0123456789ABCDEF"},
+		{"rbx0123456789abcdef0123456789abcdef", "RBX0123456789ABCDEF0123456789ABCDEF"},
+		{"01234567-89ab-cdef-0123-456789abcdef", "RBX0123456789ABCDEF0123456789ABCDEF"},
+		{"RBX01234567-89AB-CDEF-0123-456789ABCDEF", "RBX0123456789ABCDEF0123456789ABCDEF"},
+		{"RBX0", "RBX0"},
+		{"null", "null"},
+		{"", ""},
+		{"RBX0123456789ABCDEF0123456789ABCDEG", "RBX0123456789ABCDEF0123456789ABCDEG"},
+	}
+	for _, test := range tests {
+		if v := CanonicalizeReferent(test.ref); v != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.ref, test.expected, v)
+		}
+	}
+	if ref := GenerateReference(); CanonicalizeReferent(ref) != ref {
+		t.Errorf("expected generated reference %q to be canonical", ref)
+	}
+}
+
+func TestEqualReferents(t *testing.T) {
+	model := NewInstance("Model", nil)
+	model.Reference = "RBX0"
+	part := NewInstance("Part", model)
+	part.Reference = "RBX1"
+	value := NewInstance("ObjectValue", model)
+	value.Reference = "RBX2"
+	value.Set("Value", ValueReference{Instance: part})
+	model.Set("PrimaryPart", ValueReference{Instance: part})
+	part.Set("Self", ValueReference{Instance: part})
+	part.Set("Nothing", ValueReference{})
+	root := &Root{Instances: []*Instance{model}}
+
+	cloned := root.Clone()
+	orig := CollectReferences(root)
+	clone := CollectReferences(cloned)
+	if len(orig) != 4 || len(clone) != len(orig) {
+		t.Fatalf("expected 4 references, got %d and %d", len(orig), len(clone))
+	}
+	equal := EqualReferents(root, cloned)
+	changed := 0
+	for i := range orig {
+		a := ValueReference{Instance: orig[i].Referent}
+		b := ValueReference{Instance: clone[i].Referent}
+		if orig[i].Referent != nil && a == b {
+			t.Errorf("%s: expected referents to differ by pointer", orig[i].Property)
+		}
+		if !equal(a, b) {
+			changed++
+		}
+	}
+	if changed != 0 {
+		t.Errorf("expected no changed references, got %d", changed)
+	}
+
+	clonedPart := cloned.Instances[0].Children[0]
+	if equal(ValueReference{Instance: part}, ValueReference{Instance: cloned.Instances[0]}) {
+		t.Errorf("expected references to different positions to differ")
+	}
+	if equal(ValueReference{Instance: part}, ValueReference{}) {
+		t.Errorf("expected reference to differ from empty reference")
+	}
+	if !equal(ValueReference{Reference: "RBX1"}, ValueReference{Instance: clonedPart}) {
+		t.Errorf("expected unresolved reference to be located by its referent")
+	}
+	outside := NewInstance("Part", nil)
+	if !equal(ValueReference{Instance: outside}, ValueReference{Instance: outside}) {
+		t.Errorf("expected references outside of the trees to equal themselves")
+	}
+	if equal(ValueReference{Instance: outside}, ValueReference{Instance: NewInstance("Part", nil)}) {
+		t.Errorf("expected references to different instances outside of the trees to differ")
+	}
+
+	// Unrelated trees with the same Reference strings do not correspond.
+	other := NewInstance("Model", nil)
+	other.Reference = "RBX0"
+	otherPart := NewInstance("Folder", other)
+	otherPart.Reference = "RBX3"
+	otherValue := NewInstance("Part", other)
+	otherValue.Reference = "RBX1"
+	equal = EqualReferents(root, &Root{Instances: []*Instance{other}})
+	if equal(ValueReference{Instance: part}, ValueReference{Instance: otherValue}) {
+		t.Errorf("expected referents with the same Reference at different positions to differ")
+	}
+	if !equal(ValueReference{Instance: part}, ValueReference{Instance: otherPart}) {
+		t.Errorf("expected referents at the same position to be equal")
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	model := NewInstance("Model", nil)
+	part := NewInstance("Part", model)
+	part.Reference = "RBX1"
+	value := NewInstance("ObjectValue", model)
+	value.Set("Value", ValueReference{Instance: &Instance{Reference: "RBX1"}})
+	missing := &Instance{Reference: "RBX2"}
+	value.Set("Missing", ValueReference{Instance: missing})
+	root := &Root{Instances: []*Instance{model}}
+
+	if err := ResolveReferences(root); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v := value.Get("Value").(ValueReference); v.Instance != part {
+		t.Errorf("expected reference to resolve to part")
+	}
+	if v := value.Get("Missing").(ValueReference); v.Instance != missing {
+		t.Errorf("expected unresolved reference to be unchanged")
+	}
+
+	NewInstance("Part", model).Reference = "RBX1"
+	value.Set("Value", ValueReference{Instance: &Instance{Reference: "RBX1"}})
+	if err := ResolveReferences(root); err == nil {
+		t.Errorf("expected error for duplicate reference")
+	}
+}
+
+func TestAssignReferents(t *testing.T) {
+	model := NewInstance("Model", nil)
+	model.Reference = "RBX1"
+	part := NewInstance("Part", model)
+	part.Reference = "RBX1"
+	other := NewInstance("Part", model)
+	other.Reference = ""
+	value := NewInstance("ObjectValue", model)
+	value.Set("Value", ValueReference{Instance: part})
+	root := &Root{Instances: []*Instance{model}}
+
+	AssignReferents(root)
+	if IsEmptyReference(part.Reference) || part.Reference == model.Reference {
+		t.Errorf("expected unique reference for part, got %q", part.Reference)
+	}
+	if model.Reference != "RBX1" || other.Reference != "" {
+		t.Errorf("expected unreferenced instances to be unchanged")
+	}
+
+	// Round trip through reference strings.
+	ref := part.Reference
+	value.Set("Value", ValueReference{Instance: &Instance{Reference: ref}})
+	if err := ResolveReferences(root); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v := value.Get("Value").(ValueReference); v.Instance != part {
+		t.Errorf("expected reference to resolve to part")
+	}
+}
+
 // Instance Tests
 
 func TestNewInstance(t *testing.T) {
@@ -717,3 +1467,126 @@ func TestInstance_GetSet(t *testing.T) {
 		t.Error("unexpected value returned from Get")
 	}
 }
+
+func TestInstance_SetProperty(t *testing.T) {
+	inst := NewInstance("Instance", nil)
+	other := NewInstance("Part", nil)
+	tests := []struct {
+		v        interface{}
+		expected Value
+	}{
+		{ValueVector3{X: 1, Y: 2, Z: 3}, ValueVector3{X: 1, Y: 2, Z: 3}},
+		{true, ValueBool(true)},
+		{"text", ValueString("text")},
+		{[]byte{0, 1, 2}, ValueBinaryString{0, 1, 2}},
+		{42, ValueInt(42)},
+		{int32(-7), ValueInt(-7)},
+		{int64(1) << 40, ValueInt64(1 << 40)},
+		{float32(0.5), ValueFloat(0.5)},
+		{float64(0.25), ValueDouble(0.25)},
+		{other, ValueReference{Instance: other}},
+	}
+	for _, test := range tests {
+		if err := inst.SetProperty("Property", test.v); err != nil {
+			t.Errorf("%T: unexpected error: %s", test.v, err)
+			continue
+		}
+		if v := inst.Get("Property"); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("%T: expected %#v, got %#v", test.v, test.expected, v)
+		}
+	}
+
+	inst.Set("Property", ValueInt(1))
+	invalid := []interface{}{uint8(1), struct{}{}}
+	if strconv.IntSize == 64 {
+		invalid = append(invalid, int(^uint(0)>>1))
+	}
+	for _, v := range invalid {
+		if err := inst.SetProperty("Property", v); err == nil {
+			t.Errorf("%T: expected error", v)
+		}
+		if v := inst.Get("Property"); v != ValueInt(1) {
+			t.Errorf("expected property to be unchanged, got %#v", v)
+		}
+	}
+
+	if err := inst.SetProperty("Property", nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, ok := inst.Properties["Property"]; ok {
+		t.Errorf("expected property to be deleted")
+	}
+}
+
+func TestInstance_TypedGetters(t *testing.T) {
+	other := NewInstance("Part", nil)
+	inst := NewInstance("Instance", nil)
+	inst.Set("String", ValueString("text"))
+	inst.Set("Bool", ValueBool(true))
+	inst.Set("Int", ValueInt(42))
+	inst.Set("Float", ValueFloat(0.5))
+	inst.Set("Double", ValueDouble(0.25))
+	inst.Set("Vector3", ValueVector3{X: 1, Y: 2, Z: 3})
+	inst.Set("CFrame", ValueCFrame{Position: ValueVector3{X: 1}, Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}})
+	inst.Set("Color3", ValueColor3{R: 1, G: 0.5, B: 0})
+	inst.Set("Reference", ValueReference{Instance: other})
+	inst.Set("Wrong", ValueToken(1))
+
+	for _, name := range []string{"Missing", "Wrong"} {
+		if v := inst.GetString(name, "def"); v != "def" {
+			t.Errorf("%s: expected default string, got %q", name, v)
+		}
+		if v := inst.GetBool(name, true); v != true {
+			t.Errorf("%s: expected default bool, got %t", name, v)
+		}
+		if v := inst.GetInt(name, -1); v != -1 {
+			t.Errorf("%s: expected default int, got %d", name, v)
+		}
+		if v := inst.GetFloat(name, -1); v != -1 {
+			t.Errorf("%s: expected default float, got %v", name, v)
+		}
+		if v := inst.GetDouble(name, -1); v != -1 {
+			t.Errorf("%s: expected default double, got %v", name, v)
+		}
+		if v := inst.GetVector3(name, ValueVector3{X: -1}); v != (ValueVector3{X: -1}) {
+			t.Errorf("%s: expected default Vector3, got %v", name, v)
+		}
+		if v := inst.GetCFrame(name, ValueCFrame{}); v != (ValueCFrame{}) {
+			t.Errorf("%s: expected default CFrame, got %v", name, v)
+		}
+		if v := inst.GetColor3(name, ValueColor3{B: 1}); v != (ValueColor3{B: 1}) {
+			t.Errorf("%s: expected default Color3, got %v", name, v)
+		}
+		if v := inst.GetReference(name, inst); v != inst {
+			t.Errorf("%s: expected default reference, got %v", name, v)
+		}
+	}
+
+	if v := inst.GetString("String", "def"); v != "text" {
+		t.Errorf("unexpected string %q", v)
+	}
+	if v := inst.GetBool("Bool", false); v != true {
+		t.Errorf("unexpected bool %t", v)
+	}
+	if v := inst.GetInt("Int", 0); v != 42 {
+		t.Errorf("unexpected int %d", v)
+	}
+	if v := inst.GetFloat("Float", 0); v != 0.5 {
+		t.Errorf("unexpected float %v", v)
+	}
+	if v := inst.GetDouble("Double", 0); v != 0.25 {
+		t.Errorf("unexpected double %v", v)
+	}
+	if v := inst.GetVector3("Vector3", ValueVector3{}); v != (ValueVector3{X: 1, Y: 2, Z: 3}) {
+		t.Errorf("unexpected Vector3 %v", v)
+	}
+	if v := inst.GetCFrame("CFrame", ValueCFrame{}); v != inst.Get("CFrame") {
+		t.Errorf("unexpected CFrame %v", v)
+	}
+	if v := inst.GetColor3("Color3", ValueColor3{}); v != (ValueColor3{R: 1, G: 0.5, B: 0}) {
+		t.Errorf("unexpected Color3 %v", v)
+	}
+	if v := inst.GetReference("Reference", nil); v != other {
+		t.Errorf("unexpected reference %v", v)
+	}
+}
@@ -5,6 +5,8 @@ package json
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"github.com/robloxapi/rbxfile"
@@ -275,6 +277,11 @@ func ValueToJSONInterface(value rbxfile.Value, refs rbxfile.References) interfac
 		return bool(value)
 	case rbxfile.ValueInt:
 		return float64(value)
+	case rbxfile.ValueInt64:
+		// Unlike other numbers, an int64 is not converted to float64,
+		// which would round values beyond 2^53. json.Marshal writes the
+		// exact integer.
+		return int64(value)
 	case rbxfile.ValueFloat:
 		return float64(value)
 	case rbxfile.ValueDouble:
@@ -397,6 +404,17 @@ func ValueToJSONInterface(value rbxfile.Value, refs rbxfile.References) interfac
 			"g": float64(value.G),
 			"b": float64(value.B),
 		}
+	case rbxfile.ValueFont:
+		return map[string]interface{}{
+			"family":         ValueToJSONInterface(value.Family, refs),
+			"weight":         float64(value.Weight),
+			"style":          float64(value.Style),
+			"cached_face_id": ValueToJSONInterface(value.CachedFaceId, refs),
+		}
+	case rbxfile.ValueUniqueId:
+		return value.String()
+	case rbxfile.ValueSharedString:
+		return base64.StdEncoding.EncodeToString(value)
 	}
 	return nil
 }
@@ -453,6 +471,20 @@ func ValueFromJSONInterface(typ rbxfile.Type, ivalue interface{}) (value rbxfile
 			return nil
 		}
 		return rbxfile.ValueInt(int32(v))
+	case rbxfile.TypeInt64:
+		// A float64 from json.Unmarshal may already be rounded. Decoding
+		// with json.Decoder.UseNumber preserves the exact value.
+		switch v := ivalue.(type) {
+		case float64:
+			return rbxfile.ValueInt64(v)
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil
+			}
+			return rbxfile.ValueInt64(n)
+		}
+		return nil
 	case rbxfile.TypeFloat:
 		v, ok := ivalue.(float64)
 		if !ok {
@@ -679,6 +711,43 @@ func ValueFromJSONInterface(typ rbxfile.Type, ivalue interface{}) (value rbxfile
 			G: byte(v["g"].(float64)),
 			B: byte(v["b"].(float64)),
 		}
+	case rbxfile.TypeFont:
+		v, ok := ivalue.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		family, _ := ValueFromJSONInterface(rbxfile.TypeContent, v["family"]).(rbxfile.ValueContent)
+		cachedFaceId, _ := ValueFromJSONInterface(rbxfile.TypeContent, v["cached_face_id"]).(rbxfile.ValueContent)
+		return rbxfile.ValueFont{
+			Family:       family,
+			Weight:       uint16(v["weight"].(float64)),
+			Style:        rbxfile.FontStyle(v["style"].(float64)),
+			CachedFaceId: cachedFaceId,
+		}
+	case rbxfile.TypeUniqueId:
+		v, ok := ivalue.(string)
+		if !ok {
+			return nil
+		}
+		b, err := hex.DecodeString(v)
+		if err != nil || len(b) != 16 {
+			return nil
+		}
+		return rbxfile.ValueUniqueId{
+			Random: int64(binary.BigEndian.Uint64(b[0:8])),
+			Time:   binary.BigEndian.Uint32(b[8:12]),
+			Index:  binary.BigEndian.Uint32(b[12:16]),
+		}
+	case rbxfile.TypeSharedString:
+		v, ok := ivalue.(string)
+		if !ok {
+			return nil
+		}
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil
+		}
+		return rbxfile.ValueSharedString(b)
 	}
 	return nil
 }
@@ -0,0 +1,308 @@
+package rbxfile
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EncodeLua writes to w a Lua table literal representing inst and its
+// descendants, which can be evaluated and used to rebuild the tree.
+//
+// Each instance is represented by a table with the following fields:
+//
+//	ID:         A number that identifies the instance within the tree.
+//	ClassName:  The class name of the instance.
+//	Properties: A table mapping property names to values.
+//	Children:   A list of tables representing each child instance.
+//
+// Property values are written as Lua values, using Roblox constructors where
+// applicable (e.g. Vector3.new). A Reference is written as a table with a
+// single field, Reference, which is the ID of the referent. References to
+// instances outside of the tree are written as nil. Tokens are written as
+// numbers, and UniqueIds as strings. Values of other types that cannot be
+// represented are written as nil.
+func EncodeLua(inst *Instance, w io.Writer) error {
+	// First pass: assign an ID to each instance.
+	ids := map[*Instance]int{}
+	var assign func(inst *Instance)
+	assign = func(inst *Instance) {
+		ids[inst] = len(ids) + 1
+		for _, child := range inst.Children {
+			if child != nil {
+				assign(child)
+			}
+		}
+	}
+	if inst != nil {
+		assign(inst)
+	}
+
+	// Second pass: write each instance.
+	e := &luaEncoder{w: bufio.NewWriter(w), ids: ids}
+	if inst == nil {
+		e.w.WriteString("nil")
+	} else {
+		e.encodeInstance(inst, 0)
+	}
+	e.w.WriteString("\n")
+	return e.w.Flush()
+}
+
+type luaEncoder struct {
+	w   *bufio.Writer
+	ids map[*Instance]int
+}
+
+func (e *luaEncoder) indent(depth int) {
+	e.w.WriteString(strings.Repeat("\t", depth))
+}
+
+func (e *luaEncoder) encodeInstance(inst *Instance, depth int) {
+	e.w.WriteString("{\n")
+
+	e.indent(depth + 1)
+	e.w.WriteString("ID = " + strconv.Itoa(e.ids[inst]) + ",\n")
+	e.indent(depth + 1)
+	e.w.WriteString("ClassName = " + luaString(inst.ClassName) + ",\n")
+
+	names := make([]string, 0, len(inst.Properties))
+	for name := range inst.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	e.indent(depth + 1)
+	if len(names) == 0 {
+		e.w.WriteString("Properties = {},\n")
+	} else {
+		e.w.WriteString("Properties = {\n")
+		for _, name := range names {
+			e.indent(depth + 2)
+			e.w.WriteString("[" + luaString(name) + "] = " + e.value(inst.Properties[name]) + ",\n")
+		}
+		e.indent(depth + 1)
+		e.w.WriteString("},\n")
+	}
+
+	e.indent(depth + 1)
+	if len(inst.Children) == 0 {
+		e.w.WriteString("Children = {},\n")
+	} else {
+		e.w.WriteString("Children = {\n")
+		for _, child := range inst.Children {
+			if child == nil {
+				continue
+			}
+			e.indent(depth + 2)
+			e.encodeInstance(child, depth+2)
+			e.w.WriteString(",\n")
+		}
+		e.indent(depth + 1)
+		e.w.WriteString("},\n")
+	}
+
+	e.indent(depth)
+	e.w.WriteString("}")
+}
+
+// luaString returns s as a quoted Lua string literal.
+func luaString(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < ' ' || c == 0x7F {
+				// Padded, so that a following digit is not consumed.
+				b = append(b, '\\')
+				b = append(b, []byte(strconv.Itoa(1000 + int(c))[1:])...)
+				continue
+			}
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
+
+// luaNumber returns f formatted as a Lua number expression.
+func luaNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "0/0"
+	case math.IsInf(f, 1):
+		return "math.huge"
+	case math.IsInf(f, -1):
+		return "-math.huge"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func luaFloat32(f float32) string {
+	switch {
+	case math.IsNaN(float64(f)), math.IsInf(float64(f), 0):
+		return luaNumber(float64(f))
+	}
+	return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
+
+// luaCall returns a call to a constructor with the given arguments.
+func luaCall(fn string, args ...string) string {
+	return fn + "(" + strings.Join(args, ", ") + ")"
+}
+
+func luaVector3(v ValueVector3) string {
+	return luaCall("Vector3.new", luaFloat32(v.X), luaFloat32(v.Y), luaFloat32(v.Z))
+}
+
+func luaColor3(v ValueColor3) string {
+	return luaCall("Color3.new", luaFloat32(v.R), luaFloat32(v.G), luaFloat32(v.B))
+}
+
+func (e *luaEncoder) value(value Value) string {
+	switch value := value.(type) {
+	case ValueString:
+		return luaString(string(value))
+	case ValueBinaryString:
+		return luaString(string(value))
+	case ValueProtectedString:
+		return luaString(string(value))
+	case ValueContent:
+		return luaString(string(value))
+	case ValueSharedString:
+		return luaString(string(value))
+	case ValueBool:
+		return strconv.FormatBool(bool(value))
+	case ValueInt:
+		return strconv.FormatInt(int64(value), 10)
+	case ValueInt64:
+		return strconv.FormatInt(int64(value), 10)
+	case ValueFloat:
+		return luaFloat32(float32(value))
+	case ValueDouble:
+		return luaNumber(float64(value))
+	case ValueToken:
+		return strconv.FormatUint(uint64(value), 10)
+	case ValueBrickColor:
+		return luaCall("BrickColor.new", strconv.FormatUint(uint64(value), 10))
+	case ValueUDim:
+		return luaCall("UDim.new", luaFloat32(value.Scale), strconv.FormatInt(int64(value.Offset), 10))
+	case ValueUDim2:
+		return luaCall("UDim2.new",
+			luaFloat32(value.X.Scale), strconv.FormatInt(int64(value.X.Offset), 10),
+			luaFloat32(value.Y.Scale), strconv.FormatInt(int64(value.Y.Offset), 10),
+		)
+	case ValueRay:
+		return luaCall("Ray.new", luaVector3(value.Origin), luaVector3(value.Direction))
+	case ValueFaces:
+		var args []string
+		for _, face := range []struct {
+			set  bool
+			name string
+		}{
+			{value.Right, "Right"},
+			{value.Top, "Top"},
+			{value.Back, "Back"},
+			{value.Left, "Left"},
+			{value.Bottom, "Bottom"},
+			{value.Front, "Front"},
+		} {
+			if face.set {
+				args = append(args, "Enum.NormalId."+face.name)
+			}
+		}
+		return luaCall("Faces.new", args...)
+	case ValueAxes:
+		var args []string
+		for _, axis := range []struct {
+			set  bool
+			name string
+		}{
+			{value.X, "X"},
+			{value.Y, "Y"},
+			{value.Z, "Z"},
+		} {
+			if axis.set {
+				args = append(args, "Enum.Axis."+axis.name)
+			}
+		}
+		return luaCall("Axes.new", args...)
+	case ValueColor3:
+		return luaColor3(value)
+	case ValueColor3uint8:
+		return luaCall("Color3.fromRGB",
+			strconv.Itoa(int(value.R)),
+			strconv.Itoa(int(value.G)),
+			strconv.Itoa(int(value.B)),
+		)
+	case ValueVector2:
+		return luaCall("Vector2.new", luaFloat32(value.X), luaFloat32(value.Y))
+	case ValueVector3:
+		return luaVector3(value)
+	case ValueVector2int16:
+		return luaCall("Vector2int16.new", strconv.Itoa(int(value.X)), strconv.Itoa(int(value.Y)))
+	case ValueVector3int16:
+		return luaCall("Vector3int16.new", strconv.Itoa(int(value.X)), strconv.Itoa(int(value.Y)), strconv.Itoa(int(value.Z)))
+	case ValueCFrame:
+		args := []string{luaFloat32(value.Position.X), luaFloat32(value.Position.Y), luaFloat32(value.Position.Z)}
+		for _, r := range value.Rotation {
+			args = append(args, luaFloat32(r))
+		}
+		return luaCall("CFrame.new", args...)
+	case ValueReference:
+		if id, ok := e.ids[value.Instance]; ok {
+			return "{Reference = " + strconv.Itoa(id) + "}"
+		}
+		return "nil"
+	case ValueNumberSequence:
+		keypoints := make([]string, len(value))
+		for i, k := range value {
+			keypoints[i] = luaCall("NumberSequenceKeypoint.new", luaFloat32(k.Time), luaFloat32(k.Value), luaFloat32(k.Envelope))
+		}
+		return luaCall("NumberSequence.new", "{"+strings.Join(keypoints, ", ")+"}")
+	case ValueColorSequence:
+		keypoints := make([]string, len(value))
+		for i, k := range value {
+			keypoints[i] = luaCall("ColorSequenceKeypoint.new", luaFloat32(k.Time), luaColor3(k.Value))
+		}
+		return luaCall("ColorSequence.new", "{"+strings.Join(keypoints, ", ")+"}")
+	case ValueNumberRange:
+		return luaCall("NumberRange.new", luaFloat32(value.Min), luaFloat32(value.Max))
+	case ValueRect2D:
+		return luaCall("Rect.new", luaFloat32(value.Min.X), luaFloat32(value.Min.Y), luaFloat32(value.Max.X), luaFloat32(value.Max.Y))
+	case ValuePhysicalProperties:
+		if !value.CustomPhysics {
+			return "nil"
+		}
+		return luaCall("PhysicalProperties.new",
+			luaFloat32(value.Density),
+			luaFloat32(value.Friction),
+			luaFloat32(value.Elasticity),
+			luaFloat32(value.FrictionWeight),
+			luaFloat32(value.ElasticityWeight),
+		)
+	case ValueFont:
+		style := "Enum.FontStyle.Normal"
+		if value.Style == FontStyleItalic {
+			style = "Enum.FontStyle.Italic"
+		}
+		return luaCall("Font.new",
+			luaString(string(value.Family)),
+			"Enum.FontWeight:FromValue("+strconv.Itoa(int(value.Weight))+")",
+			style,
+		)
+	case ValueUniqueId:
+		return luaString(value.String())
+	}
+	return "nil"
+}
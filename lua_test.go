@@ -0,0 +1,105 @@
+package rbxfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// luaTestTree returns a tree of instances for testing EncodeLua.
+func luaTestTree() *Instance {
+	part := NewInstance("Part", nil)
+	part.Set("Name", ValueString("Base\"Plate\"\n"))
+	part.Set("Anchored", ValueBool(true))
+	part.Set("Size", ValueVector3{X: 4, Y: 1.5, Z: 2})
+	part.Set("CFrame", ValueCFrame{
+		Position: ValueVector3{X: 0, Y: 10, Z: 0},
+		Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1},
+	})
+	part.Set("Material", ValueToken(256))
+	value := NewInstance("ObjectValue", part)
+	value.Set("Value", ValueReference{Instance: part})
+	value.Set("Outside", ValueReference{Instance: NewInstance("Part", nil)})
+	return part
+}
+
+func TestEncodeLua(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeLua(luaTestTree(), &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	const expected = `{
+	ID = 1,
+	ClassName = "Part",
+	Properties = {
+		["Anchored"] = true,
+		["CFrame"] = CFrame.new(0, 10, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1),
+		["Material"] = 256,
+		["Name"] = "Base\"Plate\"\n",
+		["Size"] = Vector3.new(4, 1.5, 2),
+	},
+	Children = {
+		{
+			ID = 2,
+			ClassName = "ObjectValue",
+			Properties = {
+				["Outside"] = nil,
+				["Value"] = {Reference = 1},
+			},
+			Children = {},
+		},
+	},
+}
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestEncodeLua_Syntax(t *testing.T) {
+	var luac string
+	for _, name := range []string{"luac", "luac5.1", "luac5.3", "luac5.4"} {
+		if path, err := exec.LookPath(name); err == nil {
+			luac = path
+			break
+		}
+	}
+	if luac == "" {
+		t.Skip("no Lua compiler found on PATH")
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeLua(luaTestTree(), &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "rbxfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "part.lua")
+	if err := ioutil.WriteFile(file, append([]byte("return "), buf.Bytes()...), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(luac, "-p", file).CombinedOutput(); err != nil {
+		t.Errorf("output is not valid Lua: %s\n%s", err, out)
+	}
+}
+
+func TestLuaString(t *testing.T) {
+	tests := map[string]string{
+		"":            `""`,
+		"a\\b":        `"a\\b"`,
+		"\x00" + "1":  `"\0001"`,
+		"\ttab\r\x7f": `"\ttab\r\127"`,
+	}
+	for s, expected := range tests {
+		if v := luaString(s); v != expected {
+			t.Errorf("%q: expected %s, got %s", s, expected, v)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package rbxfile
 
 import (
 	"encoding/hex"
+	"fmt"
 	"github.com/satori/go.uuid"
+	"sort"
 	"strings"
 )
 
@@ -39,6 +41,12 @@ func (refs References) Resolve(propRef PropRef) bool {
 // a new reference is generated and applied to the instance. The instance's
 // reference is then added to References.
 func (refs References) Get(instance *Instance) (ref string) {
+	return refs.GetWithPrefix(instance, "RBX")
+}
+
+// GetWithPrefix is like Get, but a generated reference begins with prefix
+// instead of "RBX".
+func (refs References) GetWithPrefix(instance *Instance, prefix string) (ref string) {
 	if instance == nil {
 		return ""
 	}
@@ -58,7 +66,7 @@ func (refs References) Get(instance *Instance) (ref string) {
 			// may not match Roblox's implementation. It is difficult to
 			// discern whether this is correct because it is extremely
 			// unlikely that a duplicate will be generated.
-			ref = GenerateReference()
+			ref = GenerateReferenceWithPrefix(prefix)
 			if _, ok := refs[ref]; !ok {
 				instance.Reference = ref
 				break
@@ -84,5 +92,236 @@ func IsEmptyReference(ref string) bool {
 // GenerateReference generates a unique string that can be used as a reference
 // to an Instance.
 func GenerateReference() string {
-	return "RBX" + strings.ToUpper(hex.EncodeToString(uuid.NewV4().Bytes()))
+	return GenerateReferenceWithPrefix("RBX")
+}
+
+// GenerateReferenceWithPrefix is like GenerateReference, but the generated
+// string begins with prefix instead of "RBX".
+func GenerateReferenceWithPrefix(prefix string) string {
+	return prefix + strings.ToUpper(hex.EncodeToString(uuid.NewV4().Bytes()))
+}
+
+// CanonicalizeReferent returns ref in the form produced by
+// GenerateReference: "RBX" followed by 32 uppercase hexadecimal digits. The
+// "RBX" prefix is optional and case-insensitive, and hyphens are ignored, so
+// a referent in the form of a UUID is accepted. If ref cannot be converted,
+// then it is returned unchanged.
+func CanonicalizeReferent(ref string) string {
+	s := ref
+	if len(s) >= 3 && strings.EqualFold(s[:3], "RBX") {
+		s = s[3:]
+	}
+	s = strings.Replace(s, "-", "", -1)
+	if len(s) != 32 {
+		return ref
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return ref
+	}
+	return "RBX" + strings.ToUpper(s)
+}
+
+// EqualReferents returns a function that reports whether a reference within
+// the tree of a and a reference within the tree of b refer to corresponding
+// instances. Comparing the values directly compares referents by pointer, so
+// a reference within a copy of a tree never equals the corresponding
+// reference within the original.
+//
+// Instead, a referent within a tree is compared by its position within the
+// tree, as with ContentHash, so two trees are compared by their structure
+// rather than by their Reference strings. An unresolved reference is located
+// in the same way, by finding the instance that has the referent string. A
+// referent outside of a tree is equal only to itself, an unresolved reference
+// that is not found is equal only to the same referent string, and two
+// references to nothing are equal.
+func EqualReferents(a, b *Root) func(x, y ValueReference) bool {
+	flatA, idsA := Flatten(a)
+	flatB, idsB := Flatten(b)
+	refsA, refsB := referenceIDs(flatA), referenceIDs(flatB)
+	position := func(v ValueReference, ids map[*Instance]int, refIDs map[string]int) (id int, ok bool) {
+		if v.Instance != nil {
+			id, ok = ids[v.Instance]
+		} else if v.Reference != "" {
+			id, ok = refIDs[v.Reference]
+		}
+		return id, ok
+	}
+	return func(x, y ValueReference) bool {
+		idX, inX := position(x, idsA, refsA)
+		idY, inY := position(y, idsB, refsB)
+		if inX || inY {
+			return inX && inY && idX == idY
+		}
+		if x.Instance != nil || y.Instance != nil {
+			return x.Instance == y.Instance
+		}
+		return x.Reference == y.Reference
+	}
+}
+
+// ReferenceProperty describes a property of an instance that is a
+// ValueReference.
+type ReferenceProperty struct {
+	// Instance is the instance containing the property.
+	Instance *Instance
+	// Property is the name of the property.
+	Property string
+	// Referent is the instance that the property refers to, which may be
+	// nil.
+	Referent *Instance
+	// Internal is whether Referent is an instance within the tree.
+	Internal bool
+}
+
+// CollectReferences walks through the tree of root, and returns every
+// property that is a ValueReference. Instances are traversed depth-first, and
+// the properties of each instance are ordered by name.
+func CollectReferences(root *Root) []ReferenceProperty {
+	if root == nil {
+		return nil
+	}
+
+	inTree := map[*Instance]bool{}
+	var mark func(instances []*Instance)
+	mark = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			inTree[inst] = true
+			mark(inst.Children)
+		}
+	}
+	mark(root.Instances)
+
+	var props []ReferenceProperty
+	var walk func(instances []*Instance)
+	walk = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			names := make([]string, 0, len(inst.Properties))
+			for name, value := range inst.Properties {
+				if _, ok := value.(ValueReference); ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				referent := inst.Properties[name].(ValueReference).Instance
+				props = append(props, ReferenceProperty{
+					Instance: inst,
+					Property: name,
+					Referent: referent,
+					Internal: inTree[referent],
+				})
+			}
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+	return props
+}
+
+// ReferenceGraph returns the references within the tree of root as an
+// adjacency list. Each instance that has a reference to an instance is mapped
+// to its outgoing edges, which map the name of each property to the referred
+// instance. References to nothing are excluded, while references to instances
+// outside of the tree are included.
+func ReferenceGraph(root *Root) map[*Instance]map[string]*Instance {
+	graph := map[*Instance]map[string]*Instance{}
+	for _, prop := range CollectReferences(root) {
+		if prop.Referent == nil {
+			continue
+		}
+		edges, ok := graph[prop.Instance]
+		if !ok {
+			edges = map[string]*Instance{}
+			graph[prop.Instance] = edges
+		}
+		edges[prop.Property] = prop.Referent
+	}
+	return graph
+}
+
+// ResolveReferences walks through the tree of root, and resolves references
+// by their reference strings. Each property that is a ValueReference to an
+// instance outside of the tree is set to refer to the instance within the
+// tree that has the same Reference string, if one exists. This allows a
+// reference to be specified with a placeholder instance that has only its
+// Reference set. References that cannot be resolved are left unchanged.
+//
+// An error is returned, and no references are changed, if more than one
+// instance within the tree has the same non-empty Reference.
+func ResolveReferences(root *Root) error {
+	if root == nil {
+		return nil
+	}
+
+	refs := make(References)
+	var err error
+	var mark func(instances []*Instance)
+	mark = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil || err != nil {
+				continue
+			}
+			if !IsEmptyReference(inst.Reference) {
+				if refs[inst.Reference] != nil {
+					err = fmt.Errorf("duplicate reference `%s`", inst.Reference)
+					continue
+				}
+				refs[inst.Reference] = inst
+			}
+			mark(inst.Children)
+		}
+	}
+	mark(root.Instances)
+	if err != nil {
+		return err
+	}
+
+	for _, prop := range CollectReferences(root) {
+		if prop.Referent == nil || prop.Internal || IsEmptyReference(prop.Referent.Reference) {
+			continue
+		}
+		if referent := refs[prop.Referent.Reference]; referent != nil {
+			prop.Instance.Properties[prop.Property] = ValueReference{Instance: referent}
+		}
+	}
+	return nil
+}
+
+// AssignReferents walks through the tree of root, and ensures that each
+// instance within the tree that is referred to by a ValueReference has a
+// Reference that is unique within the tree. A new reference is generated
+// for a referent whose Reference is empty, or is the same as that of an
+// instance that appears earlier in the tree. Instances that are not referred
+// to are not changed.
+func AssignReferents(root *Root) {
+	if root == nil {
+		return
+	}
+
+	refs := make(References)
+	var mark func(instances []*Instance)
+	mark = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if !IsEmptyReference(inst.Reference) && refs[inst.Reference] == nil {
+				refs[inst.Reference] = inst
+			}
+			mark(inst.Children)
+		}
+	}
+	mark(root.Instances)
+
+	for _, prop := range CollectReferences(root) {
+		if prop.Internal {
+			refs.Get(prop.Referent)
+		}
+	}
 }
@@ -0,0 +1,102 @@
+package rbxfile
+
+import (
+	"fmt"
+	"github.com/robloxapi/rbxapi"
+	"sort"
+)
+
+// Validate checks the tree of root against api, and returns an error for each
+// problem found. The following are checked:
+//
+//   - The class of each instance exists.
+//   - Each property is a member of the instance's class or superclasses.
+//   - Each token is the value of an item of the property's enum.
+//   - Each reference refers to an instance within the tree, or to nothing.
+//
+// Properties of an instance whose class does not exist are not checked
+// against the API. Instances are checked depth-first, and the properties of
+// each instance are checked in order by name. Returns nil if no problems were
+// found, or if api is nil.
+func Validate(root *Root, api *rbxapi.API) []error {
+	if root == nil || api == nil {
+		return nil
+	}
+
+	var errs []error
+
+	// Cache properties of each class, including inherited properties.
+	classProps := map[string]map[string]*rbxapi.Property{}
+	getProps := func(className string) map[string]*rbxapi.Property {
+		if props, ok := classProps[className]; ok {
+			return props
+		}
+		props := map[string]*rbxapi.Property{}
+		class, ok := api.Classes[className]
+		for ok {
+			for _, member := range class.MemberList() {
+				if prop, ok := member.(*rbxapi.Property); ok {
+					if _, ok := props[prop.MemberName]; !ok {
+						props[prop.MemberName] = prop
+					}
+				}
+			}
+			class, ok = api.Classes[class.Superclass]
+		}
+		classProps[className] = props
+		return props
+	}
+
+	inTree := map[*Instance]bool{}
+	var mark func(instances []*Instance)
+	mark = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			inTree[inst] = true
+			mark(inst.Children)
+		}
+	}
+	mark(root.Instances)
+
+	var walk func(instances []*Instance)
+	walk = func(instances []*Instance) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if _, ok := api.Classes[inst.ClassName]; !ok {
+				errs = append(errs, fmt.Errorf("%s: invalid class `%s`", inst.GetFullName(), inst.ClassName))
+			}
+
+			props := getProps(inst.ClassName)
+			names := make([]string, 0, len(inst.Properties))
+			for name := range inst.Properties {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				prop, ok := props[name]
+				if !ok && api.Classes[inst.ClassName] != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid property %s.`%s`", inst.GetFullName(), inst.ClassName, name))
+				}
+				switch value := inst.Properties[name].(type) {
+				case ValueToken:
+					if ok && api.Enums[prop.ValueType] != nil && !ValidateToken(api, prop.ValueType, value) {
+						errs = append(errs, fmt.Errorf("%s: invalid item `%d` for enum %s of property `%s`", inst.GetFullName(), value, prop.ValueType, name))
+					}
+				case ValueReference:
+					if value.Instance != nil && !inTree[value.Instance] {
+						errs = append(errs, fmt.Errorf("%s: property `%s` refers to instance outside of tree", inst.GetFullName(), name))
+					}
+				}
+			}
+
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+
+	return errs
+}
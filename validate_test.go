@@ -0,0 +1,75 @@
+package rbxfile
+
+import (
+	"github.com/robloxapi/rbxapi"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Instance": &rbxapi.Class{
+				Name: "Instance",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Name", MemberClass: "Instance", ValueType: "string"},
+				},
+			},
+			"Part": &rbxapi.Class{
+				Name:       "Part",
+				Superclass: "Instance",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Material", MemberClass: "Part", ValueType: "Material"},
+				},
+			},
+			"ObjectValue": &rbxapi.Class{
+				Name:       "ObjectValue",
+				Superclass: "Instance",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Value", MemberClass: "ObjectValue", ValueType: "Object"},
+				},
+			},
+		},
+		Enums: map[string]*rbxapi.Enum{
+			"Material": &rbxapi.Enum{Name: "Material", Items: []*rbxapi.EnumItem{{Name: "Plastic", Value: 256}}},
+		},
+	}
+
+	model := NewInstance("Model", nil)
+	model.SetName("Model")
+	part := NewInstance("Part", model)
+	part.SetName("Part")
+	part.Set("Material", ValueToken(1000))
+	part.Set("Colour", ValueColor3{})
+	good := NewInstance("Part", model)
+	good.SetName("Good")
+	good.Set("Material", ValueToken(256))
+	value := NewInstance("ObjectValue", model)
+	value.SetName("Value")
+	value.Set("Value", ValueReference{Instance: good})
+	root := &Root{Instances: []*Instance{model}}
+
+	expected := []string{
+		"Model: invalid class `Model`",
+		"Model.Part: invalid property Part.`Colour`",
+		"Model.Part: invalid item `1000` for enum Material of property `Material`",
+	}
+	errs := Validate(root, api)
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("error %d: expected %q, got %q", i, expected[i], err)
+		}
+	}
+
+	value.Set("Value", ValueReference{Instance: NewInstance("Part", nil)})
+	errs = Validate(root, api)
+	if len(errs) != 4 || errs[3].Error() != "Model.Value: property `Value` refers to instance outside of tree" {
+		t.Errorf("expected error for external reference, got %v", errs)
+	}
+
+	if errs := Validate(root, nil); errs != nil {
+		t.Errorf("expected no errors without API, got %v", errs)
+	}
+}
@@ -1,7 +1,13 @@
 package rbxfile
 
 import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"github.com/robloxapi/rbxapi"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -49,6 +55,11 @@ const (
 	TypeRect2D
 	TypePhysicalProperties
 	TypeColor3uint8
+	TypeFont
+	TypeUniqueId
+	TypeSharedString
+	TypeInt64
+	TypeRaw
 )
 
 // TypeFromString returns a Type from its string representation. TypeInvalid
@@ -114,6 +125,11 @@ var typeStrings = map[Type]string{
 	TypeRect2D:             "Rect2D",
 	TypePhysicalProperties: "PhysicalProperties",
 	TypeColor3uint8:        "Color3uint8",
+	TypeFont:               "Font",
+	TypeUniqueId:           "UniqueId",
+	TypeSharedString:       "SharedString",
+	TypeInt64:              "Int64",
+	TypeRaw:                "Raw",
 }
 
 // Value holds a value of a particular Type.
@@ -170,6 +186,11 @@ var valueGenerators = map[Type]valueGenerator{
 	TypeRect2D:             newValueRect2D,
 	TypePhysicalProperties: newValuePhysicalProperties,
 	TypeColor3uint8:        newValueColor3uint8,
+	TypeFont:               newValueFont,
+	TypeUniqueId:           newValueUniqueId,
+	TypeSharedString:       newValueSharedString,
+	TypeInt64:              newValueInt64,
+	TypeRaw:                newValueRaw,
 }
 
 func joinstr(a ...string) string {
@@ -227,6 +248,24 @@ func (t ValueBinaryString) Copy() Value {
 	return c
 }
 
+// DecodeTags decodes the value of a Tags property, which is a list of
+// CollectionService tags. Each tag is separated by a null character, with no
+// trailing null. Returns nil if v is empty.
+func DecodeTags(v ValueBinaryString) []string {
+	if len(v) == 0 {
+		return nil
+	}
+	return strings.Split(string(v), "\x00")
+}
+
+// EncodeTags encodes a list of CollectionService tags as the value of a Tags
+// property. Each tag is separated by a null character, with no trailing null.
+// Because a list with one empty tag is encoded the same as an empty list,
+// such a list does not round-trip.
+func EncodeTags(tags []string) ValueBinaryString {
+	return ValueBinaryString(strings.Join(tags, "\x00"))
+}
+
 ////////////////
 
 type ValueProtectedString []byte
@@ -616,6 +655,48 @@ func (t ValueCFrame) Copy() Value {
 	return t
 }
 
+// Orthonormalize returns a copy of the CFrame with a rotation matrix that is
+// orthonormal. The columns of the matrix, which are the right, up, and back
+// vectors, are re-orthogonalized by the Gram-Schmidt process, starting with
+// the right vector. The back vector is derived from the cross product of the
+// other two, so that the handedness of the matrix is preserved. If the right
+// or up vectors are degenerate, then the CFrame is returned unchanged.
+func (t ValueCFrame) Orthonormalize() ValueCFrame {
+	r := t.Rotation
+	x := [3]float64{float64(r[0]), float64(r[3]), float64(r[6])}
+	y := [3]float64{float64(r[1]), float64(r[4]), float64(r[7])}
+
+	norm := func(v [3]float64) ([3]float64, bool) {
+		n := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+		if n == 0 {
+			return v, false
+		}
+		return [3]float64{v[0] / n, v[1] / n, v[2] / n}, true
+	}
+
+	var ok bool
+	if x, ok = norm(x); !ok {
+		return t
+	}
+	d := x[0]*y[0] + x[1]*y[1] + x[2]*y[2]
+	y = [3]float64{y[0] - d*x[0], y[1] - d*x[1], y[2] - d*x[2]}
+	if y, ok = norm(y); !ok {
+		return t
+	}
+	z := [3]float64{
+		x[1]*y[2] - x[2]*y[1],
+		x[2]*y[0] - x[0]*y[2],
+		x[0]*y[1] - x[1]*y[0],
+	}
+
+	t.Rotation = [9]float32{
+		float32(x[0]), float32(y[0]), float32(z[0]),
+		float32(x[1]), float32(y[1]), float32(z[1]),
+		float32(x[2]), float32(y[2]), float32(z[2]),
+	}
+	return t
+}
+
 ////////////////
 
 type ValueToken uint32
@@ -634,10 +715,34 @@ func (t ValueToken) Copy() Value {
 	return t
 }
 
+// ValidateToken returns whether v is the value of an item of the enum named
+// enumName in api. False is returned if api is nil, or the enum does not
+// exist.
+func ValidateToken(api *rbxapi.API, enumName string, v ValueToken) bool {
+	if api == nil {
+		return false
+	}
+	enum := api.Enums[enumName]
+	if enum == nil {
+		return false
+	}
+	for _, item := range enum.Items {
+		if item.Value == int(v) {
+			return true
+		}
+	}
+	return false
+}
+
 ////////////////
 
 type ValueReference struct {
 	*Instance
+
+	// Reference is the referent string of a reference that was decoded
+	// without being resolved to an instance. It is used only when Instance
+	// is nil.
+	Reference string
 }
 
 func newValueReference() Value {
@@ -649,6 +754,9 @@ func (ValueReference) Type() Type {
 }
 func (t ValueReference) String() string {
 	if t.Instance == nil {
+		if t.Reference != "" {
+			return t.Reference
+		}
 		return "<nil>"
 	}
 	return t.Name()
@@ -746,6 +854,36 @@ func (t ValueNumberSequence) Copy() Value {
 	return c
 }
 
+// Normalize returns a copy of the sequence that has its keypoints sorted by
+// ascending time, with each time clamped between 0 and 1. Keypoints with equal
+// times retain their relative order. If merge is true, then only the first of
+// each group of keypoints with equal times is retained.
+func (t ValueNumberSequence) Normalize(merge bool) ValueNumberSequence {
+	c := make(ValueNumberSequence, len(t))
+	copy(c, t)
+	for i := range c {
+		if c[i].Time < 0 {
+			c[i].Time = 0
+		} else if c[i].Time > 1 {
+			c[i].Time = 1
+		}
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		return c[i].Time < c[j].Time
+	})
+	if merge && len(c) > 0 {
+		n := 1
+		for i := 1; i < len(c); i++ {
+			if c[i].Time != c[n-1].Time {
+				c[n] = c[i]
+				n++
+			}
+		}
+		c = c[:n]
+	}
+	return c
+}
+
 ////////////////
 
 type ValueColorSequenceKeypoint struct {
@@ -791,6 +929,29 @@ func (t ValueColorSequence) Copy() Value {
 	return c
 }
 
+// Validate checks whether the sequence would be accepted by Roblox. A valid
+// sequence has at least 2 keypoints, sorted by ascending time, where the
+// first keypoint has a time of 0, and the last keypoint has a time of 1. An
+// error describing the first violation is returned, or nil if the sequence is
+// valid.
+func (t ValueColorSequence) Validate() error {
+	if len(t) < 2 {
+		return fmt.Errorf("sequence has %d keypoints, at least 2 are required", len(t))
+	}
+	if t[0].Time != 0 {
+		return fmt.Errorf("first keypoint has time %s, expected 0", strconv.FormatFloat(float64(t[0].Time), 'f', -1, 32))
+	}
+	for i := 1; i < len(t); i++ {
+		if t[i].Time < t[i-1].Time {
+			return fmt.Errorf("keypoint %d has time %s, which is less than time of previous keypoint", i, strconv.FormatFloat(float64(t[i].Time), 'f', -1, 32))
+		}
+	}
+	if last := t[len(t)-1]; last.Time != 1 {
+		return fmt.Errorf("last keypoint has time %s, expected 1", strconv.FormatFloat(float64(last.Time), 'f', -1, 32))
+	}
+	return nil
+}
+
 ////////////////
 
 type ValueNumberRange struct {
@@ -904,3 +1065,157 @@ func (t ValueColor3uint8) Copy() Value {
 }
 
 ////////////////
+
+// FontStyle indicates the style of a ValueFont.
+type FontStyle uint8
+
+const (
+	FontStyleNormal FontStyle = 0
+	FontStyleItalic FontStyle = 1
+)
+
+// String returns the name of the style, or the number if the style is
+// unknown.
+func (s FontStyle) String() string {
+	switch s {
+	case FontStyleNormal:
+		return "Normal"
+	case FontStyleItalic:
+		return "Italic"
+	}
+	return strconv.FormatUint(uint64(s), 10)
+}
+
+type ValueFont struct {
+	Family       ValueContent
+	Weight       uint16
+	Style        FontStyle
+	CachedFaceId ValueContent
+}
+
+func newValueFont() Value {
+	return *new(ValueFont)
+}
+
+func (ValueFont) Type() Type {
+	return TypeFont
+}
+func (t ValueFont) String() string {
+	return joinstr(
+		string(t.Family),
+		", ",
+		strconv.FormatUint(uint64(t.Weight), 10),
+		", ",
+		t.Style.String(),
+	)
+}
+func (t ValueFont) Copy() Value {
+	c := t
+	if t.Family != nil {
+		c.Family = t.Family.Copy().(ValueContent)
+	}
+	if t.CachedFaceId != nil {
+		c.CachedFaceId = t.CachedFaceId.Copy().(ValueContent)
+	}
+	return c
+}
+
+////////////////
+
+type ValueUniqueId struct {
+	Random int64
+	Time   uint32
+	Index  uint32
+}
+
+func newValueUniqueId() Value {
+	return *new(ValueUniqueId)
+}
+
+func (ValueUniqueId) Type() Type {
+	return TypeUniqueId
+}
+func (t ValueUniqueId) String() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], uint64(t.Random))
+	binary.BigEndian.PutUint32(b[8:12], t.Time)
+	binary.BigEndian.PutUint32(b[12:16], t.Index)
+	return hex.EncodeToString(b[:])
+}
+func (t ValueUniqueId) Copy() Value {
+	return t
+}
+
+////////////////
+
+type ValueSharedString []byte
+
+func newValueSharedString() Value {
+	return make(ValueSharedString, 0)
+}
+
+func (ValueSharedString) Type() Type {
+	return TypeSharedString
+}
+func (t ValueSharedString) String() string {
+	return string(t)
+}
+func (t ValueSharedString) Copy() Value {
+	c := make(ValueSharedString, len(t))
+	copy(c, t)
+	return c
+}
+
+// SharedStringHash returns the MD5 digest of the raw bytes of b. The XML
+// encoder writes this digest in base64 as the md5 attribute of each shared
+// string entry. It has not been verified against files written by Studio.
+func SharedStringHash(b []byte) [16]byte {
+	return md5.Sum(b)
+}
+
+////////////////
+
+type ValueInt64 int64
+
+func newValueInt64() Value {
+	return *new(ValueInt64)
+}
+
+func (ValueInt64) Type() Type {
+	return TypeInt64
+}
+func (t ValueInt64) String() string {
+	return strconv.FormatInt(int64(t), 10)
+}
+func (t ValueInt64) Copy() Value {
+	return t
+}
+
+////////////////
+
+// ValueRaw holds a value that could not be decoded, preserved in the
+// serialized form of the format from which it was read, so that it may be
+// re-emitted unchanged. Format identifies the format, such as "xml", and Data
+// holds the serialized value. Encoders of other formats cannot emit the
+// value.
+type ValueRaw struct {
+	Format string
+	Data   []byte
+}
+
+func newValueRaw() Value {
+	return ValueRaw{}
+}
+
+func (ValueRaw) Type() Type {
+	return TypeRaw
+}
+func (t ValueRaw) String() string {
+	return string(t.Data)
+}
+func (t ValueRaw) Copy() Value {
+	t.Data = append([]byte(nil), t.Data...)
+	return t
+}
+
+////////////////
@@ -1,6 +1,8 @@
 package rbxfile
 
 import (
+	"encoding/base64"
+	"github.com/robloxapi/rbxapi"
 	"math"
 	"reflect"
 	"strings"
@@ -166,6 +168,203 @@ func TestValueString(t *testing.T) {
 		{ValueVector3int16{X: 1, Y: 2, Z: 3}, "1, 2, 3"},
 
 		{ValueVector2int16{X: 1, Y: 2}, "1, 2"},
+
+		{ValueFont{
+			Family: ValueContent("rbxasset://fonts/families/SourceSansPro.json"),
+			Weight: 700,
+			Style:  FontStyleItalic,
+		}, "rbxasset://fonts/families/SourceSansPro.json, 700, Italic"},
+
+		{ValueUniqueId{
+			Random: 0x44b188dace632b47,
+			Time:   0x02e9c68d,
+			Index:  0x004815fc,
+		}, "44b188dace632b4702e9c68d004815fc"},
 	},
 	)
 }
+
+func TestValueColorSequence_Validate(t *testing.T) {
+	key := func(time float32) ValueColorSequenceKeypoint {
+		return ValueColorSequenceKeypoint{Time: time}
+	}
+	tests := []struct {
+		v     ValueColorSequence
+		valid bool
+	}{
+		{ValueColorSequence{key(0), key(1)}, true},
+		{ValueColorSequence{key(0), key(0.25), key(0.25), key(1)}, true},
+		{ValueColorSequence{}, false},
+		{ValueColorSequence{key(0)}, false},
+		{ValueColorSequence{key(0.5), key(1)}, false},
+		{ValueColorSequence{key(0), key(0.5)}, false},
+		{ValueColorSequence{key(0), key(0.75), key(0.25), key(1)}, false},
+	}
+	for i, test := range tests {
+		if err := test.v.Validate(); (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid to be %t, got error %v", i, test.valid, err)
+		}
+	}
+}
+
+func TestValueNumberSequence_Normalize(t *testing.T) {
+	v := ValueNumberSequence{
+		{Time: 0.5, Value: 1},
+		{Time: 2, Value: 2},
+		{Time: -1, Value: 3},
+		{Time: 0.25, Value: 4},
+		{Time: 1, Value: 5},
+	}
+
+	expected := ValueNumberSequence{
+		{Time: 0, Value: 3},
+		{Time: 0.25, Value: 4},
+		{Time: 0.5, Value: 1},
+		{Time: 1, Value: 2},
+		{Time: 1, Value: 5},
+	}
+	if n := v.Normalize(false); !reflect.DeepEqual(n, expected) {
+		t.Errorf("unexpected normalized sequence (expected %v, got %v)", expected, n)
+	}
+
+	expected = ValueNumberSequence{
+		{Time: 0, Value: 3},
+		{Time: 0.25, Value: 4},
+		{Time: 0.5, Value: 1},
+		{Time: 1, Value: 2},
+	}
+	if n := v.Normalize(true); !reflect.DeepEqual(n, expected) {
+		t.Errorf("unexpected merged sequence (expected %v, got %v)", expected, n)
+	}
+
+	if v[0].Time != 0.5 || v[1].Time != 2 {
+		t.Error("original sequence was modified")
+	}
+}
+
+func TestValueCFrame_Orthonormalize(t *testing.T) {
+	// 90 degrees around the Y axis, with drift in each component.
+	cf := ValueCFrame{
+		Position: ValueVector3{X: 1, Y: 2, Z: 3},
+		Rotation: [9]float32{
+			0.001, 0.002, 1.01,
+			-0.003, 0.98, 0.004,
+			-1.02, 0.001, 0.002,
+		},
+	}
+	o := cf.Orthonormalize()
+	if o.Position != cf.Position {
+		t.Errorf("position was modified: %v", o.Position)
+	}
+
+	r := o.Rotation
+	col := func(i int) [3]float64 {
+		return [3]float64{float64(r[i]), float64(r[3+i]), float64(r[6+i])}
+	}
+	dot := func(a, b [3]float64) float64 {
+		return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			expected := 0.0
+			if i == j {
+				expected = 1
+			}
+			if d := dot(col(i), col(j)); math.Abs(d-expected) > 1e-6 {
+				t.Errorf("columns %d and %d: expected dot product %v, got %v", i, j, expected, d)
+			}
+		}
+	}
+
+	// Result should remain close to the intended rotation.
+	expected := [9]float32{0, 0, 1, 0, 1, 0, -1, 0, 0}
+	for i := range r {
+		if math.Abs(float64(r[i]-expected[i])) > 0.01 {
+			t.Errorf("unexpected rotation:\n\texpected: %v\n\tgot:      %v", expected, r)
+			break
+		}
+	}
+
+	if cf.Rotation[0] != 0.001 {
+		t.Error("original CFrame was modified")
+	}
+}
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		tags    []string
+		encoded string
+	}{
+		{[]string{"Lava", "", "Checkpoint"}, "Lava\x00\x00Checkpoint"},
+		{[]string{"Lava", "Kill"}, "Lava\x00Kill"},
+		{[]string{"Lava", ""}, "Lava\x00"},
+		{[]string{"Lava"}, "Lava"},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		v := EncodeTags(test.tags)
+		if string(v) != test.encoded {
+			t.Errorf("%q: expected %q, got %q", test.tags, test.encoded, v)
+		}
+		if tags := DecodeTags(v); !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%q: round-trip produced %q", test.tags, tags)
+		}
+	}
+
+	// A single empty tag is indistinguishable from no tags.
+	if v := EncodeTags([]string{""}); len(v) != 0 {
+		t.Errorf("expected empty value, got %q", v)
+	}
+}
+
+func TestSharedStringHash(t *testing.T) {
+	// Standard MD5 digests, in base64. These are not taken from a file
+	// written by Studio.
+	tests := []struct {
+		value string
+		key   string
+	}{
+		{"hello", "XUFAKrxLKna5cZ2REBfFkg=="},
+		{"world", "fXkwN6B2AYZXSwKC8vQ15w=="},
+		{"", "1B2M2Y8AsgTpgAmY7PhCfg=="},
+	}
+	for _, test := range tests {
+		sum := SharedStringHash([]byte(test.value))
+		if key := base64.StdEncoding.EncodeToString(sum[:]); key != test.key {
+			t.Errorf("%q: expected hash %s, got %s", test.value, test.key, key)
+		}
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	api := &rbxapi.API{
+		Enums: map[string]*rbxapi.Enum{
+			"Material": &rbxapi.Enum{
+				Name: "Material",
+				Items: []*rbxapi.EnumItem{
+					{Enum: "Material", Name: "Plastic", Value: 256},
+					{Enum: "Material", Name: "Wood", Value: 512},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		api   *rbxapi.API
+		enum  string
+		token ValueToken
+		valid bool
+	}{
+		{api, "Material", 256, true},
+		{api, "Material", 512, true},
+		{api, "Material", 257, false},
+		{api, "Material", 0, false},
+		{api, "Unknown", 256, false},
+		{nil, "Material", 256, false},
+	}
+	for _, test := range tests {
+		if v := ValidateToken(test.api, test.enum, test.token); v != test.valid {
+			t.Errorf("%s %d: expected %t, got %t", test.enum, test.token, test.valid, v)
+		}
+	}
+}
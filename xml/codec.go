@@ -3,12 +3,15 @@ package xml
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/robloxapi/rbxapi"
 	"github.com/robloxapi/rbxfile"
 	"io"
 	"io/ioutil"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -16,6 +19,14 @@ import (
 
 // RobloxCodec implements Decoder and Encoder to emulate Roblox's internal
 // codec as closely as possible.
+//
+// Comments that appear before an Item, or after its last child, are decoded
+// into the Comments and EndComments of the instance, and comments after the
+// last item are decoded into the EndComments of the root. Processing
+// instructions in the prolog of a document are decoded into the
+// ProcessingInstructions of the root. Each is written again when the tree is
+// encoded. Other comments, such as those between properties, are not
+// decoded.
 type RobloxCodec struct {
 	// API can be set to yield a more correct encoding or decoding by
 	// providing information about each class. If API is nil, the codec will
@@ -45,8 +56,219 @@ type RobloxCodec struct {
 	// generally preferred to set ExcludeInvalidAPI to false, so that false
 	// negatives do not lead to lost data.
 	ExcludeInvalidAPI bool
+
+	// ReportDanglingReferences determines whether a warning is emitted when
+	// decoding a reference property whose referent does not refer to any
+	// item in the document. Such properties are otherwise silently set to an
+	// empty reference.
+	ReportDanglingReferences bool
+
+	// BrickColorTag determines whether BrickColor values are encoded with a
+	// "BrickColor" tag. If false, they are encoded with an "int" tag, as
+	// Studio does. Either tag is decoded as a BrickColor when the API
+	// indicates that the property is a BrickColor, or when the tag is a
+	// "BrickColor" tag.
+	BrickColorTag bool
+
+	// OrthonormalizeCFrames determines whether the rotation of each decoded
+	// CoordinateFrame is made orthonormal with ValueCFrame.Orthonormalize.
+	OrthonormalizeCFrames bool
+
+	// MaxDepth is the maximum depth to which items may be nested when
+	// decoding, where top-level items have a depth of 1. An item that would
+	// exceed the depth is skipped along with its descendants, and a warning
+	// is emitted. If zero, DefaultMaxDepth is used. If negative, then depth
+	// is not limited.
+	MaxDepth int
+
+	// MaxInstances is the maximum number of instances that may be decoded.
+	// If decoding a document would produce more instances, then decoding is
+	// aborted, and an rbxfile.ErrMaxInstances is returned. If zero or less,
+	// the number of instances is not limited.
+	MaxInstances int
+
+	// MaxTags is used as the Document.MaxTags of each document parsed by
+	// DecodeStream, or by a Serializer that uses the codec as its Decoder.
+	// If a document has more tags, then parsing is aborted, and an
+	// ErrMaxTags is returned, which a Serializer wraps in an
+	// rbxfile.DecodeError. If zero or less, the number of tags is not
+	// limited.
+	MaxTags int
+
+	// MaxBytes is used as the Document.MaxBytes of each document parsed by
+	// DecodeStream, or by a Serializer that uses the codec as its Decoder.
+	// If a document is larger, then parsing is aborted, and an ErrMaxBytes
+	// is returned, which a Serializer wraps in an rbxfile.DecodeError. If
+	// zero or less, the size of a document is not limited.
+	MaxBytes int64
+
+	// MaxProperties is the maximum number of properties that may be decoded
+	// for a single item. Once an item has this many properties, its
+	// remaining property tags are skipped, and a warning is emitted. If zero
+	// or less, the number of properties is not limited.
+	MaxProperties int
+
+	// StrictContent determines whether a Content value is excluded when it
+	// is malformed in a way that Roblox rejects, such as a <null> tag that
+	// has content. If true, a warning is emitted, and the value is excluded.
+	// If false, the value is decoded as empty content.
+	StrictContent bool
+
+	// StrictVectors determines whether a Vector2 or Vector3 value is excluded
+	// when any of its component tags are missing, as Roblox does. If true, a
+	// warning is emitted, and the value is excluded. If false, missing
+	// components are set to zero.
+	StrictVectors bool
+
+	// Int16Overflow determines how a component of a Vector2int16 or
+	// Vector3int16 that is out of range of an int16 is handled when
+	// decoding. In each case, a warning is emitted.
+	Int16Overflow OverflowPolicy
+
+	// Base64LineWidth is the number of characters after which lines of
+	// base64-encoded BinaryString values are wrapped when encoding. If zero,
+	// DefaultBase64LineWidth is used. If negative, lines are not wrapped.
+	Base64LineWidth int
+
+	// CoerceNumbers determines whether numeric properties are coerced to
+	// the type indicated by the API when decoding. If true, and the tag of a
+	// property is one of "int", "int64", "float", or "double", while the
+	// API indicates a different one of these types, then the value is
+	// decoded according to the tag, then converted to the type indicated by
+	// the API, and a warning is emitted. A value that cannot be converted
+	// without overflow is excluded. Has no effect if API is nil.
+	CoerceNumbers bool
+
+	// ValidateContent determines whether the URL of each decoded Content
+	// value is checked against the schemes known to Roblox, listed in
+	// ContentSchemes. If true, a warning is emitted for each non-empty
+	// Content value with an unrecognized URL. The value is decoded
+	// regardless.
+	ValidateContent bool
+
+	// ContentRewriter, if not nil, is called with the URL of each decoded
+	// Content value, and the value is replaced with the result. This may be
+	// used to rewrite legacy URLs, such as local "rbxasset://" paths. The
+	// Content fields of other values, such as Font, are not rewritten.
+	ContentRewriter func(url string) string
+
+	// LazyReferences determines whether references are resolved when
+	// decoding. If true, each non-empty reference is decoded as an
+	// rbxfile.ValueReference with a nil Instance, and a Reference set to the
+	// referent string, and the pass that resolves references after the tree
+	// is built is skipped. When such a value is encoded, the referent string
+	// is rewritten along with the referent of the instance in the tree that
+	// has the same Reference. If there is no such instance, the string is
+	// encoded unchanged. The binary format resolves such a value against the
+	// instances in the tree, and encodes an unresolved value as nil with a
+	// warning.
+	LazyReferences bool
+
+	// RawUnknownTypes determines how a property with an unrecognized value
+	// tag is decoded. If true, the tag is preserved as an rbxfile.ValueRaw
+	// with the "xml" format, which is re-emitted unchanged when encoding.
+	// If false, the property is dropped. Tags whose type is determined by
+	// the API are not affected.
+	RawUnknownTypes bool
+
+	// PropertyFilter, if not nil, is called for each property when
+	// encoding, with the class name of the instance, and the name and value
+	// of the property. If it returns false, the property is excluded from
+	// the output. The encoded tree is not modified.
+	PropertyFilter func(class, name string, value rbxfile.Value) bool
+
+	// InstanceFilter, if not nil, is called for each instance when
+	// encoding. If skip is true, the instance is excluded from the output,
+	// and its children are encoded in its place, under its parent. If
+	// skipChildren is true, the children of the instance are excluded,
+	// along with their descendants. Returning true for both excludes the
+	// entire subtree. A reference to an excluded instance is encoded as
+	// null, and a warning is emitted. The encoded tree is not modified.
+	InstanceFilter func(inst *rbxfile.Instance) (skip, skipChildren bool)
+
+	// ShortestFloats determines how float and double values are formatted
+	// when encoding. If true, each value is written with the fewest digits
+	// that decode to the same value, as Studio does for some values. If
+	// false, each value is written with 9 significant digits. The values of
+	// NumberSequence, ColorSequence, and NumberRange are not affected.
+	ShortestFloats bool
+
+	// CaseInsensitiveOrder determines how the properties of each instance
+	// are ordered when encoding. If true, properties are sorted by name
+	// without regard to case, so that "archivable" sorts between "Anchored"
+	// and "BrickColor". Names that differ only by case are sorted
+	// case-sensitively. If false, properties are sorted by byte value, so
+	// all names starting with an uppercase letter sort first. Whether either
+	// order matches the order written by Studio has not been confirmed.
+	CaseInsensitiveOrder bool
+
+	// CanonicalReferents determines whether referents are rewritten to the
+	// form produced by rbxfile.GenerateReference when encoding, using
+	// rbxfile.CanonicalizeReferent. A referent that cannot be converted, or
+	// that would collide with another converted referent, is replaced with a
+	// newly generated one. The Reference fields of the encoded instances are
+	// not modified.
+	CanonicalReferents bool
+
+	// ReferentPrefix is the prefix of each referent generated when encoding,
+	// for an instance whose reference is empty or duplicated. If empty,
+	// "RBX" is used. This may be used to distinguish instances from
+	// different sources. Referents generated for CanonicalReferents always
+	// use "RBX".
+	ReferentPrefix string
+
+	// Prolog is set as the Prolog of the Document produced when encoding,
+	// and is written before the root tag. For example, XMLDeclaration may be
+	// used to write an XML declaration. If empty, the ProcessingInstructions
+	// of the encoded root are written, each on its own line. Studio writes
+	// nothing before the root tag.
+	Prolog string
+}
+
+// ContentSchemes is the list of URL schemes recognized by
+// RobloxCodec.ValidateContent.
+var ContentSchemes = []string{
+	"rbxassetid",
+	"rbxasset",
+	"rbxthumb",
+	"rbxhttp",
+	"http",
+	"https",
 }
 
+// validContentURL returns whether url has a scheme within ContentSchemes,
+// followed by a non-empty location.
+func validContentURL(url string) bool {
+	i := strings.Index(url, "://")
+	if i < 0 || i+3 == len(url) {
+		return false
+	}
+	for _, scheme := range ContentSchemes {
+		if strings.EqualFold(url[:i], scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultBase64LineWidth is the line width of base64-encoded data used when
+// RobloxCodec.Base64LineWidth is zero. This matches the width used by Roblox.
+const DefaultBase64LineWidth = 72
+
+// OverflowPolicy determines how a decoded number that is out of range of its
+// type is handled.
+type OverflowPolicy uint8
+
+const (
+	OverflowZero    OverflowPolicy = iota // The number is set to zero.
+	OverflowClamp                         // The number is clamped to the nearest value in range.
+	OverflowExclude                       // The value containing the number is excluded.
+)
+
+// DefaultMaxDepth is the maximum depth of nested items used when
+// RobloxCodec.MaxDepth is zero.
+const DefaultMaxDepth = 1000
+
 func (c RobloxCodec) Decode(document *Document) (root *rbxfile.Root, err error) {
 	if document == nil {
 		return nil, fmt.Errorf("document is nil")
@@ -92,23 +314,150 @@ type rdecoder struct {
 	err        error
 	instLookup rbxfile.References
 	propRefs   []rbxfile.PropRef
+
+	// depth is the number of ancestors of the items currently being
+	// decoded.
+	depth int
+
+	// count is the number of instances decoded so far.
+	count int
+
+	// externals is the set of referent strings declared by <External> tags
+	// in the document. These indicate a reference to no instance. If nil,
+	// rbxfile.IsEmptyReference is used instead.
+	externals map[string]bool
+
+	// sharedStrings maps the keys of a document's SharedStrings block to the
+	// values they refer to.
+	sharedStrings map[string]rbxfile.ValueSharedString
+
+	// missingSharedStrings is the set of keys used by SharedString
+	// properties that were not found in sharedStrings.
+	missingSharedStrings map[string]bool
+}
+
+// isEmptyRef returns whether a referent string refers to no instance,
+// according to the External declarations of the document.
+func (dec *rdecoder) isEmptyRef(ref string) bool {
+	if ref == "" {
+		return true
+	}
+	if dec.externals == nil {
+		return rbxfile.IsEmptyReference(ref)
+	}
+	return dec.externals[ref]
+}
+
+// getExternals collects the contents of each <External> tag within the given
+// tags. Returns nil if there are no such tags.
+func getExternals(tags []*Tag) (externals map[string]bool) {
+	for _, tag := range tags {
+		if tag.StartName != "External" {
+			continue
+		}
+		if externals == nil {
+			externals = make(map[string]bool)
+		}
+		externals[getContent(tag)] = true
+	}
+	return externals
+}
+
+// getSharedStrings decodes the SharedString tags within each <SharedStrings>
+// tag within the given tags. Returns nil if there are no such tags.
+func (dec *rdecoder) getSharedStrings(tags []*Tag) (sharedStrings map[string]rbxfile.ValueSharedString) {
+	for _, tag := range tags {
+		if tag.StartName != "SharedStrings" {
+			continue
+		}
+		if sharedStrings == nil {
+			sharedStrings = make(map[string]rbxfile.ValueSharedString)
+		}
+		for _, subtag := range tag.Tags {
+			if subtag.StartName != "SharedString" {
+				continue
+			}
+			key, ok := subtag.AttrValue("md5")
+			if !ok {
+				dec.document.Warnings = append(dec.document.Warnings, errors.New("shared string with missing md5 attribute"))
+				continue
+			}
+			if _, ok := sharedStrings[key]; ok {
+				dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("duplicate shared string `%s`", key))
+				continue
+			}
+			v, err := base64.StdEncoding.DecodeString(strings.TrimSpace(getContent(subtag)))
+			if err != nil {
+				dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("shared string `%s`: %s", key, err))
+				continue
+			}
+			sharedStrings[key] = rbxfile.ValueSharedString(v)
+		}
+	}
+	return sharedStrings
 }
 
 func (dec *rdecoder) decode() error {
 	if dec.err != nil {
 		return dec.err
 	}
+	if dec.document.Root == nil {
+		dec.root = nil
+		dec.err = errors.New("document has no root tag")
+		return dec.err
+	}
 
 	dec.root = new(rbxfile.Root)
+	dec.document.UnknownClasses = nil
+	dec.document.DroppedProperties = nil
+	dec.externals = getExternals(dec.document.Root.Tags)
+	dec.sharedStrings = dec.getSharedStrings(dec.document.Root.Tags)
 	dec.root.Instances, _ = dec.getItems(nil, dec.document.Root.Tags, nil)
+	if dec.err != nil {
+		dec.root = nil
+		return dec.err
+	}
+	dec.root.EndComments = append([]string(nil), dec.document.Root.EndComments...)
+	dec.root.ProcessingInstructions = processingInstructions(dec.document.Prolog)
 
 	for _, propRef := range dec.propRefs {
-		dec.instLookup.Resolve(propRef)
+		if !dec.instLookup.Resolve(propRef) && dec.codec.ReportDanglingReferences {
+			dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("property %s.`%s` refers to missing referent `%s`", propRef.Instance.GetFullName(), propRef.Property, propRef.Reference))
+		}
 	}
 
 	return nil
 }
 
+// processingInstructions returns the text of each processing instruction
+// within prolog.
+func processingInstructions(prolog string) (pis []string) {
+	for {
+		i := strings.Index(prolog, "<?")
+		if i < 0 {
+			return pis
+		}
+		j := strings.Index(prolog[i+2:], "?>")
+		if j < 0 {
+			return pis
+		}
+		pis = append(pis, prolog[i+2:i+2+j])
+		prolog = prolog[i+2+j+2:]
+	}
+}
+
+// maxDepth returns the maximum depth of nested items, or -1 if depth is not
+// limited.
+func (dec *rdecoder) maxDepth() int {
+	switch {
+	case dec.codec.MaxDepth == 0:
+		return DefaultMaxDepth
+	case dec.codec.MaxDepth < 0:
+		return -1
+	}
+	return dec.codec.MaxDepth
+}
+
 func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*Tag, classMembers map[string]*rbxapi.Property) (instances []*rbxfile.Instance, properties map[string]rbxfile.Value) {
 	properties = make(map[string]rbxfile.Value)
 	hasProps := false
@@ -116,6 +465,11 @@ func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*Tag, classMember
 	for _, tag := range tags {
 		switch tag.StartName {
 		case "Item":
+			if max := dec.maxDepth(); max >= 0 && dec.depth >= max {
+				dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("item exceeds maximum depth of %d", max))
+				continue
+			}
+
 			className, ok := tag.AttrValue("class")
 			if !ok {
 				dec.document.Warnings = append(dec.document.Warnings, errors.New("item with missing class attribute"))
@@ -126,23 +480,45 @@ func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*Tag, classMember
 			if dec.codec.API != nil {
 				if dec.codec.API.Classes[className] == nil {
 					dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("invalid class name `%s`", className))
+					if dec.document.UnknownClasses == nil {
+						dec.document.UnknownClasses = make(map[string]bool)
+					}
+					dec.document.UnknownClasses[className] = true
 					if dec.codec.ExcludeInvalidAPI {
 						continue
 					}
 				}
 			}
 
+			if max := dec.codec.MaxInstances; max > 0 && dec.count >= max {
+				dec.err = rbxfile.ErrMaxInstances{Max: max}
+				return nil, nil
+			}
+			dec.count++
+
 			instance := rbxfile.NewInstance(className, nil)
+			instance.Comments = append([]string(nil), tag.Comments...)
+			instance.EndComments = append([]string(nil), tag.EndComments...)
 			referent, ok := tag.AttrValue("referent")
 			if ok && len(referent) > 0 {
 				instance.Reference = referent
-				if !rbxfile.IsEmptyReference(referent) {
-					dec.instLookup[referent] = instance
+				if !dec.isEmptyRef(referent) {
+					if dec.instLookup[referent] != nil {
+						// Keep the first instance, as Roblox does.
+						dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("duplicate referent `%s`", referent))
+					} else {
+						dec.instLookup[referent] = instance
+					}
 				}
 			}
 
 			var children []*rbxfile.Instance
+			dec.depth++
 			children, instance.Properties = dec.getItems(instance, tag.Tags, classMemb)
+			dec.depth--
+			if dec.err != nil {
+				return nil, nil
+			}
 			for _, child := range children {
 				instance.AddChild(child)
 			}
@@ -156,6 +532,10 @@ func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*Tag, classMember
 			hasProps = true
 
 			for _, property := range tag.Tags {
+				if max := dec.codec.MaxProperties; max > 0 && len(properties) >= max {
+					dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("item `%s` exceeds maximum of %d properties", parent.ClassName, max))
+					break
+				}
 				name, value, ok := dec.getProperty(property, parent, classMembers)
 				if ok {
 					properties[name] = value
@@ -190,6 +570,90 @@ func (c RobloxCodec) DecodeProperties(tags []*Tag, inst *rbxfile.Instance, refs
 	return dec.propRefs
 }
 
+// DecodeStream reads a document from r, and calls fn with each top-level
+// instance as soon as its Item tag has been read. Unlike Decode, the document
+// is never held in memory as a whole, which makes it suitable for very large
+// files. The returned Document contains everything except top-level Items,
+// along with any warnings produced while decoding.
+//
+// Because each instance is passed to fn before the rest of the document is
+// read, references can only be resolved within the same top-level instance.
+// Resolving references across instances would require either holding every
+// instance until the end of the document, or reading the document twice.
+// Instead, such references are left empty, and are returned as a list of
+// unresolved references. These may be resolved by the caller, using the
+// Reference field of the received instances, if needed.
+//
+// Likewise, SharedString properties can only be decoded if the SharedStrings
+// block appears before the items that use it. Otherwise, the properties are
+// dropped, and a warning is added for each shared string that was defined
+// too late.
+//
+// If fn returns an error, then decoding stops, and the error is returned.
+func (c RobloxCodec) DecodeStream(r io.Reader, fn func(inst *rbxfile.Instance) error) (document *Document, propRefs []rbxfile.PropRef, err error) {
+	if fn == nil {
+		return nil, nil, fmt.Errorf("function is nil")
+	}
+
+	document = &Document{MaxTags: c.MaxTags, MaxBytes: c.MaxBytes}
+	dec := &rdecoder{
+		document: document,
+		codec:    c,
+	}
+	_, err = document.ReadStreamFrom(r, func(item *Tag) error {
+		if dec.externals == nil {
+			dec.externals = getExternals(document.Root.Tags)
+		}
+		if dec.sharedStrings == nil {
+			dec.sharedStrings = dec.getSharedStrings(document.Root.Tags)
+		}
+		dec.instLookup = make(rbxfile.References)
+		dec.propRefs = dec.propRefs[:0]
+
+		instances, _ := dec.getItems(nil, []*Tag{item}, nil)
+		if dec.err != nil {
+			return dec.err
+		}
+		for _, propRef := range dec.propRefs {
+			if !dec.instLookup.Resolve(propRef) {
+				propRefs = append(propRefs, propRef)
+			}
+		}
+		for _, inst := range instances {
+			if err := fn(inst); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		dec.warnLateSharedStrings()
+	}
+	return document, propRefs, err
+}
+
+// warnLateSharedStrings adds a warning for each missing shared string that is
+// defined in the document, which happens when the SharedStrings block appears
+// after the items that use it.
+func (dec *rdecoder) warnLateSharedStrings() {
+	if len(dec.missingSharedStrings) == 0 {
+		return
+	}
+	for _, tag := range dec.document.Root.Tags {
+		if tag.StartName != "SharedStrings" {
+			continue
+		}
+		for _, subtag := range tag.Tags {
+			key, ok := subtag.AttrValue("md5")
+			if !ok || subtag.StartName != "SharedString" || !dec.missingSharedStrings[key] {
+				continue
+			}
+			dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("shared string `%s` is defined after the items that use it", key))
+			delete(dec.missingSharedStrings, key)
+		}
+	}
+}
+
 func (dec *rdecoder) getProperty(tag *Tag, instance *rbxfile.Instance, classMembers map[string]*rbxapi.Property) (name string, value rbxfile.Value, ok bool) {
 	name, ok = tag.AttrValue("name")
 	if !ok {
@@ -198,6 +662,8 @@ func (dec *rdecoder) getProperty(tag *Tag, instance *rbxfile.Instance, classMemb
 
 	var valueType string
 	var enum *rbxapi.Enum
+	var coerceFrom string
+	var hint rbxfile.Type
 	if dec.codec.API != nil && classMembers != nil {
 		// Determine property type from API.
 		propAPI, ok := classMembers[name]
@@ -206,6 +672,11 @@ func (dec *rdecoder) getProperty(tag *Tag, instance *rbxfile.Instance, classMemb
 			if e, ok := dec.codec.API.Enums[valueType]; ok {
 				valueType = "token"
 				enum = e
+			} else if dec.codec.CoerceNumbers {
+				tagType := dec.codec.GetCanonType(tag.StartName)
+				if tagType != valueType && isNumberType(tagType) && isNumberType(valueType) {
+					coerceFrom = tagType
+				}
 			}
 			goto processValue
 		} else if dec.codec.ExcludeInvalidAPI {
@@ -216,15 +687,56 @@ func (dec *rdecoder) getProperty(tag *Tag, instance *rbxfile.Instance, classMemb
 
 	// Guess property type from tag name
 	valueType = dec.codec.GetCanonType(tag.StartName)
+	hint = dec.tagTypeHint(tag, instance.ClassName, name)
+	if valueType == "" {
+		switch hint {
+		case rbxfile.TypeString, rbxfile.TypeProtectedString, rbxfile.TypeContent:
+			// Read unrecognized tag as a string, to be converted by the
+			// hint.
+			valueType = "string"
+		}
+	}
+
+	if valueType == "" && dec.codec.RawUnknownTypes {
+		return name, rawValue(tag), true
+	}
 
 processValue:
-	value, ok = dec.getValue(tag, valueType, enum)
-	if !ok {
-		return "", nil, false
+	if coerceFrom != "" {
+		value, ok = dec.getValue(tag, coerceFrom, nil)
+		if !ok {
+			dec.dropProperty(instance, name, tag)
+			return "", nil, false
+		}
+		if value, ok = coerceNumber(value, valueType); !ok {
+			dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("property %s.`%s` overflows %s", instance.ClassName, name, valueType))
+			dec.dropProperty(instance, name, tag)
+			return "", nil, false
+		}
+		dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("coerced property %s.`%s` from %s to %s", instance.ClassName, name, coerceFrom, valueType))
+	} else {
+		value, ok = dec.getValue(tag, valueType, enum)
+		if !ok {
+			dec.dropProperty(instance, name, tag)
+			return "", nil, false
+		}
+	}
+	value = hintValue(hint, value)
+	if cf, ok := value.(rbxfile.ValueCFrame); ok && dec.codec.OrthonormalizeCFrames {
+		value = cf.Orthonormalize()
+	}
+	if c, ok := value.(rbxfile.ValueContent); ok && dec.codec.ContentRewriter != nil {
+		value = rbxfile.ValueContent(dec.codec.ContentRewriter(string(c)))
+	}
+	if c, ok := value.(rbxfile.ValueContent); ok && dec.codec.ValidateContent && len(c) > 0 && !validContentURL(string(c)) {
+		dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("property %s.`%s` has unrecognized content URL `%s`", instance.ClassName, name, c))
 	}
 
 	ref := getContent(tag)
-	if _, ok := value.(rbxfile.ValueReference); ok && !rbxfile.IsEmptyReference(ref) {
+	if _, ok := value.(rbxfile.ValueReference); ok && !dec.isEmptyRef(ref) {
+		if dec.codec.LazyReferences {
+			return name, rbxfile.ValueReference{Reference: ref}, true
+		}
 		dec.propRefs = append(dec.propRefs, rbxfile.PropRef{
 			Instance:  instance,
 			Property:  name,
@@ -236,6 +748,216 @@ processValue:
 	return name, value, ok
 }
 
+// rawValue returns tag preserved as a ValueRaw.
+func rawValue(tag *Tag) rbxfile.ValueRaw {
+	var buf bytes.Buffer
+	doc := &Document{Root: NewRoot(tag), ExcludeRoot: true}
+	doc.WriteTo(&buf)
+	return rbxfile.ValueRaw{Format: "xml", Data: buf.Bytes()}
+}
+
+// rawTag returns the tag preserved by a ValueRaw.
+func rawTag(value rbxfile.ValueRaw) (*Tag, error) {
+	if value.Format != "xml" {
+		return nil, fmt.Errorf("unsupported format %q", value.Format)
+	}
+	// The document must have a roblox root, so the tag is wrapped in one.
+	r := io.MultiReader(
+		strings.NewReader(`<roblox version="4">`),
+		bytes.NewReader(value.Data),
+		strings.NewReader(`</roblox>`),
+	)
+	doc := new(Document)
+	if _, err := doc.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if len(doc.Root.Tags) != 1 {
+		return nil, errors.New("expected one tag")
+	}
+	return doc.Root.Tags[0], nil
+}
+
+// dropProperty records a property whose value could not be decoded.
+func (dec *rdecoder) dropProperty(instance *rbxfile.Instance, name string, tag *Tag) {
+	if dec.document == nil {
+		return
+	}
+	dec.document.DroppedProperties = append(dec.document.DroppedProperties, DroppedProperty{
+		Class:    instance.ClassName,
+		Property: name,
+		Tag:      tag.StartName,
+	})
+}
+
+// isNumberType returns whether a canonical type is numeric.
+func isNumberType(valueType string) bool {
+	switch valueType {
+	case "int", "int64", "float", "double":
+		return true
+	}
+	return false
+}
+
+// coerceNumber converts a numeric value to the given canonical numeric type.
+// Floating-point values are truncated when converted to integers. Returns
+// false if the value is not numeric, or does not fit within the type.
+func coerceNumber(value rbxfile.Value, valueType string) (rbxfile.Value, bool) {
+	var f float64
+	switch v := value.(type) {
+	case rbxfile.ValueInt:
+		f = float64(v)
+	case rbxfile.ValueInt64:
+		if valueType == "int" {
+			if v < math.MinInt32 || v > math.MaxInt32 {
+				return nil, false
+			}
+			return rbxfile.ValueInt(v), true
+		}
+		f = float64(v)
+	case rbxfile.ValueFloat:
+		f = float64(v)
+	case rbxfile.ValueDouble:
+		f = float64(v)
+	default:
+		return nil, false
+	}
+	switch valueType {
+	case "int":
+		if math.IsNaN(f) || f < math.MinInt32 || f > math.MaxInt32 {
+			return nil, false
+		}
+		return rbxfile.ValueInt(f), true
+	case "int64":
+		if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return nil, false
+		}
+		return rbxfile.ValueInt64(f), true
+	case "float":
+		return rbxfile.ValueFloat(f), true
+	case "double":
+		return rbxfile.ValueDouble(f), true
+	}
+	return nil, false
+}
+
+// parseInt parses the content of tag as an integer of the given bit size. If
+// the content is not an integer, then it is parsed as a floating-point
+// number, such as "1e3", and truncated, and a warning is emitted. Returns
+// false if the content is not a number, or does not fit within the bit size.
+func (dec *rdecoder) parseInt(tag *Tag, bitSize int) (int64, bool) {
+	content := getContent(tag)
+	v, err := strconv.ParseInt(content, 10, bitSize)
+	if err == nil {
+		return v, true
+	}
+	if err, ok := err.(*strconv.NumError); ok && err.Err == strconv.ErrRange {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(content, 64)
+	limit := math.Ldexp(1, bitSize-1)
+	if err != nil || math.IsNaN(f) || f < -limit || f >= limit {
+		return 0, false
+	}
+	if dec.document != nil {
+		dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("integer `%s` parsed as floating-point number", content))
+	}
+	return int64(f), true
+}
+
+// BinaryStringReader returns a reader that decodes the base64 content of a
+// BinaryString property tag as it is read. Unlike decoding the tag with
+// RobloxCodec, the decoded bytes are not held in memory all at once, which
+// allows large values to be copied elsewhere, such as to a file. Line breaks
+// within the content are ignored. Reading returns an error if the content is
+// not valid base64.
+func BinaryStringReader(tag *Tag) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(getContent(tag)))
+}
+
+// propertyTypeHints maps the name of a property to the type that its value is
+// expected to have. A name may be qualified by a class name, as "Class.Name",
+// which takes precedence over the unqualified name. It is used only when
+// decoding without an API, to classify values that would otherwise be decoded
+// according to their tag name.
+//
+// BrickColor properties are included because BrickColor values are encoded
+// in int tags by default.
+var propertyTypeHints = map[string]rbxfile.Type{
+	"Source":                   rbxfile.TypeProtectedString,
+	"LinkedSource":             rbxfile.TypeContent,
+	"BrickColor":               rbxfile.TypeBrickColor,
+	"TeamColor":                rbxfile.TypeBrickColor,
+	"BodyColors.HeadColor":     rbxfile.TypeBrickColor,
+	"BodyColors.TorsoColor":    rbxfile.TypeBrickColor,
+	"BodyColors.LeftArmColor":  rbxfile.TypeBrickColor,
+	"BodyColors.RightArmColor": rbxfile.TypeBrickColor,
+	"BodyColors.LeftLegColor":  rbxfile.TypeBrickColor,
+	"BodyColors.RightLegColor": rbxfile.TypeBrickColor,
+	"BrickColorValue.Value":    rbxfile.TypeBrickColor,
+}
+
+// typeHint returns the type hinted for a property by propertyTypeHints.
+func typeHint(class, name string) rbxfile.Type {
+	if typ, ok := propertyTypeHints[class+"."+name]; ok {
+		return typ
+	}
+	return propertyTypeHints[name]
+}
+
+// tagTypeHint returns the type hinted for a property decoded from tag, when
+// the type is not determined by the API. An explicit "type" attribute on the
+// tag takes precedence over propertyTypeHints, which is used only when
+// decoding without an API. Returns TypeInvalid if there is no hint.
+func (dec *rdecoder) tagTypeHint(tag *Tag, class, name string) rbxfile.Type {
+	if t, ok := tag.AttrValue("type"); ok {
+		if typ := rbxfile.TypeFromString(t); typ != rbxfile.TypeInvalid {
+			return typ
+		}
+		dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("property %s.`%s` has unknown type `%s`", class, name, t))
+	}
+	if dec.codec.API == nil {
+		return typeHint(class, name)
+	}
+	return rbxfile.TypeInvalid
+}
+
+// hintValue converts the value of a property to a hinted type. String-like
+// values are converted to String, ProtectedString, or Content, and int values
+// are converted to BrickColor or Token. The value is returned unchanged if it
+// cannot be converted, or if hint is TypeInvalid.
+func hintValue(hint rbxfile.Type, value rbxfile.Value) rbxfile.Value {
+	var b []byte
+	switch v := value.(type) {
+	case rbxfile.ValueString:
+		b = v
+	case rbxfile.ValueBinaryString:
+		b = v
+	case rbxfile.ValueProtectedString:
+		b = v
+	case rbxfile.ValueContent:
+		b = v
+	case rbxfile.ValueInt:
+		switch {
+		case hint == rbxfile.TypeBrickColor && v >= 0:
+			return rbxfile.ValueBrickColor(v)
+		case hint == rbxfile.TypeToken && v >= 0:
+			return rbxfile.ValueToken(v)
+		}
+		return value
+	default:
+		return value
+	}
+	switch hint {
+	case rbxfile.TypeString:
+		return rbxfile.ValueString(b)
+	case rbxfile.TypeProtectedString:
+		return rbxfile.ValueProtectedString(b)
+	case rbxfile.TypeContent:
+		return rbxfile.ValueContent(b)
+	}
+	return value
+}
+
 // GetCanonType converts a string (usually from a tag name) to a decodable
 // type.
 func (RobloxCodec) GetCanonType(valueType string) string {
@@ -262,6 +984,8 @@ func (RobloxCodec) GetCanonType(valueType string) string {
 		return "float"
 	case "int":
 		return "int"
+	case "int64":
+		return "int64"
 	case "protectedstring":
 		return "ProtectedString"
 	case "ray":
@@ -296,6 +1020,12 @@ func (RobloxCodec) GetCanonType(valueType string) string {
 		return "PhysicalProperties"
 	case "color3uint8":
 		return "Color3uint8"
+	case "font":
+		return "Font"
+	case "uniqueid":
+		return "UniqueId"
+	case "sharedstring":
+		return "SharedString"
 	}
 	return ""
 }
@@ -305,6 +1035,33 @@ func (RobloxCodec) GetCanonType(valueType string) string {
 // the API dump. If guessing the type, it should be converted to one of these
 // first.
 func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (value rbxfile.Value, ok bool) {
+	if isEmptyTag(tag) {
+		// An empty tag, such as a self-closing tag, decodes to the zero
+		// value of a type that is otherwise read from text.
+		switch valueType {
+		case "bool":
+			return rbxfile.ValueBool(false), true
+		case "int":
+			return rbxfile.ValueInt(0), true
+		case "int64":
+			return rbxfile.ValueInt64(0), true
+		case "float":
+			return rbxfile.ValueFloat(0), true
+		case "double":
+			return rbxfile.ValueDouble(0), true
+		case "token":
+			return dec.checkToken(0, enum)
+		case "BrickColor":
+			return rbxfile.ValueBrickColor(0), true
+		case "Color3uint8":
+			return rbxfile.ValueColor3uint8{}, true
+		case "NumberRange":
+			return rbxfile.ValueNumberRange{}, true
+		case "Content":
+			return rbxfile.ValueContent{}, true
+		}
+	}
+
 	switch valueType {
 	case "Axes":
 		var bits int32
@@ -319,8 +1076,7 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 		}, true
 
 	case "BinaryString":
-		dec := base64.NewDecoder(base64.StdEncoding, strings.NewReader(getContent(tag)))
-		v, err := ioutil.ReadAll(dec)
+		v, err := ioutil.ReadAll(BinaryStringReader(tag))
 		if err != nil {
 			return nil, false
 		}
@@ -345,6 +1101,62 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 
 	case "CoordinateFrame":
 		v := *new(rbxfile.ValueCFrame)
+		if hasQuaternion(tag) {
+			var q [4]float32
+			components{
+				"X":  &v.Position.X,
+				"Y":  &v.Position.Y,
+				"Z":  &v.Position.Z,
+				"QX": &q[0],
+				"QY": &q[1],
+				"QZ": &q[2],
+				"QW": &q[3],
+			}.getFrom(tag)
+			v.Rotation = quaternionToMatrix(q[0], q[1], q[2], q[3])
+			return v, true
+		}
+		if matrix := getLegacyMatrix(tag); matrix != nil {
+			// Legacy form, where the rotation is nested in a Matrix tag.
+			components{
+				"X": &v.Position.X,
+				"Y": &v.Position.Y,
+				"Z": &v.Position.Z,
+			}.getFrom(tag)
+			components{
+				"M00": &v.Rotation[0],
+				"M01": &v.Rotation[1],
+				"M02": &v.Rotation[2],
+				"M10": &v.Rotation[3],
+				"M11": &v.Rotation[4],
+				"M12": &v.Rotation[5],
+				"M20": &v.Rotation[6],
+				"M21": &v.Rotation[7],
+				"M22": &v.Rotation[8],
+			}.getFrom(matrix)
+			return v, true
+		}
+		if !hasRotationMatrix(tag) {
+			// Rotation is either given by an orientation ID, or is
+			// omitted entirely, in which case it defaults to identity.
+			var id *Tag
+			components{
+				"X":           &v.Position.X,
+				"Y":           &v.Position.Y,
+				"Z":           &v.Position.Z,
+				"Orientation": &id,
+			}.getFrom(tag)
+			v.Rotation = identityMatrix
+			if id != nil {
+				n, err := strconv.ParseUint(getContent(id), 10, 8)
+				rotation, ok := orientationToMatrix(uint8(n))
+				if err != nil || !ok {
+					dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("invalid orientation `%s`", getContent(id)))
+				} else {
+					v.Rotation = rotation
+				}
+			}
+			return v, true
+		}
 		components{
 			"X":   &v.Position.X,
 			"Y":   &v.Position.Y,
@@ -402,6 +1214,10 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 			case "null":
 				//DIFF: If null tag has content, then `tag expected` error is
 				//thrown.
+				if dec.codec.StrictContent && subtag.StartName == "null" && (len(getContent(subtag)) > 0 || len(subtag.Tags) > 0) {
+					dec.document.Warnings = append(dec.document.Warnings, errors.New("null tag of Content has content"))
+					return nil, false
+				}
 				return rbxfile.ValueContent{}, true
 			case "url":
 				return rbxfile.ValueContent(getContent(subtag)), true
@@ -447,12 +1263,19 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 		return rbxfile.ValueFloat(v), true
 
 	case "int":
-		v, err := strconv.ParseInt(getContent(tag), 10, 32)
-		if err != nil {
+		v, ok := dec.parseInt(tag, 32)
+		if !ok {
 			return nil, false
 		}
 		return rbxfile.ValueInt(v), true
 
+	case "int64":
+		v, ok := dec.parseInt(tag, 64)
+		if !ok {
+			return nil, false
+		}
+		return rbxfile.ValueInt64(v), true
+
 	case "ProtectedString":
 		return rbxfile.ValueProtectedString(getContent(tag)), true
 
@@ -488,24 +1311,23 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 		return rbxfile.ValueString(getContent(tag)), true
 
 	case "token":
-		v, err := strconv.ParseInt(getContent(tag), 10, 32)
+		content := getContent(tag)
+		v, err := strconv.ParseInt(content, 10, 32)
 		if err != nil {
-			return nil, false
-		}
-		if enum != nil {
-			// Verify that value is a valid enum item
+			// Some exporters write the name of the enum item instead.
+			if enum == nil {
+				dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("token `%s` is not a number, and has no enum to resolve a name", content))
+				return nil, false
+			}
 			for _, item := range enum.Items {
-				if int(v) == item.Value {
-					return rbxfile.ValueToken(v), true
+				if item.Name == content {
+					return rbxfile.ValueToken(item.Value), true
 				}
 			}
-			if dec.codec.ExcludeInvalidAPI {
-				dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("invalid item `%d` for enum %s", v, enum.Name))
-				return nil, false
-			}
+			dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("invalid item `%s` for enum %s", content, enum.Name))
+			return nil, false
 		}
-		// Assume that it is correct
-		return rbxfile.ValueToken(v), true
+		return dec.checkToken(rbxfile.ValueToken(v), enum)
 
 	case "UDim":
 		// Unknown
@@ -523,7 +1345,11 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 		return v, true
 
 	case "Vector2":
-		// DIFF: If any component tags are missing, entire value fails
+		// DIFF: Missing component tags are zero, unless StrictVectors is
+		// set, in which case the entire value fails
+		if !dec.checkComponents(tag, valueType, "X", "Y") {
+			return nil, false
+		}
 		v := *new(rbxfile.ValueVector2)
 		components{
 			"X": &v.X,
@@ -534,13 +1360,18 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 	case "Vector2int16":
 		// Unknown; guessed
 		v := *new(rbxfile.ValueVector2int16)
-		components{
+		ok := dec.getInt16Components(tag, valueType, map[string]*int16{
 			"X": &v.X,
 			"Y": &v.Y,
-		}.getFrom(tag)
-		return v, true
+		})
+		return v, ok
 
 	case "Vector3":
+		// DIFF: Missing component tags are zero, unless StrictVectors is
+		// set, in which case the entire value fails
+		if !dec.checkComponents(tag, valueType, "X", "Y", "Z") {
+			return nil, false
+		}
 		v := *new(rbxfile.ValueVector3)
 		components{
 			"X": &v.X,
@@ -552,12 +1383,12 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 	case "Vector3int16":
 		// Unknown; guessed
 		v := *new(rbxfile.ValueVector3int16)
-		components{
+		ok := dec.getInt16Components(tag, valueType, map[string]*int16{
 			"X": &v.X,
 			"Y": &v.Y,
 			"Z": &v.Z,
-		}.getFrom(tag)
-		return v, true
+		})
+		return v, ok
 
 	case "NumberSequence":
 		b := []byte(getContent(tag))
@@ -634,8 +1465,11 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 			"FrictionWeight":   &v.FrictionWeight,
 			"ElasticityWeight": &v.ElasticityWeight,
 		}.getFrom(tag)
-		vb, _ := dec.getValue(cp, "bool", enum)
-		v.CustomPhysics = bool(vb.(rbxfile.ValueBool))
+		if cp != nil {
+			if vb, ok := dec.getValue(cp, "bool", enum); ok {
+				v.CustomPhysics = bool(vb.(rbxfile.ValueBool))
+			}
+		}
 		return v, true
 
 	case "Color3uint8":
@@ -648,6 +1482,62 @@ func (dec *rdecoder) getValue(tag *Tag, valueType string, enum *rbxapi.Enum) (va
 			G: byte(v & 0x0000FF00 >> 8),
 			B: byte(v & 0x000000FF),
 		}, true
+
+	case "Font":
+		var family, style, cachedFaceId *Tag
+		var weight int32
+		components{
+			"Family":       &family,
+			"Weight":       &weight,
+			"Style":        &style,
+			"CachedFaceId": &cachedFaceId,
+		}.getFrom(tag)
+
+		v := *new(rbxfile.ValueFont)
+		if family != nil {
+			if f, ok := dec.getValue(family, "Content", nil); ok {
+				v.Family = f.(rbxfile.ValueContent)
+			}
+		}
+		v.Weight = uint16(weight)
+		if style != nil {
+			switch getContent(style) {
+			case "Normal":
+				v.Style = rbxfile.FontStyleNormal
+			case "Italic":
+				v.Style = rbxfile.FontStyleItalic
+			}
+		}
+		if cachedFaceId != nil {
+			if c, ok := dec.getValue(cachedFaceId, "Content", nil); ok {
+				v.CachedFaceId = c.(rbxfile.ValueContent)
+			}
+		}
+		return v, true
+
+	case "UniqueId":
+		b, err := hex.DecodeString(getContent(tag))
+		if err != nil || len(b) != 16 {
+			return nil, false
+		}
+		return rbxfile.ValueUniqueId{
+			Random: int64(binary.BigEndian.Uint64(b[0:8])),
+			Time:   binary.BigEndian.Uint32(b[8:12]),
+			Index:  binary.BigEndian.Uint32(b[12:16]),
+		}, true
+
+	case "SharedString":
+		key := getContent(tag)
+		v, ok := dec.sharedStrings[key]
+		if !ok {
+			dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("undefined shared string `%s`", key))
+			if dec.missingSharedStrings == nil {
+				dec.missingSharedStrings = make(map[string]bool)
+			}
+			dec.missingSharedStrings[key] = true
+			return nil, false
+		}
+		return v.Copy(), true
 	}
 
 	return nil, false
@@ -675,6 +1565,164 @@ func scanFloat(b []byte, i int) (float32, int) {
 	return 0, -1
 }
 
+// hasQuaternion returns whether a CoordinateFrame tag has its rotation
+// written as a quaternion, rather than as a matrix.
+func hasQuaternion(tag *Tag) bool {
+	for _, subtag := range tag.Tags {
+		switch subtag.StartName {
+		case "QX", "QY", "QZ", "QW":
+			return true
+		}
+	}
+	return false
+}
+
+// hasRotationMatrix returns whether a CoordinateFrame tag has any component
+// of its rotation written as a matrix.
+func hasRotationMatrix(tag *Tag) bool {
+	for _, subtag := range tag.Tags {
+		switch subtag.StartName {
+		case "R00", "R01", "R02", "R10", "R11", "R12", "R20", "R21", "R22":
+			return true
+		}
+	}
+	return false
+}
+
+// getLegacyMatrix returns the Matrix tag of a CoordinateFrame tag, which
+// contains the rotation in very old files. Returns nil if the tag has no
+// Matrix tag, or if the rotation is also written as flat components.
+func getLegacyMatrix(tag *Tag) *Tag {
+	if hasRotationMatrix(tag) {
+		return nil
+	}
+	for _, subtag := range tag.Tags {
+		if subtag.StartName == "Matrix" {
+			return subtag
+		}
+	}
+	return nil
+}
+
+// identityMatrix is a row-major 3x3 identity matrix.
+var identityMatrix = [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}
+
+// normalVectors maps each NormalId to a unit vector.
+var normalVectors = [6][3]float32{
+	{1, 0, 0},  // Right
+	{0, 1, 0},  // Top
+	{0, 0, 1},  // Back
+	{-1, 0, 0}, // Left
+	{0, -1, 0}, // Bottom
+	{0, 0, -1}, // Front
+}
+
+// orientationToMatrix converts an orientation ID to a row-major 3x3 rotation
+// matrix. An orientation ID describes an axis-aligned rotation, in the same
+// manner as the binary format: the ID minus 1 is 6*X + Y, where X and Y are
+// the NormalIds of the right and up vectors. Returns false if the ID does not
+// describe a valid rotation.
+func orientationToMatrix(id uint8) (m [9]float32, ok bool) {
+	if id < 1 || id > 36 {
+		return m, false
+	}
+	x := normalVectors[(id-1)/6]
+	y := normalVectors[(id-1)%6]
+	if x[0]*y[0]+x[1]*y[1]+x[2]*y[2] != 0 {
+		// Vectors are not perpendicular.
+		return m, false
+	}
+	z := [3]float32{
+		x[1]*y[2] - x[2]*y[1],
+		x[2]*y[0] - x[0]*y[2],
+		x[0]*y[1] - x[1]*y[0],
+	}
+	for i := 0; i < 3; i++ {
+		m[i*3+0] = x[i]
+		m[i*3+1] = y[i]
+		m[i*3+2] = z[i]
+	}
+	return m, true
+}
+
+// quaternionToMatrix converts a quaternion to a row-major 3x3 rotation
+// matrix. The quaternion is normalized first. A quaternion of length 0
+// results in the identity matrix.
+func quaternionToMatrix(qx, qy, qz, qw float32) [9]float32 {
+	x, y, z, w := float64(qx), float64(qy), float64(qz), float64(qw)
+	n := math.Sqrt(x*x + y*y + z*z + w*w)
+	if n == 0 {
+		return identityMatrix
+	}
+	x, y, z, w = x/n, y/n, z/n, w/n
+	return [9]float32{
+		float32(1 - 2*(y*y+z*z)), float32(2 * (x*y - z*w)), float32(2 * (x*z + y*w)),
+		float32(2 * (x*y + z*w)), float32(1 - 2*(x*x+z*z)), float32(2 * (y*z - x*w)),
+		float32(2 * (x*z - y*w)), float32(2 * (y*z + x*w)), float32(1 - 2*(x*x+y*y)),
+	}
+}
+
+// checkComponents returns whether tag has a subtag for each of the given
+// component names. If StrictVectors is false, true is always returned.
+// Otherwise, a warning is emitted for missing components.
+func (dec *rdecoder) checkComponents(tag *Tag, valueType string, names ...string) bool {
+	if !dec.codec.StrictVectors {
+		return true
+	}
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, subtag := range tag.Tags {
+			if subtag.StartName == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("%s is missing components %s", valueType, strings.Join(missing, ", ")))
+	return false
+}
+
+// getInt16Components reads int16 components from the subtags of tag, like
+// components.getFrom. A component that is out of range of an int16 emits a
+// warning, and is handled according to the Int16Overflow policy of the codec.
+// Returns false if the value is to be excluded.
+func (dec *rdecoder) getInt16Components(tag *Tag, valueType string, c map[string]*int16) bool {
+	// Used to ensure that only the first matched tag is selected.
+	d := map[string]bool{}
+
+	for _, subtag := range tag.Tags {
+		v, ok := c[subtag.StartName]
+		if !ok || d[subtag.StartName] {
+			continue
+		}
+		d[subtag.StartName] = true
+		n, err := strconv.ParseInt(getContent(subtag), 10, 16)
+		if err == nil {
+			*v = int16(n)
+			continue
+		}
+		if err, ok := err.(*strconv.NumError); !ok || err.Err != strconv.ErrRange {
+			continue
+		}
+		dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("%s component %s `%s` overflows int16", valueType, subtag.StartName, getContent(subtag)))
+		switch dec.codec.Int16Overflow {
+		case OverflowClamp:
+			// ParseInt returns the nearest value in range.
+			*v = int16(n)
+		case OverflowExclude:
+			return false
+		}
+	}
+	return true
+}
+
 type components map[string]interface{}
 
 func (c components) getFrom(tag *Tag) {
@@ -708,6 +1756,24 @@ func (c components) getFrom(tag *Tag) {
 	}
 }
 
+// checkToken validates v against enum, if enum is not nil. An invalid enum
+// item is warned about, and is then dropped only if invalid items are
+// excluded. Otherwise, it is assumed to be correct.
+func (dec *rdecoder) checkToken(v rbxfile.ValueToken, enum *rbxapi.Enum) (value rbxfile.Value, ok bool) {
+	if enum != nil && !rbxfile.ValidateToken(dec.codec.API, enum.Name, v) {
+		dec.document.Warnings = append(dec.document.Warnings, fmt.Errorf("invalid item `%d` for enum %s", v, enum.Name))
+		if dec.codec.ExcludeInvalidAPI {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// isEmptyTag returns whether tag has no content and no subtags.
+func isEmptyTag(tag *Tag) bool {
+	return len(tag.CData) == 0 && len(tag.Text) == 0 && len(tag.Tags) == 0
+}
+
 // Reads either the CData or the text of a tag.
 func getContent(tag *Tag) string {
 	if tag.CData != nil {
@@ -723,6 +1789,126 @@ type rencoder struct {
 	document *Document
 	refs     rbxfile.References
 	err      error
+
+	// sharedStrings maps the key of each encoded shared string to its
+	// value. sharedKeys contains each key, in the order they were encoded.
+	sharedStrings map[string]rbxfile.ValueSharedString
+	sharedKeys    []string
+
+	// canonRefs maps each instance to its canonical referent, and
+	// canonUsed contains each canonical referent, when CanonicalReferents
+	// is set.
+	canonRefs map[*rbxfile.Instance]string
+	canonUsed map[string]bool
+
+	// lazyRefs maps the Reference of each instance in the tree to the
+	// instance, for encoding references that have only a referent string.
+	lazyRefs rbxfile.References
+
+	// filtered maps each instance to the result of InstanceFilter, and
+	// excluded contains each instance that is not written to the output,
+	// when InstanceFilter is set.
+	filtered map[*rbxfile.Instance]instanceFilter
+	excluded map[*rbxfile.Instance]bool
+}
+
+// instanceFilter holds the result of RobloxCodec.InstanceFilter for an
+// instance.
+type instanceFilter struct {
+	skip, skipChildren bool
+}
+
+// filterInstances calls InstanceFilter for each instance in the tree, so that
+// references to excluded instances are known before any are encoded.
+func (enc *rencoder) filterInstances() {
+	enc.filtered = make(map[*rbxfile.Instance]instanceFilter)
+	enc.excluded = make(map[*rbxfile.Instance]bool)
+	var walk func(instances []*rbxfile.Instance, excluded bool)
+	walk = func(instances []*rbxfile.Instance, excluded bool) {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if excluded {
+				enc.excluded[inst] = true
+				walk(inst.Children, true)
+				continue
+			}
+			var f instanceFilter
+			f.skip, f.skipChildren = enc.codec.InstanceFilter(inst)
+			enc.filtered[inst] = f
+			if f.skip {
+				enc.excluded[inst] = true
+			}
+			walk(inst.Children, f.skipChildren)
+		}
+	}
+	walk(enc.root.Instances, false)
+}
+
+// referent returns the referent to be encoded for inst.
+func (enc *rencoder) referent(inst *rbxfile.Instance) string {
+	prefix := enc.codec.ReferentPrefix
+	if prefix == "" {
+		prefix = "RBX"
+	}
+	ref := enc.refs.GetWithPrefix(inst, prefix)
+	if !enc.codec.CanonicalReferents {
+		return ref
+	}
+	if canon, ok := enc.canonRefs[inst]; ok {
+		return canon
+	}
+	if enc.canonRefs == nil {
+		enc.canonRefs = make(map[*rbxfile.Instance]string)
+		enc.canonUsed = make(map[string]bool)
+	}
+	canon := rbxfile.CanonicalizeReferent(ref)
+	for !isCanonicalReferent(canon) || enc.canonUsed[canon] {
+		canon = rbxfile.GenerateReference()
+	}
+	enc.canonRefs[inst] = canon
+	enc.canonUsed[canon] = true
+	return canon
+}
+
+// lazyInstance returns the instance within the tree whose Reference is ref,
+// for encoding a reference that has only a referent string. The referent of
+// that instance is encoded in place of ref, so that the reference follows any
+// rewriting of referents. Returns nil if there is no such instance, in which
+// case ref is encoded unchanged.
+func (enc *rencoder) lazyInstance(ref string) *rbxfile.Instance {
+	if enc.lazyRefs == nil && enc.root != nil {
+		enc.lazyRefs = make(rbxfile.References)
+		var walk func(instances []*rbxfile.Instance)
+		walk = func(instances []*rbxfile.Instance) {
+			for _, inst := range instances {
+				if inst == nil {
+					continue
+				}
+				if !rbxfile.IsEmptyReference(inst.Reference) && enc.lazyRefs[inst.Reference] == nil {
+					enc.lazyRefs[inst.Reference] = inst
+				}
+				walk(inst.Children)
+			}
+		}
+		walk(enc.root.Instances)
+	}
+	return enc.lazyRefs[ref]
+}
+
+// isCanonicalReferent returns whether ref is in the form produced by
+// rbxfile.GenerateReference.
+func isCanonicalReferent(ref string) bool {
+	if len(ref) != 35 || ref[:3] != "RBX" {
+		return false
+	}
+	for _, c := range ref[3:] {
+		if !('0' <= c && c <= '9' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
 }
 
 func (c RobloxCodec) Encode(root *rbxfile.Root) (document *Document, err error) {
@@ -739,6 +1925,7 @@ func (c RobloxCodec) Encode(root *rbxfile.Root) (document *Document, err error)
 
 func (enc *rencoder) encode() {
 	enc.document = &Document{
+		Prolog: enc.codec.Prolog,
 		Prefix: "",
 		Indent: "\t",
 		Suffix: "",
@@ -751,13 +1938,50 @@ func (enc *rencoder) encode() {
 		}
 	}
 
+	if enc.document.Prolog == "" {
+		for _, pi := range enc.root.ProcessingInstructions {
+			enc.document.Prolog += "<?" + pi + "?>\n"
+		}
+	}
+	enc.document.Root.EndComments = append([]string(nil), enc.root.EndComments...)
+
+	if enc.codec.InstanceFilter != nil {
+		enc.filterInstances()
+	}
+	enc.sharedStrings = make(map[string]rbxfile.ValueSharedString)
 	for _, instance := range enc.root.Instances {
 		enc.encodeInstance(instance, enc.document.Root)
 	}
 
+	if len(enc.sharedKeys) > 0 {
+		sharedStrings := &Tag{StartName: "SharedStrings"}
+		for _, key := range enc.sharedKeys {
+			sharedStrings.Tags = append(sharedStrings.Tags, &Tag{
+				StartName: "SharedString",
+				Attr:      []Attr{Attr{Name: "md5", Value: key}},
+				NoIndent:  true,
+				Text:      base64.StdEncoding.EncodeToString(enc.sharedStrings[key]),
+			})
+		}
+		enc.document.Root.Tags = append(enc.document.Root.Tags, sharedStrings)
+	}
+
 }
 
 func (enc *rencoder) encodeInstance(instance *rbxfile.Instance, parent *Tag) {
+	children := instance.Children
+	if f, ok := enc.filtered[instance]; ok {
+		if f.skipChildren {
+			children = nil
+		}
+		if f.skip {
+			for _, child := range children {
+				enc.encodeInstance(child, parent)
+			}
+			return
+		}
+	}
+
 	if enc.codec.API != nil {
 		if _, ok := enc.codec.API.Classes[instance.ClassName]; !ok {
 			enc.document.Warnings = append(enc.document.Warnings, fmt.Errorf("invalid class `%s`", instance.ClassName))
@@ -767,15 +1991,17 @@ func (enc *rencoder) encodeInstance(instance *rbxfile.Instance, parent *Tag) {
 		}
 	}
 
-	ref := enc.refs.Get(instance)
+	ref := enc.referent(instance)
 	properties := enc.encodeProperties(instance)
 	item := NewItem(instance.ClassName, ref, properties...)
 	if enc.codec.ExcludeReferent {
 		item.SetAttrValue("referent", "")
 	}
+	item.Comments = append([]string(nil), instance.Comments...)
+	item.EndComments = append([]string(nil), instance.EndComments...)
 	parent.Tags = append(parent.Tags, item)
 
-	for _, child := range instance.Children {
+	for _, child := range children {
 		enc.encodeInstance(child, item)
 	}
 }
@@ -804,10 +2030,19 @@ func (enc *rencoder) encodeProperties(instance *rbxfile.Instance) (properties []
 	for name := range instance.Properties {
 		sorted = append(sorted, name)
 	}
-	sort.Strings(sorted)
+	if enc.codec.CaseInsensitiveOrder {
+		sort.Slice(sorted, func(i, j int) bool {
+			return lessFold(sorted[i], sorted[j])
+		})
+	} else {
+		sort.Strings(sorted)
+	}
 
 	for _, name := range sorted {
 		value := instance.Properties[name]
+		if enc.codec.PropertyFilter != nil && !enc.codec.PropertyFilter(instance.ClassName, name, value) {
+			continue
+		}
 		if apiMembers != nil {
 			apiMember, ok := apiMembers[name]
 			if ok {
@@ -821,21 +2056,13 @@ func (enc *rencoder) encodeProperties(instance *rbxfile.Instance) (properties []
 					if enc.codec.ExcludeInvalidAPI {
 						continue
 					}
-				} else if istoken && enum != nil {
-					for _, item := range enum.Items {
-						if int(token) == item.Value {
-							goto finishToken
-						}
-					}
-
+				} else if istoken && enum != nil && !rbxfile.ValidateToken(enc.codec.API, typ, token) {
 					enc.document.Warnings = append(enc.document.Warnings,
 						fmt.Errorf("invalid enum value `%d` for property %s.%s (%s)", uint32(token), instance.ClassName, name, enum.Name),
 					)
 					if enc.codec.ExcludeInvalidAPI {
 						continue
 					}
-
-				finishToken:
 				}
 			} else {
 				enc.document.Warnings = append(enc.document.Warnings, fmt.Errorf("invalid property %s.`%s`", instance.ClassName, name))
@@ -857,6 +2084,17 @@ func (enc *rencoder) encodeProperties(instance *rbxfile.Instance) (properties []
 func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Tag {
 	attr := []Attr{Attr{Name: "name", Value: prop}}
 	switch value := value.(type) {
+	case rbxfile.ValueRaw:
+		tag, err := rawTag(value)
+		if err != nil {
+			if enc.document != nil {
+				enc.document.Warnings = append(enc.document.Warnings, fmt.Errorf("property %s.`%s` has invalid raw value: %s", class, prop, err))
+			}
+			return nil
+		}
+		tag.SetAttrValue("name", prop)
+		return tag
+
 	case rbxfile.ValueAxes:
 		var n uint64
 		for i, b := range []bool{value.X, value.Y, value.Z} {
@@ -878,7 +2116,10 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 
 	case rbxfile.ValueBinaryString:
 		buf := new(bytes.Buffer)
-		sw := &lineSplit{w: buf, s: 72, n: 72}
+		var sw io.Writer = buf
+		if width := enc.base64LineWidth(); width > 0 {
+			sw = &lineSplit{w: buf, s: width, n: width}
+		}
 		bw := base64.NewEncoder(base64.StdEncoding, sw)
 		bw.Write([]byte(value))
 		bw.Close()
@@ -905,8 +2146,12 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 		}
 
 	case rbxfile.ValueBrickColor:
+		name := "int"
+		if enc.codec.BrickColorTag {
+			name = "BrickColor"
+		}
 		return &Tag{
-			StartName: "int",
+			StartName: name,
 			Attr:      attr,
 			NoIndent:  true,
 			Text:      strconv.FormatUint(uint64(value), 10),
@@ -917,18 +2162,18 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			StartName: "CoordinateFrame",
 			Attr:      attr,
 			Tags: []*Tag{
-				&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.Position.X)},
-				&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Position.Y)},
-				&Tag{StartName: "Z", NoIndent: true, Text: encodeFloat(value.Position.Z)},
-				&Tag{StartName: "R00", NoIndent: true, Text: encodeFloat(value.Rotation[0])},
-				&Tag{StartName: "R01", NoIndent: true, Text: encodeFloat(value.Rotation[1])},
-				&Tag{StartName: "R02", NoIndent: true, Text: encodeFloat(value.Rotation[2])},
-				&Tag{StartName: "R10", NoIndent: true, Text: encodeFloat(value.Rotation[3])},
-				&Tag{StartName: "R11", NoIndent: true, Text: encodeFloat(value.Rotation[4])},
-				&Tag{StartName: "R12", NoIndent: true, Text: encodeFloat(value.Rotation[5])},
-				&Tag{StartName: "R20", NoIndent: true, Text: encodeFloat(value.Rotation[6])},
-				&Tag{StartName: "R21", NoIndent: true, Text: encodeFloat(value.Rotation[7])},
-				&Tag{StartName: "R22", NoIndent: true, Text: encodeFloat(value.Rotation[8])},
+				&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.Position.X)},
+				&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Position.Y)},
+				&Tag{StartName: "Z", NoIndent: true, Text: enc.encodeFloat(value.Position.Z)},
+				&Tag{StartName: "R00", NoIndent: true, Text: enc.encodeFloat(value.Rotation[0])},
+				&Tag{StartName: "R01", NoIndent: true, Text: enc.encodeFloat(value.Rotation[1])},
+				&Tag{StartName: "R02", NoIndent: true, Text: enc.encodeFloat(value.Rotation[2])},
+				&Tag{StartName: "R10", NoIndent: true, Text: enc.encodeFloat(value.Rotation[3])},
+				&Tag{StartName: "R11", NoIndent: true, Text: enc.encodeFloat(value.Rotation[4])},
+				&Tag{StartName: "R12", NoIndent: true, Text: enc.encodeFloat(value.Rotation[5])},
+				&Tag{StartName: "R20", NoIndent: true, Text: enc.encodeFloat(value.Rotation[6])},
+				&Tag{StartName: "R21", NoIndent: true, Text: enc.encodeFloat(value.Rotation[7])},
+				&Tag{StartName: "R22", NoIndent: true, Text: enc.encodeFloat(value.Rotation[8])},
 			},
 		}
 
@@ -968,7 +2213,7 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			StartName: "double",
 			Attr:      attr,
 			NoIndent:  true,
-			Text:      encodeDouble(float64(value)),
+			Text:      enc.encodeDouble(float64(value)),
 		}
 
 	case rbxfile.ValueFaces:
@@ -995,7 +2240,7 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			StartName: "float",
 			Attr:      attr,
 			NoIndent:  true,
-			Text:      encodeFloat(float32(value)),
+			Text:      enc.encodeFloat(float32(value)),
 		}
 
 	case rbxfile.ValueInt:
@@ -1006,6 +2251,14 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			Text:      strconv.FormatInt(int64(value), 10),
 		}
 
+	case rbxfile.ValueInt64:
+		return &Tag{
+			StartName: "int64",
+			Attr:      attr,
+			NoIndent:  true,
+			Text:      strconv.FormatInt(int64(value), 10),
+		}
+
 	case rbxfile.ValueProtectedString:
 		tag := &Tag{
 			StartName: "ProtectedString",
@@ -1023,17 +2276,17 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 				&Tag{
 					StartName: "origin",
 					Tags: []*Tag{
-						&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.Origin.X)},
-						&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Origin.Y)},
-						&Tag{StartName: "Z", NoIndent: true, Text: encodeFloat(value.Origin.Z)},
+						&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.Origin.X)},
+						&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Origin.Y)},
+						&Tag{StartName: "Z", NoIndent: true, Text: enc.encodeFloat(value.Origin.Z)},
 					},
 				},
 				&Tag{
 					StartName: "direction",
 					Tags: []*Tag{
-						&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.Origin.X)},
-						&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Origin.Y)},
-						&Tag{StartName: "Z", NoIndent: true, Text: encodeFloat(value.Origin.Z)},
+						&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.Origin.X)},
+						&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Origin.Y)},
+						&Tag{StartName: "Z", NoIndent: true, Text: enc.encodeFloat(value.Origin.Z)},
 					},
 				},
 			},
@@ -1047,8 +2300,21 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 		}
 
 		referent := value.Instance
+		if referent == nil && value.Reference != "" {
+			referent = enc.lazyInstance(value.Reference)
+		}
+		if enc.excluded[referent] {
+			// The referent is not written, so it cannot be referred to.
+			if enc.document != nil {
+				enc.document.Warnings = append(enc.document.Warnings, fmt.Errorf("property %s.`%s` refers to an instance excluded by InstanceFilter; encoded as null", class, prop))
+			}
+			referent = nil
+			value.Reference = ""
+		}
 		if referent != nil {
-			tag.Text = enc.refs.Get(referent)
+			tag.Text = enc.referent(referent)
+		} else if value.Reference != "" {
+			tag.Text = value.Reference
 		} else {
 			tag.Text = "null"
 		}
@@ -1078,9 +2344,9 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			StartName: "UDim2",
 			Attr:      attr,
 			Tags: []*Tag{
-				&Tag{StartName: "XS", NoIndent: true, Text: encodeFloat(value.X.Scale)},
+				&Tag{StartName: "XS", NoIndent: true, Text: enc.encodeFloat(value.X.Scale)},
 				&Tag{StartName: "XO", NoIndent: true, Text: strconv.FormatInt(int64(value.X.Offset), 10)},
-				&Tag{StartName: "YS", NoIndent: true, Text: encodeFloat(value.Y.Scale)},
+				&Tag{StartName: "YS", NoIndent: true, Text: enc.encodeFloat(value.Y.Scale)},
 				&Tag{StartName: "YO", NoIndent: true, Text: strconv.FormatInt(int64(value.Y.Offset), 10)},
 			},
 		}
@@ -1090,8 +2356,8 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			StartName: "Vector2",
 			Attr:      attr,
 			Tags: []*Tag{
-				&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.X)},
-				&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Y)},
+				&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.X)},
+				&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Y)},
 			},
 		}
 
@@ -1110,9 +2376,9 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			StartName: "Vector3",
 			Attr:      attr,
 			Tags: []*Tag{
-				&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.X)},
-				&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Y)},
-				&Tag{StartName: "Z", NoIndent: true, Text: encodeFloat(value.Z)},
+				&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.X)},
+				&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Y)},
+				&Tag{StartName: "Z", NoIndent: true, Text: enc.encodeFloat(value.Z)},
 			},
 		}
 
@@ -1183,15 +2449,15 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 				&Tag{
 					StartName: "min",
 					Tags: []*Tag{
-						&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.Min.X)},
-						&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Min.Y)},
+						&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.Min.X)},
+						&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Min.Y)},
 					},
 				},
 				&Tag{
 					StartName: "max",
 					Tags: []*Tag{
-						&Tag{StartName: "X", NoIndent: true, Text: encodeFloat(value.Max.X)},
-						&Tag{StartName: "Y", NoIndent: true, Text: encodeFloat(value.Max.Y)},
+						&Tag{StartName: "X", NoIndent: true, Text: enc.encodeFloat(value.Max.X)},
+						&Tag{StartName: "Y", NoIndent: true, Text: enc.encodeFloat(value.Max.Y)},
 					},
 				},
 			},
@@ -1204,11 +2470,11 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 				Attr:      attr,
 				Tags: []*Tag{
 					&Tag{StartName: "CustomPhysics", Text: "true"},
-					&Tag{StartName: "Density", Text: encodeFloat(value.Density)},
-					&Tag{StartName: "Friction", Text: encodeFloat(value.Friction)},
-					&Tag{StartName: "Elasticity", Text: encodeFloat(value.Elasticity)},
-					&Tag{StartName: "FrictionWeight", Text: encodeFloat(value.FrictionWeight)},
-					&Tag{StartName: "ElasticityWeight", Text: encodeFloat(value.ElasticityWeight)},
+					&Tag{StartName: "Density", Text: enc.encodeFloat(value.Density)},
+					&Tag{StartName: "Friction", Text: enc.encodeFloat(value.Friction)},
+					&Tag{StartName: "Elasticity", Text: enc.encodeFloat(value.Elasticity)},
+					&Tag{StartName: "FrictionWeight", Text: enc.encodeFloat(value.FrictionWeight)},
+					&Tag{StartName: "ElasticityWeight", Text: enc.encodeFloat(value.ElasticityWeight)},
 				},
 			}
 		} else {
@@ -1231,11 +2497,74 @@ func (enc *rencoder) encodeProperty(class, prop string, value rbxfile.Value) *Ta
 			NoIndent:  true,
 			Text:      strconv.FormatUint(0xFF<<24|r<<16|g<<8|b, 10),
 		}
+
+	case rbxfile.ValueFont:
+		family := enc.encodeProperty(class, prop, value.Family)
+		family.StartName = "Family"
+		family.Attr = nil
+		cachedFaceId := enc.encodeProperty(class, prop, value.CachedFaceId)
+		cachedFaceId.StartName = "CachedFaceId"
+		cachedFaceId.Attr = nil
+		return &Tag{
+			StartName: "Font",
+			Attr:      attr,
+			Tags: []*Tag{
+				family,
+				&Tag{StartName: "Weight", NoIndent: true, Text: strconv.FormatUint(uint64(value.Weight), 10)},
+				&Tag{StartName: "Style", NoIndent: true, Text: value.Style.String()},
+				cachedFaceId,
+			},
+		}
+
+	case rbxfile.ValueUniqueId:
+		return &Tag{
+			StartName: "UniqueId",
+			Attr:      attr,
+			NoIndent:  true,
+			Text:      value.String(),
+		}
+
+	case rbxfile.ValueSharedString:
+		sum := rbxfile.SharedStringHash(value)
+		key := base64.StdEncoding.EncodeToString(sum[:])
+		if enc.sharedStrings != nil {
+			if _, ok := enc.sharedStrings[key]; !ok {
+				enc.sharedStrings[key] = value
+				enc.sharedKeys = append(enc.sharedKeys, key)
+			}
+		}
+		return &Tag{
+			StartName: "SharedString",
+			Attr:      attr,
+			NoIndent:  true,
+			Text:      key,
+		}
 	}
 
 	return nil
 }
 
+// lessFold returns whether a sorts before b without regard to case. Names
+// that differ only by case are compared case-sensitively.
+func lessFold(a, b string) bool {
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+// base64LineWidth returns the line width of base64-encoded data, or -1 if
+// lines are not wrapped.
+func (enc *rencoder) base64LineWidth() int {
+	switch {
+	case enc.codec.Base64LineWidth == 0:
+		return DefaultBase64LineWidth
+	case enc.codec.Base64LineWidth < 0:
+		return -1
+	}
+	return enc.codec.Base64LineWidth
+}
+
 type lineSplit struct {
 	w io.Writer
 	s int
@@ -1270,8 +2599,17 @@ func (l *lineSplit) Write(p []byte) (n int, err error) {
 	return
 }
 
-func encodeFloat(f float32) string {
-	return fixFloatExp(strconv.FormatFloat(float64(f), 'g', 9, 32), 3)
+// floatPrecision returns the precision used to format float and double
+// values.
+func (enc *rencoder) floatPrecision() int {
+	if enc.codec.ShortestFloats {
+		return -1
+	}
+	return 9
+}
+
+func (enc *rencoder) encodeFloat(f float32) string {
+	return fixFloatExp(strconv.FormatFloat(float64(f), 'g', enc.floatPrecision(), 32), 3)
 }
 
 func encodeFloatPrec(f float32, prec int) string {
@@ -1289,8 +2627,8 @@ func fixFloatExp(s string, n int) string {
 	return s
 }
 
-func encodeDouble(f float64) string {
-	return strconv.FormatFloat(f, 'g', 9, 64)
+func (enc *rencoder) encodeDouble(f float64) string {
+	return strconv.FormatFloat(f, 'g', enc.floatPrecision(), 64)
 }
 
 func encodeContent(tag *Tag, text string) {
@@ -1325,6 +2663,8 @@ func isCanonType(t string, v rbxfile.Value) bool {
 		return t == "float"
 	case rbxfile.ValueInt:
 		return t == "int"
+	case rbxfile.ValueInt64:
+		return t == "int64"
 	case rbxfile.ValueProtectedString:
 		return t == "ProtectedString"
 	case rbxfile.ValueRay:
@@ -1357,6 +2697,12 @@ func isCanonType(t string, v rbxfile.Value) bool {
 		return t == "PhysicalProperties"
 	case rbxfile.ValueColor3uint8:
 		return t == "Color3uint8"
+	case rbxfile.ValueFont:
+		return t == "Font"
+	case rbxfile.ValueUniqueId:
+		return t == "UniqueId"
+	case rbxfile.ValueSharedString:
+		return t == "SharedString"
 	}
 	return false
 }
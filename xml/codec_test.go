@@ -0,0 +1,2263 @@
+package xml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/robloxapi/rbxapi"
+	"github.com/robloxapi/rbxfile"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func decodeString(t *testing.T, codec RobloxCodec, s string) *rbxfile.Root {
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(s)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := codec.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	return root
+}
+
+func TestRobloxCodec_DecodeExternal(t *testing.T) {
+	const document = `<roblox version="4">
+	<External>none</External>
+	<Item class="ObjectValue" referent="RBX0">
+		<Properties>
+			<Ref name="Value">none</Ref>
+		</Properties>
+	</Item>
+	<Item class="ObjectValue" referent="null">
+		<Properties>
+			<Ref name="Value">null</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	root := decodeString(t, RobloxCodec{}, document)
+	if len(root.Instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(root.Instances))
+	}
+	a, b := root.Instances[0], root.Instances[1]
+
+	if v, ok := a.Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != nil {
+		t.Errorf("expected external reference to be empty, got %#v", a.Properties["Value"])
+	}
+	// "null" is not declared as external, so it is an ordinary referent.
+	if v, ok := b.Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != b {
+		t.Errorf("expected undeclared reference to resolve to referent, got %#v", b.Properties["Value"])
+	}
+}
+
+func TestRobloxCodec_LazyReferences(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="ObjectValue" referent="RBX0">
+		<Properties>
+			<Ref name="Value">RBX1</Ref>
+		</Properties>
+	</Item>
+	<Item class="ObjectValue" referent="RBX1">
+		<Properties>
+			<Ref name="Value">null</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	codec := RobloxCodec{LazyReferences: true}
+	root := decodeString(t, codec, document)
+	if len(root.Instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(root.Instances))
+	}
+	a, b := root.Instances[0], root.Instances[1]
+	if v, ok := a.Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != nil || v.Reference != "RBX1" {
+		t.Errorf("expected unresolved reference, got %#v", a.Properties["Value"])
+	}
+	if v, ok := b.Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != nil || v.Reference != "" {
+		t.Errorf("expected empty reference, got %#v", b.Properties["Value"])
+	}
+
+	doc, err := codec.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	item := doc.Root.Tags[len(doc.Root.Tags)-2]
+	if ref := item.Tags[0].Tags[0]; ref.Text != "RBX1" {
+		t.Errorf("expected referent to be encoded unchanged, got %q", ref.Text)
+	}
+
+	// The referent string follows the rewritten referent of its instance.
+	codec.CanonicalReferents = true
+	if doc, err = codec.Encode(root); err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	items := doc.Root.Tags[len(doc.Root.Tags)-2:]
+	referent, _ := items[1].AttrValue("referent")
+	if referent == "RBX1" {
+		t.Fatalf("expected referent to be rewritten")
+	}
+	if ref := items[0].Tags[0].Tags[0]; ref.Text != referent {
+		t.Errorf("expected reference %q, got %q", referent, ref.Text)
+	}
+}
+
+func TestRobloxCodec_DecodeDefaultExternal(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="ObjectValue" referent="RBX0">
+		<Properties>
+			<Ref name="Value">null</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	root := decodeString(t, RobloxCodec{}, document)
+	if len(root.Instances) != 1 {
+		t.Fatalf("expected 1 instance, got %d", len(root.Instances))
+	}
+	if v, ok := root.Instances[0].Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != nil {
+		t.Errorf("expected default external reference to be empty, got %#v", root.Instances[0].Properties["Value"])
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestRobloxCodec_DecodeStream(t *testing.T) {
+	const count = 10000
+
+	var buf bytes.Buffer
+	buf.WriteString("<roblox version=\"4\">\n\t<External>null</External>\n")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&buf, "\t<Item class=\"ObjectValue\" referent=\"RBX%d\">\n", i)
+		fmt.Fprintf(&buf, "\t\t<Properties>\n\t\t\t<string name=\"Name\">Value%d</string>\n", i)
+		fmt.Fprintf(&buf, "\t\t\t<Ref name=\"Value\">RBX%d</Ref>\n\t\t</Properties>\n", (i+1)%count)
+		fmt.Fprintf(&buf, "\t\t<Item class=\"ObjectValue\" referent=\"RBXChild%d\">\n", i)
+		fmt.Fprintf(&buf, "\t\t\t<Properties>\n\t\t\t\t<Ref name=\"Value\">RBX%d</Ref>\n\t\t\t</Properties>\n\t\t</Item>\n", i)
+		buf.WriteString("\t</Item>\n")
+	}
+	buf.WriteString("</roblox>")
+	size := buf.Len()
+
+	r := &countingReader{r: &buf}
+	received := 0
+	firstRead := 0
+	_, propRefs, err := RobloxCodec{}.DecodeStream(r, func(inst *rbxfile.Instance) error {
+		if received == 0 {
+			firstRead = r.n
+		}
+		if name := inst.Name(); name != fmt.Sprintf("Value%d", received) {
+			t.Fatalf("expected instance Value%d, got %s", received, name)
+		}
+		if len(inst.Children) != 1 {
+			t.Fatalf("expected 1 child, got %d", len(inst.Children))
+		}
+		if v := inst.Children[0].Properties["Value"].(rbxfile.ValueReference); v.Instance != inst {
+			t.Fatalf("expected reference within item to be resolved")
+		}
+		received++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if received != count {
+		t.Errorf("expected %d instances, got %d", count, received)
+	}
+	if firstRead >= size/2 {
+		t.Errorf("expected first instance before reading half of document (%d bytes), read %d bytes", size/2, firstRead)
+	}
+	if len(propRefs) != count {
+		t.Errorf("expected %d unresolved references, got %d", count, len(propRefs))
+	}
+}
+
+func TestRobloxCodec_DecodeStreamMaxInstances(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Folder" referent="RBX0"></Item>
+	<Item class="Folder" referent="RBX1"></Item>
+	<Item class="Folder" referent="RBX2"></Item>
+</roblox>`
+
+	received := 0
+	_, _, err := RobloxCodec{MaxInstances: 2}.DecodeStream(strings.NewReader(document), func(inst *rbxfile.Instance) error {
+		received++
+		return nil
+	})
+	if err != (rbxfile.ErrMaxInstances{Max: 2}) {
+		t.Errorf("expected ErrMaxInstances, got %v", err)
+	}
+	if received != 2 {
+		t.Errorf("expected 2 instances, got %d", received)
+	}
+}
+
+func TestRobloxCodec_DecodeStreamSharedStrings(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<SharedString name="A">XUFAKrxLKna5cZ2REBfFkg==</SharedString>
+			<SharedString name="B">missing</SharedString>
+		</Properties>
+	</Item>
+	<SharedStrings>
+		<SharedString md5="XUFAKrxLKna5cZ2REBfFkg==">aGVsbG8=</SharedString>
+	</SharedStrings>
+</roblox>`
+
+	received := 0
+	doc, _, err := RobloxCodec{}.DecodeStream(strings.NewReader(document), func(inst *rbxfile.Instance) error {
+		if len(inst.Properties) != 0 {
+			t.Errorf("expected shared strings to be dropped, got %v", inst.Properties)
+		}
+		received++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if received != 1 {
+		t.Errorf("expected 1 instance, got %d", received)
+	}
+	warnings := []string{
+		"undefined shared string `XUFAKrxLKna5cZ2REBfFkg==`",
+		"undefined shared string `missing`",
+		"shared string `XUFAKrxLKna5cZ2REBfFkg==` is defined after the items that use it",
+	}
+	if len(doc.Warnings) != len(warnings) {
+		t.Fatalf("unexpected warnings %v", doc.Warnings)
+	}
+	for i, w := range doc.Warnings {
+		if w.Error() != warnings[i] {
+			t.Errorf("expected warning %q, got %q", warnings[i], w)
+		}
+	}
+	dropped := []DroppedProperty{
+		{Class: "Part", Property: "A", Tag: "SharedString"},
+		{Class: "Part", Property: "B", Tag: "SharedString"},
+	}
+	if !reflect.DeepEqual(doc.DroppedProperties, dropped) {
+		t.Errorf("unexpected dropped properties %v", doc.DroppedProperties)
+	}
+}
+
+func TestRobloxCodec_DecodeDuplicateReferent(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">First</string>
+		</Properties>
+	</Item>
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">Second</string>
+		</Properties>
+	</Item>
+	<Item class="ObjectValue" referent="RBX1">
+		<Properties>
+			<Ref name="Value">RBX0</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	if len(doc.Warnings) != 1 || !strings.Contains(doc.Warnings[0].Error(), "duplicate referent") {
+		t.Errorf("expected duplicate referent warning, got %v", doc.Warnings)
+	}
+	if len(root.Instances) != 3 {
+		t.Fatalf("expected 3 instances, got %d", len(root.Instances))
+	}
+	if v := root.Instances[2].Properties["Value"].(rbxfile.ValueReference); v.Instance != root.Instances[0] {
+		t.Errorf("expected reference to resolve to first instance, got %v", v.Instance)
+	}
+}
+
+func TestRobloxCodec_ReportDanglingReferences(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="ObjectValue" referent="RBX0">
+		<Properties>
+			<string name="Name">Value</string>
+			<Ref name="Value">RBX1</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, report := range []bool{false, true} {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{ReportDanglingReferences: report}.Decode(doc)
+		if err != nil {
+			t.Fatalf("failed to decode document: %s", err)
+		}
+		if v := root.Instances[0].Properties["Value"].(rbxfile.ValueReference); v.Instance != nil {
+			t.Errorf("expected empty reference, got %v", v.Instance)
+		}
+		if !report {
+			if len(doc.Warnings) != 0 {
+				t.Errorf("expected no warnings, got %v", doc.Warnings)
+			}
+			continue
+		}
+		if len(doc.Warnings) != 1 {
+			t.Fatalf("expected 1 warning, got %v", doc.Warnings)
+		}
+		if w := doc.Warnings[0].Error(); w != "property Value.`Value` refers to missing referent `RBX1`" {
+			t.Errorf("unexpected warning: %s", w)
+		}
+	}
+}
+
+func TestRobloxCodec_SharedStrings(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<SharedString name="A">XUFAKrxLKna5cZ2REBfFkg==</SharedString>
+			<SharedString name="B">fXkwN6B2AYZXSwKC8vQ15w==</SharedString>
+		</Properties>
+	</Item>
+	<Item class="Part" referent="RBX1">
+		<Properties>
+			<SharedString name="A">XUFAKrxLKna5cZ2REBfFkg==</SharedString>
+		</Properties>
+	</Item>
+	<SharedStrings>
+		<SharedString md5="XUFAKrxLKna5cZ2REBfFkg==">aGVsbG8=</SharedString>
+		<SharedString md5="fXkwN6B2AYZXSwKC8vQ15w==">d29ybGQ=</SharedString>
+	</SharedStrings>
+</roblox>`
+
+	check := func(root *rbxfile.Root) {
+		if len(root.Instances) != 2 {
+			t.Fatalf("expected 2 instances, got %d", len(root.Instances))
+		}
+		for _, p := range []struct {
+			inst  int
+			name  string
+			value string
+		}{
+			{0, "A", "hello"},
+			{0, "B", "world"},
+			{1, "A", "hello"},
+		} {
+			v, ok := root.Instances[p.inst].Properties[p.name].(rbxfile.ValueSharedString)
+			if !ok || string(v) != p.value {
+				t.Errorf("instance %d: expected property %s to be %q, got %#v", p.inst, p.name, p.value, root.Instances[p.inst].Properties[p.name])
+			}
+		}
+	}
+
+	root := decodeString(t, RobloxCodec{}, document)
+	check(root)
+
+	doc, err := RobloxCodec{}.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode root: %s", err)
+	}
+	var sharedStrings *Tag
+	for _, tag := range doc.Root.Tags {
+		if tag.StartName == "SharedStrings" {
+			sharedStrings = tag
+		}
+	}
+	if sharedStrings == nil {
+		t.Fatal("expected SharedStrings tag")
+	}
+	if len(sharedStrings.Tags) != 2 {
+		t.Errorf("expected 2 shared strings, got %d", len(sharedStrings.Tags))
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	check(decodeString(t, RobloxCodec{}, buf.String()))
+}
+
+func TestRobloxCodec_UnknownClasses(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">Part</string>
+		</Properties>
+		<Item class="FutureClass" referent="RBX1">
+			<Properties>
+				<string name="Name">Future</string>
+			</Properties>
+		</Item>
+	</Item>
+	<Item class="FutureClass" referent="RBX2"/>
+	<Item class="OtherClass" referent="RBX3"/>
+</roblox>`
+
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Part": &rbxapi.Class{Name: "Part"},
+		},
+	}
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{API: api}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	expected := map[string]bool{"FutureClass": true, "OtherClass": true}
+	if !reflect.DeepEqual(doc.UnknownClasses, expected) {
+		t.Errorf("unexpected unknown classes (expected %v, got %v)", expected, doc.UnknownClasses)
+	}
+	if len(root.Instances) != 3 || len(root.Instances[0].Children) != 1 {
+		t.Error("expected instances of unknown classes to be decoded")
+	}
+	if name := root.Instances[0].Children[0].Name(); name != "Future" {
+		t.Errorf("expected properties of unknown class to be decoded, got name %q", name)
+	}
+}
+
+func TestRobloxCodec_ControlCharacters(t *testing.T) {
+	b := make([]byte, 0x20)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	value := "a" + string(b) + "b\r\n\r"
+
+	inst := rbxfile.NewInstance("StringValue", nil)
+	inst.Set("Value", rbxfile.ValueString(value))
+	doc, err := RobloxCodec{}.Encode(&rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	if err != nil {
+		t.Fatalf("failed to encode root: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	for _, c := range buf.Bytes() {
+		if c < 0x20 && c != '\n' && c != '\t' {
+			t.Fatalf("unescaped control character %#02x in document:\n%s", c, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "a&#0;&#1;&#2;") {
+		t.Errorf("expected control characters to be escaped numerically:\n%s", buf.String())
+	}
+
+	root := decodeString(t, RobloxCodec{}, buf.String())
+	if v := root.Instances[0].Properties["Value"].(rbxfile.ValueString); string(v) != value {
+		t.Errorf("string does not match after round-trip:\n\texpected: %q\n\tgot:      %q", value, v)
+	}
+}
+
+func TestRobloxCodec_Base64LineWidth(t *testing.T) {
+	payload := make([]byte, 120)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	full := base64.StdEncoding.EncodeToString(payload)
+
+	split := func(width int) string {
+		var lines []string
+		s := full
+		for len(s) > width {
+			lines = append(lines, s[:width])
+			s = s[width:]
+		}
+		return strings.Join(append(lines, s), "\n")
+	}
+
+	for _, test := range []struct {
+		width    int
+		expected string
+	}{
+		{0, split(DefaultBase64LineWidth)},
+		{16, split(16)},
+		{-1, full},
+	} {
+		enc := &rencoder{codec: RobloxCodec{Base64LineWidth: test.width}}
+		tag := enc.encodeProperty("Folder", "Data", rbxfile.ValueBinaryString(payload))
+		if text := string(tag.CData); text != test.expected {
+			t.Errorf("width %d: unexpected content:\n\texpected: %q\n\tgot:      %q", test.width, test.expected, text)
+		}
+		dec := &rdecoder{document: new(Document)}
+		if v, ok := dec.getValue(tag, "BinaryString", nil); !ok || !bytes.Equal(v.(rbxfile.ValueBinaryString), payload) {
+			t.Errorf("width %d: content does not decode to payload", test.width)
+		}
+	}
+}
+
+func TestBinaryStringReader(t *testing.T) {
+	// Large enough to span many reads.
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	inst := rbxfile.NewInstance("UnionOperation", nil)
+	inst.Set("PhysicsData", rbxfile.ValueBinaryString(data))
+	doc, err := RobloxCodec{}.Encode(&rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	if _, err := doc.ReadFrom(&buf); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+
+	tag := doc.Root.Tags[len(doc.Root.Tags)-1].Tags[0].Tags[0]
+	var out bytes.Buffer
+	n, err := io.Copy(&out, BinaryStringReader(tag))
+	if err != nil {
+		t.Fatalf("failed to stream: %s", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("streamed data does not match (got %d bytes)", n)
+	}
+
+	if _, err := io.Copy(ioutil.Discard, BinaryStringReader(&Tag{Text: "not*base64"})); err == nil {
+		t.Errorf("expected error for invalid content")
+	}
+}
+
+func TestRobloxCodec_BrickColorTag(t *testing.T) {
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Part": &rbxapi.Class{
+				Name: "Part",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "BrickColor", MemberClass: "Part", ValueType: "BrickColor"},
+				},
+			},
+		},
+	}
+
+	for _, form := range []struct {
+		brickColorTag bool
+		name          string
+	}{
+		{false, "int"},
+		{true, "BrickColor"},
+	} {
+		inst := rbxfile.NewInstance("Part", nil)
+		inst.Set("BrickColor", rbxfile.ValueBrickColor(194))
+		codec := RobloxCodec{API: api, BrickColorTag: form.brickColorTag}
+		doc, err := codec.Encode(&rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+		if err != nil {
+			t.Fatalf("%s: failed to encode root: %s", form.name, err)
+		}
+		var buf bytes.Buffer
+		if _, err := doc.WriteTo(&buf); err != nil {
+			t.Fatalf("%s: failed to write document: %s", form.name, err)
+		}
+		if !strings.Contains(buf.String(), "<"+form.name+" name=\"BrickColor\">194</"+form.name+">") {
+			t.Errorf("%s: expected %s tag:\n%s", form.name, form.name, buf.String())
+		}
+
+		root := decodeString(t, codec, buf.String())
+		if v := root.Instances[0].Properties["BrickColor"]; v != rbxfile.ValueBrickColor(194) {
+			t.Errorf("%s: expected BrickColor after round-trip, got %#v", form.name, v)
+		}
+	}
+
+	// Without an API, only the BrickColor tag can be recognized.
+	root := decodeString(t, RobloxCodec{}, `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<BrickColor name="BrickColor">194</BrickColor>
+		</Properties>
+	</Item>
+</roblox>`)
+	if v := root.Instances[0].Properties["BrickColor"]; v != rbxfile.ValueBrickColor(194) {
+		t.Errorf("expected BrickColor without API, got %#v", v)
+	}
+}
+
+func TestRobloxCodec_DecodeBrickColorHint(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<int name="BrickColor">194</int>
+			<int name="Value">194</int>
+		</Properties>
+	</Item>
+	<Item class="BrickColorValue" referent="RBX1">
+		<Properties>
+			<int name="Value">21</int>
+		</Properties>
+	</Item>
+</roblox>`
+
+	root := decodeString(t, RobloxCodec{}, document)
+	part := root.Instances[0].Properties
+	if v, ok := part["BrickColor"].(rbxfile.ValueBrickColor); !ok || v != 194 {
+		t.Errorf("expected BrickColor 194, got %#v", part["BrickColor"])
+	}
+	if v, ok := part["Value"].(rbxfile.ValueInt); !ok || v != 194 {
+		t.Errorf("expected int 194, got %#v", part["Value"])
+	}
+	value := root.Instances[1].Properties
+	if v, ok := value["Value"].(rbxfile.ValueBrickColor); !ok || v != 21 {
+		t.Errorf("expected BrickColor 21, got %#v", value["Value"])
+	}
+}
+
+func TestRobloxCodec_DecodeTypeAttribute(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Script" referent="RBX0">
+		<Properties>
+			<string name="X" type="Content">rbxassetid://1</string>
+			<int name="Material" type="Token">256</int>
+			<string name="Source" type="String">print(1)</string>
+			<Custom name="Link" type="ProtectedString">text</Custom>
+			<string name="Y" type="Bogus">y</string>
+		</Properties>
+	</Item>
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["X"].(rbxfile.ValueContent); !ok || string(v) != "rbxassetid://1" {
+		t.Errorf("expected Content, got %#v", props["X"])
+	}
+	if v := props["Material"]; v != rbxfile.ValueToken(256) {
+		t.Errorf("expected token, got %#v", v)
+	}
+	// Takes precedence over the hint for Source.
+	if v, ok := props["Source"].(rbxfile.ValueString); !ok || string(v) != "print(1)" {
+		t.Errorf("expected string, got %#v", props["Source"])
+	}
+	if v, ok := props["Link"].(rbxfile.ValueProtectedString); !ok || string(v) != "text" {
+		t.Errorf("expected ProtectedString, got %#v", props["Link"])
+	}
+	if v, ok := props["Y"].(rbxfile.ValueString); !ok || string(v) != "y" {
+		t.Errorf("expected string, got %#v", props["Y"])
+	}
+	if len(doc.Warnings) != 1 || doc.Warnings[0].Error() != "property Script.`Y` has unknown type `Bogus`" {
+		t.Errorf("unexpected warnings %v", doc.Warnings)
+	}
+
+	// The API takes precedence over the attribute.
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Script": &rbxapi.Class{
+				Name: "Script",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "X", MemberClass: "Script", ValueType: "string"},
+				},
+			},
+		},
+	}
+	root = decodeString(t, RobloxCodec{API: api}, document)
+	if v, ok := root.Instances[0].Properties["X"].(rbxfile.ValueString); !ok || string(v) != "rbxassetid://1" {
+		t.Errorf("expected string from API, got %#v", root.Instances[0].Properties["X"])
+	}
+}
+
+func TestRobloxCodec_DecodeQuaternionCFrame(t *testing.T) {
+	const s2 = 0.70710678
+	tests := []struct {
+		q        [4]float64
+		expected [9]float32
+	}{
+		// Identity.
+		{[4]float64{0, 0, 0, 1}, [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		// 90 degrees around the Y axis.
+		{[4]float64{0, s2, 0, s2}, [9]float32{0, 0, 1, 0, 1, 0, -1, 0, 0}},
+		// 180 degrees around the X axis.
+		{[4]float64{1, 0, 0, 0}, [9]float32{1, 0, 0, 0, -1, 0, 0, 0, -1}},
+		// 120 degrees around (1, 1, 1), which permutes the axes. Not
+		// normalized.
+		{[4]float64{2, 2, 2, 2}, [9]float32{0, 0, 1, 1, 0, 0, 0, 1, 0}},
+	}
+	for i, test := range tests {
+		document := fmt.Sprintf(`<roblox version="4">
+	<Item class="CFrameValue" referent="RBX0">
+		<Properties>
+			<CoordinateFrame name="Value">
+				<X>1</X>
+				<Y>2</Y>
+				<Z>3</Z>
+				<QX>%v</QX>
+				<QY>%v</QY>
+				<QZ>%v</QZ>
+				<QW>%v</QW>
+			</CoordinateFrame>
+		</Properties>
+	</Item>
+</roblox>`, test.q[0], test.q[1], test.q[2], test.q[3])
+
+		root := decodeString(t, RobloxCodec{}, document)
+		v, ok := root.Instances[0].Properties["Value"].(rbxfile.ValueCFrame)
+		if !ok {
+			t.Fatalf("%d: expected CFrame, got %#v", i, root.Instances[0].Properties["Value"])
+		}
+		if v.Position != (rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}) {
+			t.Errorf("%d: unexpected position %v", i, v.Position)
+		}
+		for j, r := range v.Rotation {
+			if d := r - test.expected[j]; d < -1e-6 || d > 1e-6 {
+				t.Errorf("%d: unexpected rotation:\n\texpected: %v\n\tgot:      %v", i, test.expected, v.Rotation)
+				break
+			}
+		}
+	}
+}
+
+func TestRobloxCodec_DecodeOrientationCFrame(t *testing.T) {
+	tests := []struct {
+		rotation string
+		expected [9]float32
+		warning  string
+	}{
+		// Position only.
+		{"", [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}, ""},
+		// Right is +X, up is +Y.
+		{"<Orientation>2</Orientation>", [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}, ""},
+		// Right is +X, up is +Z.
+		{"<Orientation>3</Orientation>", [9]float32{1, 0, 0, 0, 0, -1, 0, 1, 0}, ""},
+		// Right is -Z, up is +Y.
+		{"<Orientation>32</Orientation>", [9]float32{0, 0, 1, 0, 1, 0, -1, 0, 0}, ""},
+		// Right and up are the same.
+		{"<Orientation>1</Orientation>", [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}, "invalid orientation `1`"},
+		{"<Orientation>37</Orientation>", [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}, "invalid orientation `37`"},
+	}
+	for i, test := range tests {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(`<roblox version="4">
+	<Item class="CFrameValue" referent="RBX0">
+		<Properties>
+			<CoordinateFrame name="Value">
+				<X>1</X>
+				<Y>2</Y>
+				<Z>3</Z>
+				` + test.rotation + `
+			</CoordinateFrame>
+		</Properties>
+	</Item>
+</roblox>`)); err != nil {
+			t.Fatalf("%d: failed to read document: %s", i, err)
+		}
+		root, err := RobloxCodec{}.Decode(doc)
+		if err != nil {
+			t.Fatalf("%d: failed to decode document: %s", i, err)
+		}
+		expected := rbxfile.ValueCFrame{
+			Position: rbxfile.ValueVector3{X: 1, Y: 2, Z: 3},
+			Rotation: test.expected,
+		}
+		if v := root.Instances[0].Properties["Value"]; v != expected {
+			t.Errorf("%d: unexpected value:\n\texpected: %v\n\tgot:      %v", i, expected, v)
+		}
+		if test.warning == "" && len(doc.Warnings) != 0 {
+			t.Errorf("%d: unexpected warnings %v", i, doc.Warnings)
+		} else if test.warning != "" && (len(doc.Warnings) != 1 || doc.Warnings[0].Error() != test.warning) {
+			t.Errorf("%d: expected warning %q, got %v", i, test.warning, doc.Warnings)
+		}
+	}
+}
+
+func TestRobloxCodec_DecodeLegacyMatrixCFrame(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<CoordinateFrame name="CFrame">
+				<X>1</X>
+				<Y>2</Y>
+				<Z>3</Z>
+				<Matrix>
+					<M00>0</M00>
+					<M01>0</M01>
+					<M02>1</M02>
+					<M10>0</M10>
+					<M11>1</M11>
+					<M12>0</M12>
+					<M20>-1</M20>
+					<M21>0</M21>
+					<M22>0</M22>
+				</Matrix>
+			</CoordinateFrame>
+		</Properties>
+	</Item>
+</roblox>`
+
+	root := decodeString(t, RobloxCodec{}, document)
+	expected := rbxfile.ValueCFrame{
+		Position: rbxfile.ValueVector3{X: 1, Y: 2, Z: 3},
+		Rotation: [9]float32{0, 0, 1, 0, 1, 0, -1, 0, 0},
+	}
+	if v := root.Instances[0].Properties["CFrame"]; v != expected {
+		t.Errorf("unexpected value:\n\texpected: %v\n\tgot:      %v", expected, v)
+	}
+}
+
+func TestRobloxCodec_OrthonormalizeCFrames(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="CFrameValue" referent="RBX0">
+		<Properties>
+			<CoordinateFrame name="Value">
+				<X>0</X><Y>0</Y><Z>0</Z>
+				<R00>1.01</R00><R01>0</R01><R02>0</R02>
+				<R10>0</R10><R11>0.99</R11><R12>0</R12>
+				<R20>0</R20><R21>0</R21><R22>1</R22>
+			</CoordinateFrame>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, orthonormalize := range []bool{false, true} {
+		root := decodeString(t, RobloxCodec{OrthonormalizeCFrames: orthonormalize}, document)
+		v := root.Instances[0].Properties["Value"].(rbxfile.ValueCFrame)
+		if orthonormalize && v.Rotation != [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1} {
+			t.Errorf("expected orthonormal rotation, got %v", v.Rotation)
+		} else if !orthonormalize && v.Rotation[0] != 1.01 {
+			t.Errorf("expected rotation to be unchanged, got %v", v.Rotation)
+		}
+	}
+}
+
+func TestRobloxCodec_CoerceNumbers(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<int name="Transparency">1</int>
+			<float name="Count">2.75</float>
+			<double name="Large">1e10</double>
+			<float name="Reflectance">0.5</float>
+		</Properties>
+	</Item>
+</roblox>`
+
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Part": &rbxapi.Class{
+				Name: "Part",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Transparency", MemberClass: "Part", ValueType: "float"},
+					&rbxapi.Property{MemberName: "Count", MemberClass: "Part", ValueType: "int"},
+					&rbxapi.Property{MemberName: "Large", MemberClass: "Part", ValueType: "int"},
+					&rbxapi.Property{MemberName: "Reflectance", MemberClass: "Part", ValueType: "float"},
+				},
+			},
+		},
+	}
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{API: api, CoerceNumbers: true}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v := props["Transparency"]; v != rbxfile.ValueFloat(1) {
+		t.Errorf("expected int to be coerced to float, got %#v", v)
+	}
+	if v := props["Count"]; v != rbxfile.ValueInt(2) {
+		t.Errorf("expected float to be coerced to int, got %#v", v)
+	}
+	if v, ok := props["Large"]; ok {
+		t.Errorf("expected overflowing value to be excluded, got %#v", v)
+	}
+	if v := props["Reflectance"]; v != rbxfile.ValueFloat(0.5) {
+		t.Errorf("expected matching value to be unchanged, got %#v", v)
+	}
+	warnings := []string{
+		"coerced property Part.`Transparency` from int to float",
+		"coerced property Part.`Count` from float to int",
+		"property Part.`Large` overflows int",
+	}
+	if len(doc.Warnings) != len(warnings) {
+		t.Fatalf("unexpected warnings %v", doc.Warnings)
+	}
+	for _, w := range doc.Warnings {
+		found := false
+		for _, e := range warnings {
+			if w.Error() == e {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("unexpected warning %q", w)
+		}
+	}
+	dropped := []DroppedProperty{{Class: "Part", Property: "Large", Tag: "double"}}
+	if !reflect.DeepEqual(doc.DroppedProperties, dropped) {
+		t.Errorf("unexpected dropped properties %v", doc.DroppedProperties)
+	}
+}
+
+func TestRobloxCodec_DecodeInvalidToken(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<token name="Material">256</token>
+			<token name="Shape">1000</token>
+		</Properties>
+	</Item>
+</roblox>`
+
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Part": &rbxapi.Class{
+				Name: "Part",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Material", MemberClass: "Part", ValueType: "Material"},
+					&rbxapi.Property{MemberName: "Shape", MemberClass: "Part", ValueType: "PartType"},
+				},
+			},
+		},
+		Enums: map[string]*rbxapi.Enum{
+			"Material": &rbxapi.Enum{Name: "Material", Items: []*rbxapi.EnumItem{{Name: "Plastic", Value: 256}}},
+			"PartType": &rbxapi.Enum{Name: "PartType", Items: []*rbxapi.EnumItem{{Name: "Block", Value: 1}}},
+		},
+	}
+
+	// An invalid token is always warned about, and is kept unless invalid
+	// items are excluded.
+	for _, exclude := range []bool{false, true} {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{API: api, ExcludeInvalidAPI: exclude}.Decode(doc)
+		if err != nil {
+			t.Fatalf("failed to decode document: %s", err)
+		}
+		if len(doc.Warnings) != 1 || doc.Warnings[0].Error() != "invalid item `1000` for enum PartType" {
+			t.Errorf("exclude %t: unexpected warnings %v", exclude, doc.Warnings)
+		}
+		props := root.Instances[0].Properties
+		if v := props["Material"]; v != rbxfile.ValueToken(256) {
+			t.Errorf("exclude %t: expected valid token to be kept, got %#v", exclude, v)
+		}
+		if v, ok := props["Shape"]; exclude && ok {
+			t.Errorf("exclude %t: expected invalid token to be dropped, got %#v", exclude, v)
+		} else if !exclude && v != rbxfile.ValueToken(1000) {
+			t.Errorf("exclude %t: expected invalid token to be kept, got %#v", exclude, v)
+		}
+	}
+}
+
+func TestRobloxCodec_DecodeNamedToken(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<token name="Material">Plastic</token>
+			<token name="Shape">Sphere</token>
+			<token name="Unknown">Block</token>
+		</Properties>
+	</Item>
+</roblox>`
+
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Part": &rbxapi.Class{
+				Name: "Part",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Material", MemberClass: "Part", ValueType: "Material"},
+					&rbxapi.Property{MemberName: "Shape", MemberClass: "Part", ValueType: "PartType"},
+				},
+			},
+		},
+		Enums: map[string]*rbxapi.Enum{
+			"Material": &rbxapi.Enum{Name: "Material", Items: []*rbxapi.EnumItem{{Name: "Plastic", Value: 256}}},
+			"PartType": &rbxapi.Enum{Name: "PartType", Items: []*rbxapi.EnumItem{{Name: "Block", Value: 1}}},
+		},
+	}
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{API: api}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v := props["Material"]; v != rbxfile.ValueToken(256) {
+		t.Errorf("expected name to resolve to token, got %#v", v)
+	}
+	if v, ok := props["Shape"]; ok {
+		t.Errorf("expected invalid name to be dropped, got %#v", v)
+	}
+	if v, ok := props["Unknown"]; ok {
+		t.Errorf("expected name without enum to be dropped, got %#v", v)
+	}
+	warnings := []string{
+		"invalid item `Sphere` for enum PartType",
+		"token `Block` is not a number, and has no enum to resolve a name",
+	}
+	if len(doc.Warnings) != len(warnings) {
+		t.Fatalf("unexpected warnings %v", doc.Warnings)
+	}
+	for i, w := range doc.Warnings {
+		if w.Error() != warnings[i] {
+			t.Errorf("unexpected warning %q", w)
+		}
+	}
+}
+
+func TestRobloxCodec_MaxDepth(t *testing.T) {
+	nested := func(depth int) string {
+		var buf bytes.Buffer
+		buf.WriteString(`<roblox version="4">`)
+		for i := 0; i < depth; i++ {
+			fmt.Fprintf(&buf, `<Item class="Folder" referent="RBX%d">`, i)
+		}
+		for i := 0; i < depth; i++ {
+			buf.WriteString(`</Item>`)
+		}
+		buf.WriteString(`</roblox>`)
+		return buf.String()
+	}
+	depthOf := func(root *rbxfile.Root) (depth int) {
+		for insts := root.Instances; len(insts) > 0; insts = insts[0].Children {
+			depth++
+		}
+		return depth
+	}
+
+	for _, test := range []struct {
+		maxDepth int
+		depth    int
+		expected int
+		warned   bool
+	}{
+		{0, DefaultMaxDepth, DefaultMaxDepth, false},
+		{0, DefaultMaxDepth + 10, DefaultMaxDepth, true},
+		{5, 5, 5, false},
+		{5, 6, 5, true},
+		{-1, DefaultMaxDepth + 10, DefaultMaxDepth + 10, false},
+	} {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(nested(test.depth))); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{MaxDepth: test.maxDepth}.Decode(doc)
+		if err != nil {
+			t.Fatalf("failed to decode document: %s", err)
+		}
+		if d := depthOf(root); d != test.expected {
+			t.Errorf("max %d, depth %d: expected decoded depth %d, got %d", test.maxDepth, test.depth, test.expected, d)
+		}
+		if warned := len(doc.Warnings) > 0; warned != test.warned {
+			t.Errorf("max %d, depth %d: expected warning %t, got %v", test.maxDepth, test.depth, test.warned, doc.Warnings)
+		}
+	}
+}
+
+func TestRobloxCodec_MaxInstances(t *testing.T) {
+	items := func(n int) *Document {
+		var buf bytes.Buffer
+		buf.WriteString(`<roblox version="4">`)
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&buf, `<Item class="Folder" referent="RBX%d"><Item class="Part"/></Item>`, i)
+		}
+		buf.WriteString(`</roblox>`)
+		doc := new(Document)
+		if _, err := doc.ReadFrom(&buf); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		return doc
+	}
+
+	root, err := RobloxCodec{MaxInstances: 10}.Decode(items(5))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(root.Instances) != 5 {
+		t.Errorf("expected 5 instances, got %d", len(root.Instances))
+	}
+
+	dec := &rdecoder{
+		document:   items(1000),
+		codec:      RobloxCodec{MaxInstances: 10},
+		instLookup: make(rbxfile.References),
+	}
+	if err := dec.decode(); err != (rbxfile.ErrMaxInstances{Max: 10}) {
+		t.Fatalf("expected ErrMaxInstances, got %v", err)
+	}
+	if dec.root != nil {
+		t.Errorf("expected nil root")
+	}
+	if dec.count != 10 {
+		t.Errorf("expected decoding to stop after 10 instances, decoded %d", dec.count)
+	}
+}
+
+func TestRobloxCodec_MaxProperties(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`<roblox version="4"><Item class="Part" referent="RBX0"><Properties>`)
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buf, `<int name="Prop%d">%d</int>`, i, i)
+	}
+	buf.WriteString(`</Properties></Item><Item class="Part" referent="RBX1"><Properties>`)
+	buf.WriteString(`<string name="Name">Part</string>`)
+	buf.WriteString(`</Properties></Item></roblox>`)
+	doc := new(Document)
+	if _, err := doc.ReadFrom(&buf); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+
+	root, err := RobloxCodec{MaxProperties: 10}.Decode(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(root.Instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(root.Instances))
+	}
+	if n := len(root.Instances[0].Properties); n != 10 {
+		t.Errorf("expected 10 properties, got %d", n)
+	}
+	if v := root.Instances[0].Properties["Prop9"]; v != rbxfile.ValueInt(9) {
+		t.Errorf("unexpected value %v of Prop9", v)
+	}
+	if n := len(root.Instances[1].Properties); n != 1 {
+		t.Errorf("expected 1 property, got %d", n)
+	}
+	if len(doc.Warnings) != 1 {
+		t.Errorf("expected 1 warning, got %v", doc.Warnings)
+	}
+
+	root, err = RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := len(root.Instances[0].Properties); n != 100 {
+		t.Errorf("expected 100 properties, got %d", n)
+	}
+}
+
+func TestRobloxCodec_StrictContent(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Decal" referent="RBX0">
+		<Properties>
+			<Content name="Texture"><null>content</null></Content>
+			<Content name="Empty"><null></null></Content>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, strict := range []bool{false, true} {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{StrictContent: strict}.Decode(doc)
+		if err != nil {
+			t.Fatalf("strict %t: failed to decode document: %s", strict, err)
+		}
+		props := root.Instances[0].Properties
+		if strict && (len(doc.Warnings) != 1 || doc.Warnings[0].Error() != "null tag of Content has content") {
+			t.Errorf("strict %t: unexpected warnings %v", strict, doc.Warnings)
+		} else if !strict && len(doc.Warnings) != 0 {
+			t.Errorf("strict %t: unexpected warnings %v", strict, doc.Warnings)
+		}
+		if v, ok := props["Texture"]; strict && ok {
+			t.Errorf("strict %t: expected value to be excluded, got %#v", strict, v)
+		} else if !strict && (!ok || len(v.(rbxfile.ValueContent)) != 0) {
+			t.Errorf("strict %t: expected empty content, got %#v", strict, v)
+		}
+		if v, ok := props["Empty"].(rbxfile.ValueContent); !ok || len(v) != 0 {
+			t.Errorf("strict %t: expected empty content, got %#v", strict, props["Empty"])
+		}
+	}
+}
+
+func TestRobloxCodec_ValidateContent(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="MeshPart" referent="RBX0">
+		<Properties>
+			<Content name="MeshId"><url>rbxassetid://1818</url></Content>
+			<Content name="TextureID"><url>htp://www.roblox.com/asset/?id=1818</url></Content>
+			<Content name="Empty"><null></null></Content>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, validate := range []bool{false, true} {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{ValidateContent: validate}.Decode(doc)
+		if err != nil {
+			t.Fatalf("validate %t: failed to decode document: %s", validate, err)
+		}
+		if validate && (len(doc.Warnings) != 1 || doc.Warnings[0].Error() != "property MeshPart.`TextureID` has unrecognized content URL `htp://www.roblox.com/asset/?id=1818`") {
+			t.Errorf("validate %t: unexpected warnings %v", validate, doc.Warnings)
+		} else if !validate && len(doc.Warnings) != 0 {
+			t.Errorf("validate %t: unexpected warnings %v", validate, doc.Warnings)
+		}
+		if len(root.Instances[0].Properties) != 3 {
+			t.Errorf("validate %t: expected all properties to be decoded", validate)
+		}
+	}
+}
+
+func TestRobloxCodec_ContentRewriter(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Decal" referent="RBX0">
+		<Properties>
+			<Content name="Texture"><url>rbxasset://textures/face.png</url></Content>
+			<Content name="Other"><url>rbxassetid://1818</url></Content>
+			<string name="Name">face</string>
+		</Properties>
+	</Item>
+</roblox>`
+
+	legacy := map[string]string{
+		"rbxasset://textures/face.png": "rbxassetid://7074764",
+	}
+	var urls []string
+	codec := RobloxCodec{
+		ContentRewriter: func(url string) string {
+			urls = append(urls, url)
+			if v, ok := legacy[url]; ok {
+				return v
+			}
+			return url
+		},
+	}
+	root := decodeString(t, codec, document)
+	props := root.Instances[0].Properties
+	if v, ok := props["Texture"].(rbxfile.ValueContent); !ok || string(v) != "rbxassetid://7074764" {
+		t.Errorf("expected rewritten URL, got %#v", props["Texture"])
+	}
+	if v, ok := props["Other"].(rbxfile.ValueContent); !ok || string(v) != "rbxassetid://1818" {
+		t.Errorf("expected unchanged URL, got %#v", props["Other"])
+	}
+	if len(urls) != 2 {
+		t.Errorf("expected rewriter to be called for each Content value, got %q", urls)
+	}
+}
+
+func TestRobloxCodec_Int16Overflow(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Folder" referent="RBX0">
+		<Properties>
+			<Vector2int16 name="A">
+				<X>40000</X>
+				<Y>-2</Y>
+			</Vector2int16>
+			<Vector3int16 name="B">
+				<X>1</X>
+				<Y>-40000</Y>
+				<Z>3</Z>
+			</Vector3int16>
+		</Properties>
+	</Item>
+</roblox>`
+
+	tests := []struct {
+		policy OverflowPolicy
+		a, b   rbxfile.Value
+	}{
+		{OverflowZero, rbxfile.ValueVector2int16{X: 0, Y: -2}, rbxfile.ValueVector3int16{X: 1, Y: 0, Z: 3}},
+		{OverflowClamp, rbxfile.ValueVector2int16{X: 32767, Y: -2}, rbxfile.ValueVector3int16{X: 1, Y: -32768, Z: 3}},
+		{OverflowExclude, nil, nil},
+	}
+	for _, test := range tests {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{Int16Overflow: test.policy}.Decode(doc)
+		if err != nil {
+			t.Fatalf("policy %d: failed to decode document: %s", test.policy, err)
+		}
+		props := root.Instances[0].Properties
+		if v := props["A"]; v != test.a {
+			t.Errorf("policy %d: expected A to be %#v, got %#v", test.policy, test.a, v)
+		}
+		if v := props["B"]; v != test.b {
+			t.Errorf("policy %d: expected B to be %#v, got %#v", test.policy, test.b, v)
+		}
+		if len(doc.Warnings) != 2 ||
+			doc.Warnings[0].Error() != "Vector2int16 component X `40000` overflows int16" ||
+			doc.Warnings[1].Error() != "Vector3int16 component Y `-40000` overflows int16" {
+			t.Errorf("policy %d: unexpected warnings %v", test.policy, doc.Warnings)
+		}
+	}
+}
+
+func TestRobloxCodec_StrictVectors(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<Vector3 name="Size">
+				<X>4</X>
+				<Y>1</Y>
+			</Vector3>
+			<Vector3 name="Velocity">
+				<X>1</X>
+				<Y>2</Y>
+				<Z>3</Z>
+			</Vector3>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, strict := range []bool{false, true} {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+			t.Fatalf("failed to read document: %s", err)
+		}
+		root, err := RobloxCodec{StrictVectors: strict}.Decode(doc)
+		if err != nil {
+			t.Fatalf("strict %t: failed to decode document: %s", strict, err)
+		}
+		props := root.Instances[0].Properties
+		if v, ok := props["Size"]; strict && ok {
+			t.Errorf("strict %t: expected value to be excluded, got %#v", strict, v)
+		} else if !strict && v != (rbxfile.ValueVector3{X: 4, Y: 1, Z: 0}) {
+			t.Errorf("strict %t: expected missing component to be zero, got %#v", strict, v)
+		}
+		if v := props["Velocity"]; v != (rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}) {
+			t.Errorf("strict %t: unexpected complete value %#v", strict, v)
+		}
+		if strict && (len(doc.Warnings) != 1 || doc.Warnings[0].Error() != "Vector3 is missing components Z") {
+			t.Errorf("strict %t: unexpected warnings %v", strict, doc.Warnings)
+		} else if !strict && len(doc.Warnings) != 0 {
+			t.Errorf("strict %t: unexpected warnings %v", strict, doc.Warnings)
+		}
+	}
+}
+
+func TestRobloxCodec_PropertyTypeHints(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Script" referent="RBX0">
+		<Properties>
+			<string name="Name">Script</string>
+			<string name="Source">print("hello")</string>
+			<string name="LinkedSource">rbxassetid://1818</string>
+		</Properties>
+	</Item>
+	<Item class="Script" referent="RBX1">
+		<Properties>
+			<Code name="Source"><![CDATA[print("world")]]></Code>
+			<bool name="LinkedSource">true</bool>
+		</Properties>
+	</Item>
+</roblox>`
+
+	root := decodeString(t, RobloxCodec{}, document)
+	a, b := root.Instances[0].Properties, root.Instances[1].Properties
+	if v, ok := a["Name"].(rbxfile.ValueString); !ok || string(v) != "Script" {
+		t.Errorf("expected Name to remain a string, got %#v", a["Name"])
+	}
+	if v, ok := a["Source"].(rbxfile.ValueProtectedString); !ok || string(v) != `print("hello")` {
+		t.Errorf("expected Source to be a ProtectedString, got %#v", a["Source"])
+	}
+	if v, ok := a["LinkedSource"].(rbxfile.ValueContent); !ok || string(v) != "rbxassetid://1818" {
+		t.Errorf("expected LinkedSource to be Content, got %#v", a["LinkedSource"])
+	}
+	if v, ok := b["Source"].(rbxfile.ValueProtectedString); !ok || string(v) != `print("world")` {
+		t.Errorf("expected Source with unknown tag to be a ProtectedString, got %#v", b["Source"])
+	}
+	if v := b["LinkedSource"]; v != rbxfile.ValueBool(true) {
+		t.Errorf("expected non-string value to be unchanged, got %#v", v)
+	}
+
+	// The hints are not used with an API.
+	api := &rbxapi.API{Classes: map[string]*rbxapi.Class{}}
+	root = decodeString(t, RobloxCodec{API: api}, document)
+	if _, ok := root.Instances[0].Properties["Source"].(rbxfile.ValueString); !ok {
+		t.Errorf("expected Source to be a string with API, got %#v", root.Instances[0].Properties["Source"])
+	}
+}
+
+func TestRobloxCodec_PropertyFilter(t *testing.T) {
+	script := rbxfile.NewInstance("Script", nil)
+	script.SetName("Script")
+	script.Set("Source", rbxfile.ValueProtectedString("print('hello')"))
+	script.Set("Disabled", rbxfile.ValueBool(true))
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{script}}
+
+	codec := RobloxCodec{PropertyFilter: func(class, name string, value rbxfile.Value) bool {
+		return name != "Source"
+	}}
+	doc, err := codec.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	var names []string
+	for _, tag := range doc.Root.Tags {
+		if tag.StartName != "Item" {
+			continue
+		}
+		for _, props := range tag.Tags {
+			for _, prop := range props.Tags {
+				name, _ := prop.AttrValue("name")
+				names = append(names, name)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"Disabled", "Name"}) {
+		t.Errorf("unexpected properties %v", names)
+	}
+	if _, ok := script.Properties["Source"]; !ok {
+		t.Errorf("expected tree to be unmodified")
+	}
+}
+
+func TestRobloxCodec_InstanceFilter(t *testing.T) {
+	workspace := rbxfile.NewInstance("Workspace", nil)
+	workspace.SetName("Workspace")
+	rbxfile.NewInstance("Terrain", workspace).SetName("Terrain")
+	debug := rbxfile.NewInstance("Folder", workspace)
+	debug.SetName("Debug")
+	marker := rbxfile.NewInstance("Part", debug)
+	marker.SetName("Marker")
+	model := rbxfile.NewInstance("Model", workspace)
+	model.SetName("Model")
+	part := rbxfile.NewInstance("Part", model)
+	part.SetName("Part")
+	rbxfile.NewInstance("Decal", part)
+	rbxfile.NewInstance("Part", model).SetName("Hidden")
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{workspace}}
+	workspace.Set("Kept", rbxfile.ValueReference{Instance: part})
+	workspace.Set("Skipped", rbxfile.ValueReference{Instance: model})
+	workspace.Set("Excluded", rbxfile.ValueReference{Instance: marker})
+
+	codec := RobloxCodec{InstanceFilter: func(inst *rbxfile.Instance) (skip, skipChildren bool) {
+		switch {
+		case inst.ClassName == "Terrain", inst.Name() == "Debug":
+			return true, true
+		case inst.ClassName == "Model":
+			// Flatten the model into its parent.
+			return true, false
+		case inst.Name() == "Part":
+			return false, true
+		}
+		return false, false
+	}}
+	doc, err := codec.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+
+	var names []string
+	var walk func(tags []*Tag, prefix string)
+	walk = func(tags []*Tag, prefix string) {
+		for _, tag := range tags {
+			if tag.StartName != "Item" {
+				continue
+			}
+			class, _ := tag.AttrValue("class")
+			names = append(names, prefix+class)
+			walk(tag.Tags, prefix+class+".")
+		}
+	}
+	walk(doc.Root.Tags, "")
+	expected := []string{"Workspace", "Workspace.Part", "Workspace.Part"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected instances:\n\texpected: %v\n\tgot:      %v", expected, names)
+	}
+	if len(workspace.Children) != 3 || len(model.Children) != 2 || len(part.Children) != 1 {
+		t.Errorf("expected tree to be unmodified")
+	}
+
+	// References to instances that are not written are encoded as null.
+	refs := map[string]string{}
+	for _, tag := range doc.Root.Tags {
+		if tag.StartName != "Item" {
+			continue
+		}
+		for _, props := range tag.Tags {
+			if props.StartName != "Properties" {
+				continue
+			}
+			for _, prop := range props.Tags {
+				if prop.StartName == "Ref" {
+					name, _ := prop.AttrValue("name")
+					refs[name] = prop.Text
+				}
+			}
+		}
+	}
+	if refs["Kept"] == "" || refs["Kept"] == "null" {
+		t.Errorf("expected reference to written instance, got %q", refs["Kept"])
+	}
+	for _, name := range []string{"Skipped", "Excluded"} {
+		if refs[name] != "null" {
+			t.Errorf("%s: expected null reference, got %q", name, refs[name])
+		}
+	}
+	if len(doc.Warnings) != 2 {
+		t.Errorf("expected warning for each excluded reference, got %v", doc.Warnings)
+	}
+}
+
+func TestDocument_Comments(t *testing.T) {
+	const document = `<roblox version="4">
+	<!-- The first part. -->
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">A</string>
+			<!--Kept for compatibility.-->
+		</Properties>
+	</Item>
+	<!-- The second part. -->
+	<!-- Multiple
+	lines. -->
+	<Item class="Part" referent="RBX1">
+		<Properties>
+			<string name="Name">B</string>
+		</Properties>
+	</Item>
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	items := doc.Root.Tags
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(items))
+	}
+	if !reflect.DeepEqual(items[0].Comments, []string{" The first part. "}) {
+		t.Errorf("unexpected comments %q", items[0].Comments)
+	}
+	if !reflect.DeepEqual(items[0].Tags[0].EndComments, []string{"Kept for compatibility."}) {
+		t.Errorf("unexpected end comments %q", items[0].Tags[0].EndComments)
+	}
+	if !reflect.DeepEqual(items[1].Comments, []string{" The second part. ", " Multiple\n\tlines. "}) {
+		t.Errorf("unexpected comments %q", items[1].Comments)
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	if buf.String() != document {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	root, err := RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	if len(root.Instances) != 2 || len(doc.Warnings) > 0 {
+		t.Errorf("expected comments to decode without warnings, got %v", doc.Warnings)
+	}
+
+	// Comments that cannot be written are ignored.
+	items[1].Comments = []string{"a--b"}
+	if _, err := doc.WriteTo(ioutil.Discard); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	if len(doc.Warnings) != 1 {
+		t.Errorf("expected warning for malformed comment, got %v", doc.Warnings)
+	}
+}
+
+func TestRobloxCodec_Comments(t *testing.T) {
+	const document = `<?xml version="1.0" encoding="utf-8"?>
+<roblox xmlns:xmime="http://www.w3.org/2005/05/xmlmime" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.roblox.com/roblox.xsd" version="4">
+	<!-- The first part. -->
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">A</string>
+		</Properties>
+		<Item class="Decal" referent="RBX1">
+			<Properties>
+				<string name="Name">Decal</string>
+			</Properties>
+		</Item>
+		<!-- After the decal. -->
+	</Item>
+	<!-- The second part. -->
+	<Item class="Part" referent="RBX2">
+		<Properties>
+			<string name="Name">B</string>
+		</Properties>
+	</Item>
+	<!-- The end. -->
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	codec := RobloxCodec{ExcludeExternal: true}
+	root, err := codec.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	if len(root.Instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(root.Instances))
+	}
+	if v := root.Instances[0].Comments; !reflect.DeepEqual(v, []string{" The first part. "}) {
+		t.Errorf("unexpected comments %q", v)
+	}
+	if v := root.Instances[0].EndComments; !reflect.DeepEqual(v, []string{" After the decal. "}) {
+		t.Errorf("unexpected end comments %q", v)
+	}
+	if v := root.Instances[1].Comments; !reflect.DeepEqual(v, []string{" The second part. "}) {
+		t.Errorf("unexpected comments %q", v)
+	}
+	if v := root.EndComments; !reflect.DeepEqual(v, []string{" The end. "}) {
+		t.Errorf("unexpected root end comments %q", v)
+	}
+	if v := root.ProcessingInstructions; !reflect.DeepEqual(v, []string{`xml version="1.0" encoding="utf-8"`}) {
+		t.Errorf("unexpected processing instructions %q", v)
+	}
+
+	out, err := codec.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := out.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	if buf.String() != document {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRobloxCodec_ShortestFloats(t *testing.T) {
+	inst := rbxfile.NewInstance("Part", nil)
+	inst.Set("Float", rbxfile.ValueFloat(0.1))
+	inst.Set("Double", rbxfile.ValueDouble(0.1))
+	inst.Set("Vector3", rbxfile.ValueVector3{X: 0.1, Y: 1, Z: 1e-7})
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}
+
+	for _, test := range []struct {
+		shortest bool
+		expected map[string]string
+	}{
+		{false, map[string]string{"Float": "0.100000001", "Double": "0.1", "X": "0.100000001", "Y": "1", "Z": "1.00000001e-007"}},
+		{true, map[string]string{"Float": "0.1", "Double": "0.1", "X": "0.1", "Y": "1", "Z": "1e-007"}},
+	} {
+		doc, err := RobloxCodec{ShortestFloats: test.shortest}.Encode(root)
+		if err != nil {
+			t.Fatalf("shortest %t: failed to encode: %s", test.shortest, err)
+		}
+		texts := map[string]string{}
+		for _, prop := range doc.Root.Tags[len(doc.Root.Tags)-1].Tags[0].Tags {
+			name, _ := prop.AttrValue("name")
+			texts[name] = prop.Text
+			for _, sub := range prop.Tags {
+				texts[sub.StartName] = sub.Text
+			}
+		}
+		delete(texts, "Vector3")
+		if !reflect.DeepEqual(texts, test.expected) {
+			t.Errorf("shortest %t: expected %v, got %v", test.shortest, test.expected, texts)
+		}
+
+		// Values decode to the same result.
+		decoded, err := RobloxCodec{}.Decode(doc)
+		if err != nil {
+			t.Fatalf("shortest %t: failed to decode: %s", test.shortest, err)
+		}
+		if !reflect.DeepEqual(decoded.Instances[0].Properties, inst.Properties) {
+			t.Errorf("shortest %t: values changed after decoding", test.shortest)
+		}
+	}
+}
+
+func TestRobloxCodec_Prolog(t *testing.T) {
+	// The first bytes of a place saved by Studio.
+	const studio = `<roblox xmlns:xmime="http://www.w3.org/2005/05/xmlmime" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.roblox.com/roblox.xsd" version="4">` + "\n\t<External>null</External>"
+
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{rbxfile.NewInstance("Part", nil)}}
+
+	encode := func(codec RobloxCodec) string {
+		doc, err := codec.Encode(root)
+		if err != nil {
+			t.Fatalf("failed to encode: %s", err)
+		}
+		var buf bytes.Buffer
+		if _, err := doc.WriteTo(&buf); err != nil {
+			t.Fatalf("failed to write document: %s", err)
+		}
+		return buf.String()
+	}
+
+	if s := encode(RobloxCodec{}); !strings.HasPrefix(s, studio) {
+		t.Errorf("output does not match Studio:\n%q", s[:len(studio)])
+	}
+	prolog := ByteOrderMark + XMLDeclaration
+	s := encode(RobloxCodec{Prolog: prolog})
+	if !strings.HasPrefix(s, prolog+studio) {
+		t.Errorf("unexpected prolog:\n%q", s[:len(prolog+studio)])
+	}
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(s)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	if doc.Prolog != prolog {
+		t.Errorf("expected prolog %q, got %q", prolog, doc.Prolog)
+	}
+	if doc.Prefix != "" || doc.Indent != "\t" {
+		t.Errorf("unexpected indentation %q, %q", doc.Prefix, doc.Indent)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write document: %s", err)
+	}
+	if buf.String() != s {
+		t.Errorf("document did not round-trip:\n%s", buf.String())
+	}
+
+	// Whitespace after the last newline remains the prefix.
+	const indented = "<?xml version=\"1.0\"?>\n  <roblox version=\"4\">\n  \t<External>null</External>\n  </roblox>"
+	if _, err := doc.ReadFrom(strings.NewReader(indented)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	if doc.Prolog != "<?xml version=\"1.0\"?>\n" || doc.Prefix != "  " {
+		t.Errorf("unexpected prolog %q and prefix %q", doc.Prolog, doc.Prefix)
+	}
+}
+
+func TestRobloxCodec_CanonicalReferents(t *testing.T) {
+	model := rbxfile.NewInstance("Model", nil)
+	model.Reference = "01234567-89ab-cdef-0123-456789abcdef"
+	part := rbxfile.NewInstance("Part", model)
+	part.Reference = "RBX0"
+	model.Set("PrimaryPart", rbxfile.ValueReference{Instance: part})
+	// Collides with the model after conversion.
+	other := rbxfile.NewInstance("Part", model)
+	other.Reference = "RBX0123456789ABCDEF0123456789ABCDEF"
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	doc, err := RobloxCodec{CanonicalReferents: true}.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	item := doc.Root.Tags[len(doc.Root.Tags)-1]
+	if ref, _ := item.AttrValue("referent"); ref != "RBX0123456789ABCDEF0123456789ABCDEF" {
+		t.Errorf("unexpected model referent %q", ref)
+	}
+	partRef, _ := item.Tags[1].AttrValue("referent")
+	if !isCanonicalReferent(partRef) {
+		t.Errorf("expected canonical part referent, got %q", partRef)
+	}
+	if ref, _ := item.Tags[2].AttrValue("referent"); !isCanonicalReferent(ref) || ref == "RBX0123456789ABCDEF0123456789ABCDEF" || ref == partRef {
+		t.Errorf("expected distinct canonical referent, got %q", ref)
+	}
+	if ref := item.Tags[0].Tags[0]; ref.StartName != "Ref" || ref.Text != partRef {
+		t.Errorf("expected reference to match part referent %q, got %q", partRef, ref.Text)
+	}
+	if model.Reference != "01234567-89ab-cdef-0123-456789abcdef" || part.Reference != "RBX0" {
+		t.Errorf("expected instance references to be unchanged")
+	}
+}
+
+func TestRobloxCodec_ReferentPrefix(t *testing.T) {
+	model := rbxfile.NewInstance("Model", nil)
+	model.Reference = ""
+	part := rbxfile.NewInstance("Part", model)
+	part.Reference = "RBX0"
+	// Duplicates the part.
+	other := rbxfile.NewInstance("Part", model)
+	other.Reference = "RBX0"
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	doc, err := RobloxCodec{ReferentPrefix: "RBXA"}.Encode(root)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	item := doc.Root.Tags[len(doc.Root.Tags)-1]
+	for i, tag := range []*Tag{item, item.Tags[2]} {
+		if ref, _ := tag.AttrValue("referent"); len(ref) != 36 || !strings.HasPrefix(ref, "RBXA") {
+			t.Errorf("expected prefixed referent for item %d, got %q", i, ref)
+		}
+	}
+	if ref, _ := item.Tags[1].AttrValue("referent"); ref != "RBX0" {
+		t.Errorf("expected existing referent to be unchanged, got %q", ref)
+	}
+}
+
+func TestRobloxCodec_EncodeNoProperties(t *testing.T) {
+	// Studio writes a Properties tag for every item, even when it is empty.
+	const expected = `<roblox xmlns:xmime="http://www.w3.org/2005/05/xmlmime" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.roblox.com/roblox.xsd" version="4">
+	<External>null</External>
+	<External>nil</External>
+	<Item class="Model" referent="RBX0">
+		<Properties></Properties>
+		<Item class="Folder" referent="RBX1">
+			<Properties></Properties>
+		</Item>
+	</Item>
+</roblox>`
+
+	model := rbxfile.NewInstance("Model", nil)
+	model.Reference = "RBX0"
+	rbxfile.NewInstance("Folder", model).Reference = "RBX1"
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	var buf bytes.Buffer
+	if err := Serialize(&buf, nil, root); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	decoded := decodeString(t, RobloxCodec{}, buf.String())
+	if len(decoded.Instances) != 1 || len(decoded.Instances[0].Properties) != 0 || len(decoded.Instances[0].Children) != 1 {
+		t.Errorf("unexpected decoded tree")
+	}
+}
+
+func TestRobloxCodec_DecodeEmptyTags(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="String"/>
+			<int name="Int"/>
+			<bool name="Bool"/>
+			<double name="Double"></double>
+			<token name="Token"/>
+			<Content name="Content"/>
+		</Properties>
+	</Item>
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["String"].(rbxfile.ValueString); !ok || len(v) != 0 {
+		t.Errorf("expected empty string, got %#v", props["String"])
+	}
+	expected := map[string]rbxfile.Value{
+		"Int":    rbxfile.ValueInt(0),
+		"Bool":   rbxfile.ValueBool(false),
+		"Double": rbxfile.ValueDouble(0),
+		"Token":  rbxfile.ValueToken(0),
+	}
+	for name, value := range expected {
+		if v := props[name]; v != value {
+			t.Errorf("%s: expected %#v, got %#v", name, value, v)
+		}
+	}
+	if v, ok := props["Content"].(rbxfile.ValueContent); !ok || len(v) != 0 {
+		t.Errorf("expected empty content, got %#v", props["Content"])
+	}
+	if len(doc.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", doc.Warnings)
+	}
+
+	// An empty token is validated against its enum like any other token.
+	api := &rbxapi.API{
+		Classes: map[string]*rbxapi.Class{
+			"Part": &rbxapi.Class{
+				Name: "Part",
+				Members: []rbxapi.Member{
+					&rbxapi.Property{MemberName: "Token", MemberClass: "Part", ValueType: "PartType"},
+				},
+			},
+		},
+		Enums: map[string]*rbxapi.Enum{
+			"PartType": &rbxapi.Enum{Name: "PartType", Items: []*rbxapi.EnumItem{{Name: "Block", Value: 1}}},
+		},
+	}
+	doc = new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	if root, err = (RobloxCodec{API: api, ExcludeInvalidAPI: true}).Decode(doc); err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	if v, ok := root.Instances[0].Properties["Token"]; ok {
+		t.Errorf("expected invalid empty token to be dropped, got %#v", v)
+	}
+	var warned bool
+	for _, w := range doc.Warnings {
+		warned = warned || w.Error() == "invalid item `0` for enum PartType"
+	}
+	if !warned {
+		t.Errorf("expected warning for invalid empty token, got %v", doc.Warnings)
+	}
+}
+
+func TestRobloxCodec_DroppedProperties(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">Part</string>
+			<Quaternion name="Orientation">1 0 0 0</Quaternion>
+			<int name="Count">not a number</int>
+		</Properties>
+		<Item class="Folder" referent="RBX1">
+			<Properties>
+				<Custom name="Data">x</Custom>
+			</Properties>
+		</Item>
+	</Item>
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	if n := len(root.Instances[0].Properties); n != 1 {
+		t.Errorf("expected 1 property, got %d", n)
+	}
+	expected := []DroppedProperty{
+		{Class: "Part", Property: "Orientation", Tag: "Quaternion"},
+		{Class: "Part", Property: "Count", Tag: "int"},
+		{Class: "Folder", Property: "Data", Tag: "Custom"},
+	}
+	if !reflect.DeepEqual(doc.DroppedProperties, expected) {
+		t.Errorf("unexpected dropped properties %v", doc.DroppedProperties)
+	}
+
+	// Cleared on each decode.
+	doc.Root.Tags = nil
+	if _, err := (RobloxCodec{}).Decode(doc); err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	if len(doc.DroppedProperties) != 0 {
+		t.Errorf("expected no dropped properties, got %v", doc.DroppedProperties)
+	}
+}
+
+func TestRobloxCodec_RawUnknownTypes(t *testing.T) {
+	const document = `<roblox xmlns:xmime="http://www.w3.org/2005/05/xmlmime" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.roblox.com/roblox.xsd" version="4">
+	<External>null</External>
+	<External>nil</External>
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">Part</string>
+			<Quaternion name="Orientation" space="local">
+				<W>1</W>
+				<X>0</X>
+				<Y>&lt;0&gt;</Y>
+				<Z></Z>
+			</Quaternion>
+		</Properties>
+	</Item>
+</roblox>`
+
+	codec := RobloxCodec{RawUnknownTypes: true}
+	root := decodeString(t, codec, document)
+	inst := root.Instances[0]
+	raw, ok := inst.Properties["Orientation"].(rbxfile.ValueRaw)
+	if !ok {
+		t.Fatalf("expected raw value, got %T", inst.Properties["Orientation"])
+	}
+	if raw.Format != "xml" {
+		t.Errorf("unexpected format %q", raw.Format)
+	}
+	// Known properties can be edited alongside the raw value.
+	inst.Properties["Name"] = rbxfile.ValueString("Edited")
+
+	var buf bytes.Buffer
+	if err := NewSerializer(codec, codec).Serialize(&buf, root); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	if expected := strings.Replace(document, ">Part<", ">Edited<", 1); buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	// Without the option, the property is dropped.
+	root = decodeString(t, RobloxCodec{}, document)
+	if _, ok := root.Instances[0].Properties["Orientation"]; ok {
+		t.Errorf("expected property to be dropped")
+	}
+}
+
+func TestRobloxCodec_MaxTags(t *testing.T) {
+	// Many small tags, which would each be allocated.
+	var b strings.Builder
+	b.WriteString(`<roblox version="4"><Item class="Folder" referent="RBX0"><Properties>`)
+	for i := 0; i < 1000; i++ {
+		b.WriteString(`<bool name="B">true</bool>`)
+	}
+	b.WriteString(`</Properties></Item></roblox>`)
+	document := b.String()
+
+	codec := RobloxCodec{MaxTags: 100}
+	var tagsErr ErrMaxTags
+	if _, err := NewSerializer(codec, codec).Deserialize(strings.NewReader(document)); !errors.As(err, &tagsErr) || tagsErr.Max != 100 {
+		t.Errorf("expected ErrMaxTags, got %v", err)
+	}
+	_, _, err := codec.DecodeStream(strings.NewReader(document), func(inst *rbxfile.Instance) error { return nil })
+	if err != (ErrMaxTags{Max: 100}) {
+		t.Errorf("expected ErrMaxTags from stream, got %v", err)
+	}
+
+	// Exactly at the budget.
+	codec.MaxTags = 1003
+	if _, err := NewSerializer(codec, codec).Deserialize(strings.NewReader(document)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRobloxCodec_MaxBytes(t *testing.T) {
+	// A single tag with a large amount of text.
+	document := `<roblox version="4"><Item class="StringValue" referent="RBX0"><Properties><string name="Value">` +
+		strings.Repeat("a", 1<<20) +
+		`</string></Properties></Item></roblox>`
+
+	codec := RobloxCodec{MaxTags: 10, MaxBytes: 1 << 10}
+	_, err := NewSerializer(codec, codec).Deserialize(strings.NewReader(document))
+	var bytesErr ErrMaxBytes
+	if !errors.As(err, &bytesErr) || bytesErr.Max != 1<<10 {
+		t.Errorf("expected ErrMaxBytes, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrLimit) {
+		t.Errorf("expected ErrLimit class, got %v", err)
+	}
+	_, _, err = codec.DecodeStream(strings.NewReader(document), func(inst *rbxfile.Instance) error { return nil })
+	if err != (ErrMaxBytes{Max: 1 << 10}) {
+		t.Errorf("expected ErrMaxBytes from stream, got %v", err)
+	}
+
+	// Exactly at the budget.
+	codec.MaxBytes = int64(len(document))
+	if _, err := NewSerializer(codec, codec).Deserialize(strings.NewReader(document)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestSerializer_DeserializeAll(t *testing.T) {
+	var buf bytes.Buffer
+	const n = 50
+	for i := 0; i < n; i++ {
+		inst := rbxfile.NewInstance("Part", nil)
+		inst.SetName(strconv.Itoa(i))
+		if err := Serialize(&buf, nil, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}); err != nil {
+			t.Fatalf("failed to serialize: %s", err)
+		}
+		buf.WriteString("\n\t ")
+	}
+
+	roots, err := NewSerializer(nil, nil).DeserializeAll(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(roots) != n {
+		t.Fatalf("expected %d roots, got %d", n, len(roots))
+	}
+	for i, root := range roots {
+		if len(root.Instances) != 1 || root.Instances[0].Name() != strconv.Itoa(i) {
+			t.Errorf("root %d: unexpected instances", i)
+		}
+	}
+
+	// Trailing data that is not a document.
+	buf.Reset()
+	if err := Serialize(&buf, nil, &rbxfile.Root{}); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	buf.WriteString("junk")
+	if roots, err := NewSerializer(nil, nil).DeserializeAll(&buf); err == nil || len(roots) != 1 {
+		t.Errorf("expected error after 1 root, got %d roots and %v", len(roots), err)
+	}
+}
+
+func TestRobloxCodec_CaseInsensitiveOrder(t *testing.T) {
+	inst := rbxfile.NewInstance("Part", nil)
+	for _, name := range []string{"BrickColor", "archivable", "Anchored", "Archivable", "name", "Color"} {
+		inst.Set(name, rbxfile.ValueBool(true))
+	}
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}
+
+	for _, test := range []struct {
+		codec    RobloxCodec
+		expected []string
+	}{
+		{RobloxCodec{}, []string{"Anchored", "Archivable", "BrickColor", "Color", "archivable", "name"}},
+		{RobloxCodec{CaseInsensitiveOrder: true}, []string{"Anchored", "Archivable", "archivable", "BrickColor", "Color", "name"}},
+	} {
+		doc, err := test.codec.Encode(root)
+		if err != nil {
+			t.Fatalf("failed to encode: %s", err)
+		}
+		var names []string
+		for _, tag := range doc.Root.Tags[len(doc.Root.Tags)-1].Tags[0].Tags {
+			name, _ := tag.AttrValue("name")
+			names = append(names, name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("CaseInsensitiveOrder=%t: expected order %v, got %v", test.codec.CaseInsensitiveOrder, test.expected, names)
+		}
+	}
+}
+
+func TestRobloxCodec_StudioPropertyOrder(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "studio", "*.rbx[lm]x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("no files saved by Studio in testdata/studio")
+	}
+	// Studio is expected to order properties as CaseInsensitiveOrder does.
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read fixture: %s", err)
+		}
+		doc := new(Document)
+		if _, err := doc.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: failed to read document: %s", file, err)
+		}
+		var walk func(tags []*Tag)
+		walk = func(tags []*Tag) {
+			for _, tag := range tags {
+				if tag.StartName == "Properties" {
+					var prev string
+					for i, prop := range tag.Tags {
+						name, _ := prop.AttrValue("name")
+						if i > 0 && !lessFold(prev, name) {
+							t.Errorf("%s: property %q sorted after %q", file, name, prev)
+						}
+						prev = name
+					}
+				}
+				walk(tag.Tags)
+			}
+		}
+		walk(doc.Root.Tags)
+	}
+}
+
+func TestRobloxCodec_DecodeScientificInt(t *testing.T) {
+	const document = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<int name="Int">1e3</int>
+			<int64 name="Int64">-2.5E1</int64>
+			<int name="Direct">42</int>
+			<int name="Overflow">1e10</int>
+			<int name="Invalid">1e</int>
+		</Properties>
+	</Item>
+</roblox>`
+
+	doc := new(Document)
+	if _, err := doc.ReadFrom(strings.NewReader(document)); err != nil {
+		t.Fatalf("failed to read document: %s", err)
+	}
+	root, err := RobloxCodec{}.Decode(doc)
+	if err != nil {
+		t.Fatalf("failed to decode document: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v := props["Int"]; v != rbxfile.ValueInt(1000) {
+		t.Errorf("expected int 1000, got %#v", v)
+	}
+	if v := props["Int64"]; v != rbxfile.ValueInt64(-25) {
+		t.Errorf("expected int64 -25, got %#v", v)
+	}
+	if v := props["Direct"]; v != rbxfile.ValueInt(42) {
+		t.Errorf("expected int 42, got %#v", v)
+	}
+	for _, name := range []string{"Overflow", "Invalid"} {
+		if v, ok := props[name]; ok {
+			t.Errorf("expected %s to be dropped, got %#v", name, v)
+		}
+	}
+	if len(doc.Warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %v", doc.Warnings)
+	}
+}
+
+func TestSerializer_DeserializeErrorClasses(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		codec    RobloxCodec
+		document string
+		class    error
+	}{
+		{"syntax", RobloxCodec{}, `<roblox version="4"><Item class=Folder></Item></roblox>`, rbxfile.ErrCorrupt},
+		{"version", RobloxCodec{}, `<roblox version="3"></roblox>`, rbxfile.ErrVersion},
+		{"truncated", RobloxCodec{}, `<roblox version="4"><Item class="Folder"`, rbxfile.ErrTruncated},
+	} {
+		_, err := NewSerializer(test.codec, test.codec).Deserialize(strings.NewReader(test.document))
+		var decodeErr *rbxfile.DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Op != "parsing document" {
+			t.Errorf("%s: expected DecodeError, got %v", test.name, err)
+		}
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected SyntaxError, got %v", test.name, err)
+		} else if syntaxErr.Class != test.class {
+			t.Errorf("%s: expected Class %q, got %v", test.name, test.class, syntaxErr.Class)
+		}
+		if !errors.Is(err, test.class) {
+			t.Errorf("%s: expected class %q, got %v", test.name, test.class, err)
+		}
+	}
+
+	_, err := NewSerializer(nil, nil).Deserialize(strings.NewReader(`<roblox version="4"><Item class="Folder"><`))
+	if !errors.Is(err, rbxfile.ErrTruncated) || !errors.Is(err, io.EOF) {
+		t.Errorf("expected ErrTruncated class for EOF, got %v", err)
+	}
+
+	document := `<roblox version="4"><Item class="Folder" referent="RBX0"></Item><Item class="Folder" referent="RBX1"></Item></roblox>`
+
+	codec := RobloxCodec{MaxTags: 2}
+	_, err = NewSerializer(codec, codec).Deserialize(strings.NewReader(document))
+	var tagsErr ErrMaxTags
+	if !errors.As(err, &tagsErr) || tagsErr.Max != 2 {
+		t.Errorf("expected ErrMaxTags, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrLimit) {
+		t.Errorf("expected ErrLimit class for tags, got %v", err)
+	}
+	var decodeErr *rbxfile.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Op != "parsing document" {
+		t.Errorf("expected DecodeError for tags, got %v", err)
+	}
+
+	codec = RobloxCodec{MaxInstances: 1}
+	_, err = NewSerializer(codec, codec).Deserialize(strings.NewReader(document))
+	var instancesErr rbxfile.ErrMaxInstances
+	if !errors.As(err, &instancesErr) || instancesErr.Max != 1 {
+		t.Errorf("expected ErrMaxInstances, got %v", err)
+	}
+	if !errors.Is(err, rbxfile.ErrLimit) {
+		t.Errorf("expected ErrLimit class for instances, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package xml
+
+import (
+	"encoding/csv"
+	"github.com/robloxapi/rbxfile"
+	"io"
+	"strings"
+)
+
+// ExportCSV writes to w the properties of each instance in the tree of root
+// that has the given class name, in CSV format. The first row is a header
+// containing "ClassName", followed by each name in props. Each following row
+// corresponds to an instance, in pre-order.
+//
+// Each property is formatted as it would be when encoded by a RobloxCodec.
+// Values with multiple components, such as a Vector3, have each component
+// separated by a comma and a space. Properties that are missing or cannot be
+// encoded are written as empty cells.
+func ExportCSV(root *rbxfile.Root, className string, props []string, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"ClassName"}, props...)); err != nil {
+		return err
+	}
+
+	enc := &rencoder{codec: RobloxCodec{}}
+	row := make([]string, len(props)+1)
+	var walk func(instances []*rbxfile.Instance) error
+	walk = func(instances []*rbxfile.Instance) error {
+		for _, inst := range instances {
+			if inst == nil {
+				continue
+			}
+			if inst.ClassName == className {
+				row[0] = inst.ClassName
+				for i, name := range props {
+					row[i+1] = ""
+					if value, ok := inst.Properties[name]; ok {
+						if tag := enc.encodeProperty(inst.ClassName, name, value); tag != nil {
+							row[i+1] = csvCell(tag)
+						}
+					}
+				}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+			if err := walk(inst.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if root != nil {
+		if err := walk(root.Instances); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell formats an encoded property tag as a single cell.
+func csvCell(tag *Tag) string {
+	if len(tag.Tags) == 0 {
+		return getContent(tag)
+	}
+	cells := make([]string, len(tag.Tags))
+	for i, sub := range tag.Tags {
+		cells[i] = csvCell(sub)
+	}
+	return strings.Join(cells, ", ")
+}
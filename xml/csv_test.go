@@ -0,0 +1,48 @@
+package xml
+
+import (
+	"bytes"
+	"github.com/robloxapi/rbxfile"
+	"testing"
+)
+
+func TestExportCSV(t *testing.T) {
+	model := rbxfile.NewInstance("Model", nil)
+	a := rbxfile.NewInstance("Part", model)
+	a.SetName("A")
+	a.Set("Position", rbxfile.ValueVector3{X: 1, Y: 2.5, Z: -3})
+	a.Set("Size", rbxfile.ValueVector3{X: 4, Y: 1.2, Z: 2})
+	rbxfile.NewInstance("Folder", a)
+	b := rbxfile.NewInstance("Part", model)
+	b.SetName("B, \"quoted\"")
+	b.Set("Anchored", rbxfile.ValueBool(true))
+
+	var buf bytes.Buffer
+	err := ExportCSV(&rbxfile.Root{Instances: []*rbxfile.Instance{model}}, "Part", []string{"Name", "Position", "Size", "Anchored"}, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	const expected = "ClassName,Name,Position,Size,Anchored\n" +
+		"Part,A,\"1, 2.5, -3\",\"4, 1.20000005, 2\",\n" +
+		"Part,\"B, \"\"quoted\"\"\",,,true\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n\texpected: %q\n\tgot:      %q", expected, buf.String())
+	}
+}
+
+func TestExportCSV_InvalidRaw(t *testing.T) {
+	part := rbxfile.NewInstance("Part", nil)
+	part.SetName("A")
+	part.Set("Raw", rbxfile.ValueRaw{Format: "xml", Data: []byte("<unterminated")})
+
+	var buf bytes.Buffer
+	err := ExportCSV(&rbxfile.Root{Instances: []*rbxfile.Instance{part}}, "Part", []string{"Name", "Raw"}, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	const expected = "ClassName,Name,Raw\nPart,A,\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n\texpected: %q\n\tgot:      %q", expected, buf.String())
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"github.com/robloxapi/rbxfile"
 	"io"
 	"strconv"
+	"strings"
 )
 
 // Tag represents a Roblox XML tag construct. Unlike standard XML, the content
@@ -59,6 +61,15 @@ type Tag struct {
 
 	// Tags is a list of child tags within the tag.
 	Tags []*Tag
+
+	// Comments is a list of comments that appear directly before the tag,
+	// within its parent. Each comment is the text between "<!--" and
+	// "-->".
+	Comments []string
+
+	// EndComments is a list of comments that appear after the last child
+	// tag, directly before the end tag.
+	EndComments []string
 }
 
 // AttrValue returns the value of the first attribute of the given name, and
@@ -82,7 +93,7 @@ func (t *Tag) SetAttrValue(name, value string) {
 			if value == "" {
 				t.Attr = append(t.Attr[:i], t.Attr[i+1:]...)
 			} else {
-				a.Value = value
+				t.Attr[i].Value = value
 			}
 			return
 		}
@@ -159,8 +170,26 @@ type Attr struct {
 
 ////////////////////////////////////////////////////////////////
 
+// XMLDeclaration is a standard XML declaration, which may be used as the
+// Prolog of a Document. Studio does not write a declaration.
+const XMLDeclaration = "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"
+
+// ByteOrderMark is the UTF-8 encoding of the byte order mark, which may
+// appear at the start of a Prolog.
+const ByteOrderMark = "\xEF\xBB\xBF"
+
 // Document represents an entire XML document.
 type Document struct {
+	// Prolog is a string that appears at the very start of the document,
+	// before the Prefix and the root tag, such as a byte order mark or an
+	// XML declaration. Studio writes neither, so this is empty by default.
+	//
+	// When encoding, this string is written as-is. When decoding, this value
+	// becomes any byte order mark, and any processing instructions such as
+	// an XML declaration, that appear before the root tag, along with the
+	// whitespace between them, up to and including the last newline.
+	Prolog string
+
 	// Prefix is a string that appears at the start of each line in the
 	// document.
 	//
@@ -202,18 +231,98 @@ type Document struct {
 	// be cleared and populated when calling either ReadFrom and WriteTo.
 	// Codecs may also clear and populate this when decoding or encoding.
 	Warnings []error
+
+	// UnknownClasses is the set of class names that were found while
+	// decoding, but were not present in the API given to the codec. This is
+	// populated by codecs when decoding, and only if an API is given.
+	UnknownClasses map[string]bool
+
+	// DroppedProperties is a list of properties that were found while
+	// decoding, but were excluded because their values could not be
+	// decoded, such as when the type of the property is not supported. This
+	// is populated by codecs when decoding.
+	DroppedProperties []DroppedProperty
+
+	// MaxTags is the maximum number of tags that may be read when decoding,
+	// including the root tag, and tags streamed by ReadStreamFrom. If
+	// exceeded, decoding is aborted, and an ErrMaxTags is returned. If zero
+	// or less, then the number of tags is not limited.
+	MaxTags int
+
+	// MaxBytes is the maximum number of bytes that may be read when
+	// decoding. If exceeded, decoding is aborted, and an ErrMaxBytes is
+	// returned. A single tag may hold any amount of text or attributes, so
+	// MaxBytes, rather than MaxTags, bounds the memory used to decode
+	// untrusted input. If zero or less, then the number of bytes is not
+	// limited.
+	MaxBytes int64
+}
+
+// ErrMaxTags is returned when decoding a Document would read more tags than
+// Document.MaxTags. It belongs to the rbxfile.ErrLimit class.
+type ErrMaxTags struct {
+	// Max is the maximum number of tags that was exceeded.
+	Max int
+}
+
+func (err ErrMaxTags) Error() string {
+	return "number of tags exceeds maximum of " + strconv.Itoa(err.Max)
+}
+
+func (err ErrMaxTags) Is(target error) bool {
+	return target == rbxfile.ErrLimit
+}
+
+// ErrMaxBytes is returned when decoding a Document would read more bytes than
+// Document.MaxBytes. It belongs to the rbxfile.ErrLimit class.
+type ErrMaxBytes struct {
+	// Max is the maximum number of bytes that was exceeded.
+	Max int64
+}
+
+func (err ErrMaxBytes) Error() string {
+	return "size of document exceeds maximum of " + strconv.FormatInt(err.Max, 10) + " bytes"
+}
+
+func (err ErrMaxBytes) Is(target error) bool {
+	return target == rbxfile.ErrLimit
+}
+
+// DroppedProperty describes a property that was excluded while decoding.
+type DroppedProperty struct {
+	// Class is the class name of the instance containing the property.
+	Class string
+	// Property is the name of the property.
+	Property string
+	// Tag is the name of the tag of the property, which usually indicates
+	// its type.
+	Tag string
 }
 
 // A SyntaxError represents a syntax error in the XML input stream.
 type SyntaxError struct {
 	Msg  string
 	Line int
+
+	// Class is the class of decoding errors to which the error belongs:
+	// rbxfile.ErrTruncated if the input ended unexpectedly,
+	// rbxfile.ErrVersion if the version of the document is not supported,
+	// and rbxfile.ErrCorrupt otherwise. If nil, the error belongs to
+	// rbxfile.ErrCorrupt.
+	Class error
 }
 
 func (e *SyntaxError) Error() string {
 	return "XML syntax error on line " + strconv.Itoa(e.Line) + ": " + e.Msg
 }
 
+func (e *SyntaxError) Is(target error) bool {
+	if e.Class == nil {
+		return target == rbxfile.ErrCorrupt
+	}
+	return target == e.Class
+}
+
 type decoder struct {
 	r        io.ByteReader
 	buf      bytes.Buffer
@@ -222,11 +331,21 @@ type decoder struct {
 	n        int64
 	err      error
 	line     int
+	tags     int
+
+	// stream, if not nil, receives each Item tag under the root tag, instead
+	// of the tag being added to the root.
+	stream func(tag *Tag) error
 }
 
 // Creates a SyntaxError with the current line number.
 func (d *decoder) syntaxError(msg string) error {
-	return &SyntaxError{Msg: msg, Line: d.line}
+	return d.syntaxErrorOf(rbxfile.ErrCorrupt, msg)
+}
+
+// syntaxErrorOf returns a SyntaxError that belongs to the given class.
+func (d *decoder) syntaxErrorOf(class error, msg string) error {
+	return &SyntaxError{Msg: msg, Line: d.line, Class: class}
 }
 
 func (d *decoder) ignoreStartTag(err error) int {
@@ -402,6 +521,12 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 		return nil, d.err
 	}
 
+	d.tags++
+	if max := d.doc.MaxTags; max > 0 && d.tags > max {
+		d.err = ErrMaxTags{Max: max}
+		return nil, d.err
+	}
+
 	tag = new(Tag)
 	noindent := false
 	nocontent := true
@@ -422,6 +547,9 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 	}
 
 	if root {
+		// Make the root available while its children are being streamed.
+		d.doc.Root = tag
+
 		if tag.StartName != "roblox" {
 			d.err = d.syntaxError("no roblox tag")
 			return nil, d.err
@@ -438,7 +566,7 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 				return nil, d.err
 			}
 			if n < 4 {
-				d.err = d.syntaxError("schemaVersionLoading<4")
+				d.err = d.syntaxErrorOf(rbxfile.ErrVersion, "schemaVersionLoading<4")
 				return nil, d.err
 			}
 		}
@@ -493,6 +621,8 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 		nocontent = false
 	}
 
+	// Comments to be attached to the next child tag.
+	var comments []string
 	for {
 		// prettifying whitespace between tags
 		d.space()
@@ -510,6 +640,16 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 		if b, ok = d.mustgetc(); !ok {
 			return nil, d.err
 		}
+		if b == '!' {
+			comment, ok := d.decodeComment()
+			if d.err != nil {
+				return nil, d.err
+			}
+			if ok {
+				comments = append(comments, comment)
+				continue
+			}
+		}
 		if b == '/' {
 			// </: End element
 			d.ungetc('/')
@@ -519,6 +659,7 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 				return nil, d.err
 			}
 
+			tag.EndComments = comments
 			break
 		}
 
@@ -530,9 +671,19 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 		if err != nil {
 			return nil, err
 		}
-		if subtag != nil {
-			tag.Tags = append(tag.Tags, subtag)
+		if subtag == nil {
+			comments = nil
+			continue
+		}
+		subtag.Comments, comments = comments, nil
+		if root && d.stream != nil && subtag.StartName == "Item" {
+			if err := d.stream(subtag); err != nil {
+				d.err = err
+				return nil, err
+			}
+			continue
 		}
+		tag.Tags = append(tag.Tags, subtag)
 	}
 	if len(tag.Tags) > 0 {
 		nocontent = false
@@ -551,6 +702,109 @@ func (d *decoder) decodeTag(root bool) (tag *Tag, err error) {
 	return tag, nil
 }
 
+// decodeComment reads a comment, after "<!" has been read. If the following
+// characters are not "--", they are unread, and false is returned.
+func (d *decoder) decodeComment() (comment string, ok bool) {
+	for i := 0; i < 2; i++ {
+		b, ok := d.mustgetc()
+		if !ok {
+			return "", false
+		}
+		if b != '-' {
+			d.ungetc(b)
+			for ; i > 0; i-- {
+				d.ungetc('-')
+			}
+			return "", false
+		}
+	}
+
+	// Have <!--. Read text until -->.
+	d.buf.Reset()
+	for {
+		b, ok := d.mustgetc()
+		if !ok {
+			return "", false
+		}
+		d.buf.WriteByte(b)
+		if b == '>' && bytes.HasSuffix(d.buf.Bytes(), []byte("-->")) {
+			return string(d.buf.Bytes()[:d.buf.Len()-3]), true
+		}
+	}
+}
+
+// decodeProlog reads an optional byte order mark, followed by any processing
+// instructions, into the Prolog of the document. Whitespace following the
+// last newline of the prolog is unread, so that it may be detected as the
+// Prefix.
+func (d *decoder) decodeProlog() {
+	var prolog []byte
+	for i := 0; i < len(ByteOrderMark); i++ {
+		b, ok := d.getc()
+		if !ok {
+			return
+		}
+		if b != ByteOrderMark[i] {
+			d.ungetc(b)
+			for ; i > 0; i-- {
+				d.ungetc(ByteOrderMark[i-1])
+			}
+			break
+		}
+		if i == len(ByteOrderMark)-1 {
+			prolog = append(prolog, ByteOrderMark...)
+		}
+	}
+
+	unget := func(b []byte) {
+		for i := len(b) - 1; i >= 0; i-- {
+			d.ungetc(b[i])
+		}
+	}
+	for {
+		space := append([]byte(nil), d.readSpace()...)
+		b, ok := d.getc()
+		if !ok {
+			break
+		}
+		if b != '<' {
+			unget(append(space, b))
+			break
+		}
+		if b, ok = d.getc(); !ok || b != '?' {
+			if ok {
+				d.ungetc(b)
+			}
+			unget(append(space, '<'))
+			break
+		}
+
+		// Have <?. Read text until ?>.
+		prolog = append(prolog, space...)
+		start := len(prolog)
+		prolog = append(prolog, '<', '?')
+		for {
+			b, ok := d.mustgetc()
+			if !ok {
+				return
+			}
+			prolog = append(prolog, b)
+			if b == '>' && len(prolog)-start >= 4 && prolog[len(prolog)-2] == '?' {
+				break
+			}
+		}
+	}
+	d.doc.Prolog = string(prolog)
+
+	if len(prolog) > 0 {
+		// Keep trailing whitespace up to the last newline.
+		space := append([]byte(nil), d.readSpace()...)
+		i := bytes.LastIndexByte(space, '\n') + 1
+		d.doc.Prolog += string(space[:i])
+		unget(space[i:])
+	}
+}
+
 func (d *decoder) attrval() []byte {
 	b, ok := d.mustgetc()
 	if !ok {
@@ -621,6 +875,10 @@ func (d *decoder) getc() (b byte, ok bool) {
 			return 0, false
 		}
 		d.n++
+		if max := d.doc.MaxBytes; max > 0 && d.n > max {
+			d.err = ErrMaxBytes{Max: max}
+			return 0, false
+		}
 	}
 	if b == '\n' {
 		d.line++
@@ -636,7 +894,7 @@ func (d *decoder) getc() (b byte, ok bool) {
 func (d *decoder) mustgetc() (b byte, ok bool) {
 	if b, ok = d.getc(); !ok {
 		if d.err == io.EOF {
-			d.err = d.syntaxError("unexpected EOF")
+			d.err = d.syntaxErrorOf(rbxfile.ErrTruncated, "unexpected EOF")
 		}
 	}
 	return
@@ -672,7 +930,7 @@ Input:
 		if !ok {
 			if cdata {
 				if d.err == io.EOF {
-					d.err = d.syntaxError("unexpected EOF in CDATA section")
+					d.err = d.syntaxErrorOf(rbxfile.ErrTruncated, "unexpected EOF in CDATA section")
 				}
 				return nil
 			}
@@ -876,10 +1134,39 @@ func isNameByte(c byte, t int) bool {
 
 // ReadFrom decode data from r into the Document.
 func (doc *Document) ReadFrom(r io.Reader) (n int64, err error) {
+	return doc.readFrom(r, nil, true)
+}
+
+// ReadStreamFrom decodes data from r into the Document, except that each Item
+// tag directly under the root tag is passed to fn as soon as it has been fully
+// read, rather than being added to the root. This allows large documents to
+// be processed without holding every tag in memory at once. Other tags under
+// the root, such as External tags, are added to the root as usual, and the
+// root is available through doc.Root while fn is being called.
+//
+// If fn returns an error, then decoding stops, and the error is returned.
+func (doc *Document) ReadStreamFrom(r io.Reader, fn func(item *Tag) error) (n int64, err error) {
+	if fn == nil {
+		return 0, errors.New("function is nil")
+	}
+	return doc.readFrom(r, fn, true)
+}
+
+// readNext decodes a single document from r into doc, leaving the data after
+// the root tag unread, so that it may be read as the next document. The Suffix
+// of doc contains only the bytes that were read ahead of the root tag.
+func (doc *Document) readNext(r *bufio.Reader) (n int64, err error) {
+	return doc.readFrom(r, nil, false)
+}
+
+// readFrom decodes a document from r. If suffix is true, then all data after
+// the root tag is read into the Suffix of the document.
+func (doc *Document) readFrom(r io.Reader, stream func(tag *Tag) error, suffix bool) (n int64, err error) {
 	if r == nil {
 		return 0, errors.New("reader is nil")
 	}
 
+	doc.Prolog = ""
 	doc.Prefix = ""
 	doc.Indent = ""
 	doc.Warnings = doc.Warnings[:0]
@@ -888,6 +1175,7 @@ func (doc *Document) ReadFrom(r io.Reader) (n int64, err error) {
 		doc:      doc,
 		nextByte: make([]byte, 0, 9),
 		line:     1,
+		stream:   stream,
 	}
 	if rb, ok := r.(io.ByteReader); ok {
 		d.r = rb
@@ -895,13 +1183,14 @@ func (doc *Document) ReadFrom(r io.Reader) (n int64, err error) {
 		d.r = bufio.NewReader(r)
 	}
 
+	d.decodeProlog()
 	doc.Root, err = d.decodeTag(true)
 	if err != nil {
 		return d.n, err
 	}
 
 	d.buf.Reset()
-	for {
+	for suffix || len(d.nextByte) > 0 {
 		b, ok := d.getc()
 		if !ok {
 			break
@@ -945,6 +1234,19 @@ func (e *encoder) encodeText(tag *Tag) bool {
 	return true
 }
 
+// encodeComment writes a comment. A comment containing "--", or ending with
+// "-", cannot be written, and is ignored with a warning.
+func (e *encoder) encodeComment(comment string) bool {
+	if strings.Contains(comment, "--") || strings.HasSuffix(comment, "-") {
+		e.d.Warnings = append(e.d.Warnings, errors.New("ignored malformed comment `"+comment+"`"))
+		return false
+	}
+	e.writeString("<!--")
+	e.writeString(comment)
+	e.writeString("-->")
+	return e.flush()
+}
+
 func (e *encoder) checkName(name string, typ int) bool {
 	if len(name) == 0 {
 		return false
@@ -1024,12 +1326,31 @@ func (e *encoder) encodeTag(tag *Tag, noTags bool, noindent bool) int {
 		return -1
 	}
 
+	if len(tag.Tags) == 0 {
+		for _, comment := range tag.EndComments {
+			e.encodeComment(comment)
+		}
+	}
+
 	for i, sub := range tag.Tags {
+		for _, comment := range sub.Comments {
+			if e.encodeComment(comment) && !noindent && !tag.NoIndent {
+				e.writeIndent(0, false)
+			}
+		}
 		if r := e.encodeTag(sub, false, noindent || tag.NoIndent); r < 0 {
 			return -1
 		} else if r == 0 {
 			continue
 		}
+		if i == len(tag.Tags)-1 {
+			for _, comment := range tag.EndComments {
+				if !noindent && !tag.NoIndent {
+					e.writeIndent(0, false)
+				}
+				e.encodeComment(comment)
+			}
+		}
 		if !noindent && !tag.NoIndent {
 			if i == len(tag.Tags)-1 {
 				if noTags {
@@ -1171,7 +1492,9 @@ func (e *encoder) escapeString(s string, escapeLead bool) {
 		case '>':
 			esc = esc_gt
 		default:
-			if ' ' <= b && b <= '~' || b == '\n' || b == '\r' {
+			// Carriage returns are escaped, since a decoder normalizes
+			// literal ones into newlines.
+			if ' ' <= b && b <= '~' || b == '\n' {
 				// literal
 				continue
 			} else {
@@ -1202,6 +1525,7 @@ func (d *Document) WriteTo(w io.Writer) (n int64, err error) {
 
 	e := &encoder{Writer: bufio.NewWriter(w), d: d}
 
+	e.writeString(e.d.Prolog)
 	e.writeString(e.d.Prefix)
 
 	if r := e.encodeTag(d.Root, d.ExcludeRoot, d.Root.NoIndent); r < 0 {
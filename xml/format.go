@@ -1,10 +1,12 @@
 package xml
 
 import (
+	"bufio"
 	"errors"
 	"github.com/robloxapi/rbxapi"
 	"github.com/robloxapi/rbxfile"
 	"io"
+	"strings"
 )
 
 // Decoder decodes a Document to a generic rbxfile.Root structure.
@@ -47,6 +49,28 @@ func NewSerializer(d Decoder, e Encoder) Serializer {
 	return s
 }
 
+// newDocument returns an empty Document, with the limits of the decoder, if
+// it is a RobloxCodec.
+func (s Serializer) newDocument() *Document {
+	switch codec := s.Decoder.(type) {
+	case RobloxCodec:
+		return &Document{MaxTags: codec.MaxTags, MaxBytes: codec.MaxBytes}
+	case *RobloxCodec:
+		return &Document{MaxTags: codec.MaxTags, MaxBytes: codec.MaxBytes}
+	}
+	return new(Document)
+}
+
+// parseError wraps err, which occurred while reading a Document, in a
+// rbxfile.DecodeError. An unexpected end of data is classified as
+// rbxfile.ErrTruncated.
+func parseError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = &rbxfile.ClassError{Class: rbxfile.ErrTruncated, Err: err}
+	}
+	return &rbxfile.DecodeError{Op: "parsing document", Err: err}
+}
+
 // Deserialize decodes data from r into a Root structure using the specified
 // decoder.
 func (s Serializer) Deserialize(r io.Reader) (root *rbxfile.Root, err error) {
@@ -54,20 +78,77 @@ func (s Serializer) Deserialize(r io.Reader) (root *rbxfile.Root, err error) {
 		return nil, errors.New("a decoder has not been not specified")
 	}
 
-	document := new(Document)
+	document := s.newDocument()
 
 	if _, err = document.ReadFrom(r); err != nil {
-		return nil, errors.New("error parsing document: " + err.Error())
+		return nil, parseError(err)
 	}
 
 	root, err = s.Decoder.Decode(document)
 	if err != nil {
-		return nil, errors.New("error decoding data: " + err.Error())
+		return nil, &rbxfile.DecodeError{Op: "decoding data", Err: err}
 	}
 
 	return root, nil
 }
 
+// DeserializeAll decodes a sequence of concatenated documents from r into a
+// Root structure for each document, using the specified decoder. Documents
+// may be separated by whitespace. The roots decoded before an error occurs
+// are returned along with the error.
+func (s Serializer) DeserializeAll(r io.Reader) (roots []*rbxfile.Root, err error) {
+	if s.Decoder == nil {
+		return nil, errors.New("a decoder has not been not specified")
+	}
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	for {
+		document := s.newDocument()
+
+		// Each document is read from the same reader, which is left at the
+		// end of the root tag.
+		if _, err = document.readNext(br); err != nil {
+			return roots, parseError(err)
+		}
+
+		root, err := s.Decoder.Decode(document)
+		if err != nil {
+			return roots, &rbxfile.DecodeError{Op: "decoding data", Err: err}
+		}
+		roots = append(roots, root)
+
+		if document.Suffix != "" {
+			// Data read ahead of the root tag begins the next document.
+			br = bufio.NewReader(io.MultiReader(strings.NewReader(document.Suffix), br))
+		}
+		if more, err := skipSpace(br); err != nil {
+			return roots, parseError(err)
+		} else if !more {
+			return roots, nil
+		}
+	}
+}
+
+// skipSpace reads whitespace from br. Returns whether any data follows.
+func skipSpace(br *bufio.Reader) (more bool, err error) {
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return true, br.UnreadByte()
+	}
+}
+
 // Serialize encodes data from a Root structure to w using the specified
 // encoder.
 func (s Serializer) Serialize(w io.Writer, root *rbxfile.Root) (err error) {
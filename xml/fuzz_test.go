@@ -0,0 +1,62 @@
+//go:build go1.18
+// +build go1.18
+
+package xml
+
+import (
+	"bytes"
+	"github.com/robloxapi/rbxfile"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzRobloxCodec_Decode checks that parsing and decoding an arbitrary
+// document, and re-encoding the result, does not panic. Errors are expected.
+func FuzzRobloxCodec_Decode(f *testing.F) {
+	// Seed with a document encoded from a tree containing each value type.
+	inst := rbxfile.NewInstance("Part", nil)
+	for typ := rbxfile.TypeInvalid + 1; rbxfile.NewValue(typ) != nil; typ++ {
+		inst.Set(typ.String(), rbxfile.NewValue(typ))
+	}
+	rbxfile.NewInstance("Decal", inst)
+	doc, err := RobloxCodec{}.Encode(&rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	if err != nil {
+		f.Fatalf("failed to encode seed: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		f.Fatalf("failed to write seed: %s", err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte(`<roblox version="4">
+	<External>null</External>
+	<SharedStrings><SharedString md5="AAAAAAAAAAAAAAAAAAAAAA==">AAAA</SharedString></SharedStrings>
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">Part</string>
+			<CoordinateFrame name="CFrame"><X>1</X><Y>2</Y><Z>3</Z><R00>1</R00><R11>1</R11><R22>1</R22></CoordinateFrame>
+			<Ref name="Ref">RBX0</Ref>
+			<SharedString name="Data">AAAAAAAAAAAAAAAAAAAAAA==</SharedString>
+			<BinaryString name="Tags">AAAA</BinaryString>
+			<PhysicalProperties name="CustomPhysicalProperties"></PhysicalProperties>
+		</Properties>
+		<Item class="Decal" referent="RBX1"/>
+	</Item>
+</roblox>`))
+	f.Add([]byte(``))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		doc := new(Document)
+		if _, err := doc.ReadFrom(bytes.NewReader(b)); err != nil {
+			return
+		}
+		root, err := RobloxCodec{}.Decode(doc)
+		if err != nil {
+			return
+		}
+		if doc, err = (RobloxCodec{}).Encode(root); err != nil {
+			return
+		}
+		doc.WriteTo(ioutil.Discard)
+	})
+}